@@ -0,0 +1,18 @@
+package broker
+
+// Event -> One fan-out event, every instance subscribed to the broker receives it. What the fields mean
+// depends on Type, the broker only carries them
+type Event struct {
+	Type          string `bson:"type"`
+	Room          string `bson:"room,omitempty"`
+	UserId        string `bson:"user_id,omitempty"`
+	ExcludeConnId string `bson:"exclude_conn_id,omitempty"`
+	Payload       []byte `bson:"payload,omitempty"`
+}
+
+// Broker -> Fans events out to every subscribed instance, the publishing one included
+type Broker interface {
+	Publish(event Event) error
+	// Subscribe calls handler for every event published after it returns, until unsubscribe is called
+	Subscribe(handler func(Event)) (unsubscribe func(), err error)
+}
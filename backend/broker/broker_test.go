@@ -0,0 +1,54 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	memory := NewMemory()
+
+	var first, second []Event
+	unsubscribeFirst, _ := memory.Subscribe(func(event Event) { first = append(first, event) })
+	memory.Subscribe(func(event Event) { second = append(second, event) })
+
+	memory.Publish(Event{Type: "frame", Room: "chat:1", Payload: []byte("hi")})
+
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("Expected every subscriber to get the event, got %d and %d", len(first), len(second))
+	}
+
+	if first[0].Room != "chat:1" || string(first[0].Payload) != "hi" {
+		t.Errorf("Expected the published event, got %+v", first[0])
+	}
+
+	unsubscribeFirst()
+	memory.Publish(Event{Type: "frame", Room: "chat:1"})
+
+	if len(first) != 1 {
+		t.Errorf("Expected no events after unsubscribing, got %d", len(first))
+	}
+
+	if len(second) != 2 {
+		t.Errorf("Expected the other subscriber to keep getting events, got %d", len(second))
+	}
+}
+
+func TestMemory_PublishFromHandler(t *testing.T) {
+	memory := NewMemory()
+
+	var received []string
+	memory.Subscribe(func(event Event) {
+		received = append(received, event.Type)
+
+		// must not deadlock
+		if event.Type == "first" {
+			memory.Publish(Event{Type: "second"})
+		}
+	})
+
+	memory.Publish(Event{Type: "first"})
+
+	if len(received) != 2 || received[1] != "second" {
+		t.Errorf("Expected first and second, got %v", received)
+	}
+}
@@ -0,0 +1,50 @@
+package broker
+
+import "sync"
+
+// Memory -> Broker of a single instance. Publish calls the subscribers before it returns
+type Memory struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	nextId      int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		subscribers: make(map[int]func(Event)),
+	}
+}
+
+func (memory *Memory) Publish(event Event) error {
+	memory.mu.RLock()
+	handlers := make([]func(Event), 0, len(memory.subscribers))
+	for _, handler := range memory.subscribers {
+		handlers = append(handlers, handler)
+	}
+	memory.mu.RUnlock()
+
+	// called without the lock, a handler may publish again
+	for _, handler := range handlers {
+		handler(event)
+	}
+
+	return nil
+}
+
+func (memory *Memory) Subscribe(handler func(Event)) (func(), error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	id := memory.nextId
+	memory.nextId++
+	memory.subscribers[id] = handler
+
+	unsubscribe := func() {
+		memory.mu.Lock()
+		defer memory.mu.Unlock()
+
+		delete(memory.subscribers, id)
+	}
+
+	return unsubscribe, nil
+}
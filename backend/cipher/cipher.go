@@ -4,57 +4,210 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"golang.org/x/crypto/chacha20poly1305"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
+const (
+	// versioned ciphertext: formatVersioned | key version (uint32) | nonce | sealed text
+	formatVersioned byte = 0xc1
+	headerSize           = 1 + 4
+	nonceSize            = chacha20poly1305.NonceSize
+
+	// LegacyKeyVersion -> Version of ENCRYPTION_SECRET_KEY. Ciphertexts without a header were made with it
+	LegacyKeyVersion uint32 = 1
+)
+
+var ErrUnknownKeyVersion = errors.New("ciphertext key version is unknown")
+
 type Cipher struct {
-	Aead cipher.AEAD
+	// Aead is the newest key, every new ciphertext uses it
+	Aead    cipher.AEAD
+	version uint32
+	keys    map[uint32]cipher.AEAD
+
+	// envelope encryption, see UseKeyProvider
+	provider      KeyProvider
+	dataKeys      DataKeyStore
+	dataKeysMutex sync.Mutex
+	cachedKeys    map[string]cipher.AEAD
 }
 
+// New -> Cipher with the keys of ENCRYPTION_SECRET_KEY (version 1) and ENCRYPTION_KEYS ("2=secret,3=secret"),
+// and the key provider of ENCRYPTION_KEY_PROVIDER. With a provider the static keys are optional
 func New() *Cipher {
-	secretKey := os.Getenv("ENCRYPTION_SECRET_KEY")
-	if secretKey == "" {
-		panic("ENCRYPTION_SECRET_KEY env var is empty")
+	secrets := make(map[uint32]string)
+
+	if secretKey := os.Getenv("ENCRYPTION_SECRET_KEY"); secretKey != "" {
+		secrets[LegacyKeyVersion] = secretKey
+	}
+
+	for _, item := range strings.Split(os.Getenv("ENCRYPTION_KEYS"), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		version, secret, found := strings.Cut(item, "=")
+		parsedVersion, err := strconv.ParseUint(strings.TrimSpace(version), 10, 32)
+		if !found || err != nil || parsedVersion <= uint64(LegacyKeyVersion) {
+			panic("ENCRYPTION_KEYS entries must look like version=secret, with versions above 1")
+		}
+
+		secrets[uint32(parsedVersion)] = strings.TrimSpace(secret)
+	}
+
+	provider, err := KeyProviderFromEnv()
+	if err != nil {
+		panic(err)
 	}
 
-	hashedKey := sha256.Sum256([]byte(secretKey))
+	if len(secrets) == 0 {
+		if provider == nil {
+			panic("ENCRYPTION_SECRET_KEY env var is empty")
+		}
 
-	aead, err := chacha20poly1305.New(hashedKey[:])
+		return &Cipher{
+			keys:       make(map[uint32]cipher.AEAD),
+			provider:   provider,
+			cachedKeys: newKeyCache(),
+		}
+	}
+
+	cipherInstance, err := NewWithKeys(secrets)
 	if err != nil {
 		panic(err)
 	}
 
-	return &Cipher{
-		Aead: aead,
+	if provider != nil {
+		cipherInstance.UseKeyProvider(provider, nil)
 	}
+
+	return cipherInstance
+}
+
+// NewWithKeys -> Every secret is hashed into a key. The highest version encrypts
+func NewWithKeys(secrets map[uint32]string) (*Cipher, error) {
+	cipherInstance := &Cipher{
+		keys: make(map[uint32]cipher.AEAD),
+	}
+
+	for version, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("encryption key %d is empty", version)
+		}
+
+		hashedKey := sha256.Sum256([]byte(secret))
+
+		aead, err := chacha20poly1305.New(hashedKey[:])
+		if err != nil {
+			return nil, err
+		}
+
+		cipherInstance.keys[version] = aead
+
+		if version >= cipherInstance.version {
+			cipherInstance.version = version
+			cipherInstance.Aead = aead
+		}
+	}
+
+	if cipherInstance.Aead == nil {
+		return nil, errors.New("no encryption key configured")
+	}
+
+	return cipherInstance, nil
+}
+
+// LatestVersion -> Key version of every new ciphertext, EnvelopeKeyVersion with a key provider
+func (cipher *Cipher) LatestVersion() uint32 {
+	if cipher.provider != nil {
+		return EnvelopeKeyVersion
+	}
+
+	return cipher.version
 }
 
 func (cipher *Cipher) Encrypt(plainText []byte) ([]byte, error) {
+	return cipher.EncryptWithAD(plainText, nil)
+}
+
+// EncryptWithAD -> additionalData is authenticated but not stored, the same bytes are needed to decrypt.
+// It binds a ciphertext to where it belongs, so it can't be copied somewhere else
+func (cipher *Cipher) EncryptWithAD(plainText, additionalData []byte) ([]byte, error) {
+	if cipher.Aead == nil {
+		return nil, ErrNoStaticKey
+	}
+
 	nonce, err := generateNonce()
 	if err != nil {
 		return nil, err
 	}
 
-	cipherText := cipher.Aead.Seal(nil, nonce, plainText, nil)
+	header := make([]byte, headerSize, headerSize+nonceSize+len(plainText)+cipher.Aead.Overhead())
+	header[0] = formatVersioned
+	binary.BigEndian.PutUint32(header[1:], cipher.version)
+
+	ciphered := append(header, nonce...)
 
-	return append(nonce, cipherText...), nil
+	return cipher.Aead.Seal(ciphered, nonce, plainText, additionalData), nil
 }
 
 func (cipher *Cipher) Decrypt(ciphered []byte) ([]byte, error) {
-	if len(ciphered) < 12 {
+	return cipher.DecryptWithAD(ciphered, nil)
+}
+
+func (cipher *Cipher) DecryptWithAD(ciphered, additionalData []byte) ([]byte, error) {
+	plainText, _, err := cipher.DecryptWithVersion(ciphered, additionalData)
+	return plainText, err
+}
+
+// DecryptWithVersion -> Also returns the key version, so old ciphertexts can be found and re-encrypted
+func (cipher *Cipher) DecryptWithVersion(ciphered, additionalData []byte) ([]byte, uint32, error) {
+	if len(ciphered) >= headerSize+nonceSize && ciphered[0] == formatVersioned {
+		version := binary.BigEndian.Uint32(ciphered[1:headerSize])
+
+		if aead, exists := cipher.keys[version]; exists {
+			if plainText, err := open(aead, ciphered[headerSize:], additionalData); err == nil {
+				return plainText, version, nil
+			}
+		}
+	}
+
+	// ciphertexts from before the key versions are nonce | sealed text with the legacy key.
+	// A legacy nonce may start with the format byte by chance, which is why the header is not trusted blindly
+	aead, exists := cipher.keys[LegacyKeyVersion]
+	if !exists {
+		return nil, 0, ErrUnknownKeyVersion
+	}
+
+	plainText, err := open(aead, ciphered, additionalData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return plainText, LegacyKeyVersion, nil
+}
+
+func open(aead cipher.AEAD, ciphered, additionalData []byte) ([]byte, error) {
+	if len(ciphered) < nonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
 
-	nonce := ciphered[:12]
-	cipherText := ciphered[12:]
+	nonce := ciphered[:nonceSize]
+	cipherText := ciphered[nonceSize:]
 
-	return cipher.Aead.Open(nil, nonce, cipherText, nil)
+	return aead.Open(nil, nonce, cipherText, additionalData)
 }
 
 func generateNonce() ([]byte, error) {
-	nonce := make([]byte, 12)
+	nonce := make([]byte, nonceSize)
 	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
@@ -284,4 +284,119 @@ func BenchmarkEncryptDecrypt(b *testing.B) {
 			b.Fatalf("Decryption failed: %v", err)
 		}
 	}
-} 
\ No newline at end of file
+}
+
+func TestKeyVersions(t *testing.T) {
+	oldCipher, err := NewWithKeys(map[uint32]string{1: "first-secret-key"})
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	rotated, err := NewWithKeys(map[uint32]string{1: "first-secret-key", 2: "second-secret-key"})
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	if rotated.LatestVersion() != 2 {
+		t.Fatalf("Expected newest key version 2, got %d", rotated.LatestVersion())
+	}
+
+	oldEncrypted, err := oldCipher.Encrypt([]byte("old message"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	newEncrypted, err := rotated.Encrypt([]byte("new message"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	plainText, version, err := rotated.DecryptWithVersion(oldEncrypted, nil)
+	if err != nil || version != 1 || string(plainText) != "old message" {
+		t.Errorf("Expected old message with version 1, got %q, %d, %v", plainText, version, err)
+	}
+
+	plainText, version, err = rotated.DecryptWithVersion(newEncrypted, nil)
+	if err != nil || version != 2 || string(plainText) != "new message" {
+		t.Errorf("Expected new message with version 2, got %q, %d, %v", plainText, version, err)
+	}
+
+	if _, err := oldCipher.Decrypt(newEncrypted); err == nil {
+		t.Error("Expected error when the key version is unknown")
+	}
+}
+
+func TestDecryptLegacyFormat(t *testing.T) {
+	cipher, err := NewWithKeys(map[uint32]string{LegacyKeyVersion: "legacy-secret-key", 2: "second-secret-key"})
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	// ciphertexts stored before key versions: nonce | sealed text, no header
+	legacyAead := cipher.keys[LegacyKeyVersion]
+	for _, firstNonceByte := range []byte{0x00, formatVersioned} {
+		nonce := make([]byte, nonceSize)
+		nonce[0] = firstNonceByte
+
+		legacy := legacyAead.Seal(append([]byte{}, nonce...), nonce, []byte("legacy message"), nil)
+
+		plainText, version, err := cipher.DecryptWithVersion(legacy, nil)
+		if err != nil || version != LegacyKeyVersion || string(plainText) != "legacy message" {
+			t.Errorf("Expected legacy message with version 1, got %q, %d, %v", plainText, version, err)
+		}
+	}
+}
+
+func TestNewWithEncryptionKeys(t *testing.T) {
+	os.Setenv("ENCRYPTION_SECRET_KEY", "first-secret-key")
+	os.Setenv("ENCRYPTION_KEYS", "2=second-secret-key, 3=third-secret-key")
+	defer os.Unsetenv("ENCRYPTION_SECRET_KEY")
+	defer os.Unsetenv("ENCRYPTION_KEYS")
+
+	cipher := New()
+	if cipher.LatestVersion() != 3 || len(cipher.keys) != 3 {
+		t.Errorf("Expected 3 keys with version 3 newest, got %d keys and version %d", len(cipher.keys),
+			cipher.LatestVersion())
+	}
+
+	os.Setenv("ENCRYPTION_KEYS", "1=duplicate-of-legacy")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected panic for a key version that is not above 1")
+		}
+	}()
+
+	New()
+}
+
+func TestAdditionalData(t *testing.T) {
+	cipher, err := NewWithKeys(map[uint32]string{1: "test-secret-key-for-testing-only"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := cipher.EncryptWithAD([]byte("hello"), []byte("message one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainText, err := cipher.DecryptWithAD(encrypted, []byte("message one"))
+	if err != nil || string(plainText) != "hello" {
+		t.Fatalf("expected hello, got %q (err %v)", plainText, err)
+	}
+
+	// copied into another context it must not open
+	if _, err := cipher.DecryptWithAD(encrypted, []byte("message two")); err == nil {
+		t.Error("expected an error with different additional data")
+	}
+
+	if _, err := cipher.Decrypt(encrypted); err == nil {
+		t.Error("expected an error without the additional data")
+	}
+
+	// ciphertexts without additional data keep working with Decrypt
+	withoutAD, _ := cipher.Encrypt([]byte("hello"))
+	if _, err := cipher.Decrypt(withoutAD); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package cipher
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// envelope ciphertext: formatEnvelope | nonce | sealed text, with the data key of the owner
+const formatEnvelope byte = 0xc2
+
+// EnvelopeKeyVersion -> Reported for envelope ciphertexts, it sorts after every static key version
+const EnvelopeKeyVersion uint32 = math.MaxUint32
+
+var (
+	ErrDataKeyNotFound = errors.New("data key not found")
+	ErrDataKeyExists   = errors.New("data key exists already")
+	ErrNoStaticKey     = errors.New("no static encryption key configured")
+)
+
+// DataKeyStore -> Keeps the wrapped data keys. An owner is whatever shares a key, e.g. "chat:<id>"
+type DataKeyStore interface {
+	// Get returns ErrDataKeyNotFound when the owner has no key
+	Get(owner string) ([]byte, error)
+	// Create returns ErrDataKeyExists when another key was stored first
+	Create(owner string, wrappedKey []byte) error
+	Delete(owner string) error
+}
+
+// UseKeyProvider -> Turns on envelope encryption: EncryptFor seals with a data key per owner,
+// wrapped by the provider's master key. The static keys are still used to read older ciphertexts
+func (cipher *Cipher) UseKeyProvider(provider KeyProvider, store DataKeyStore) {
+	cipher.dataKeysMutex.Lock()
+	defer cipher.dataKeysMutex.Unlock()
+
+	cipher.provider = provider
+	cipher.dataKeys = store
+	cipher.cachedKeys = newKeyCache()
+}
+
+func newKeyCache() map[string]cipher.AEAD {
+	return make(map[string]cipher.AEAD)
+}
+
+// SetDataKeyStore -> For a provider configured by New, the store is only known after the database is up
+func (cipher *Cipher) SetDataKeyStore(store DataKeyStore) {
+	cipher.UseKeyProvider(cipher.provider, store)
+}
+
+// EncryptFor -> Encrypts with the owner's data key, or with the newest static key when there is no key provider
+func (cipher *Cipher) EncryptFor(owner string, plainText, additionalData []byte) ([]byte, error) {
+	if cipher.provider == nil {
+		return cipher.EncryptWithAD(plainText, additionalData)
+	}
+
+	aead, err := cipher.dataKey(owner, true)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphered := make([]byte, 1, 1+nonceSize+len(plainText)+aead.Overhead())
+	ciphered[0] = formatEnvelope
+	ciphered = append(ciphered, nonce...)
+
+	return aead.Seal(ciphered, nonce, plainText, additionalData), nil
+}
+
+// DecryptFor -> Opens envelope and static ciphertexts
+func (cipher *Cipher) DecryptFor(owner string, ciphered, additionalData []byte) ([]byte, error) {
+	plainText, _, err := cipher.DecryptForWithVersion(owner, ciphered, additionalData)
+	return plainText, err
+}
+
+// DecryptForWithVersion -> The version is EnvelopeKeyVersion for envelope ciphertexts
+func (cipher *Cipher) DecryptForWithVersion(owner string, ciphered, additionalData []byte) ([]byte, uint32, error) {
+	if cipher.provider == nil || len(ciphered) < 1+nonceSize || ciphered[0] != formatEnvelope {
+		return cipher.DecryptWithVersion(ciphered, additionalData)
+	}
+
+	aead, err := cipher.dataKey(owner, false)
+	if err == nil {
+		var plainText []byte
+		if plainText, err = open(aead, ciphered[1:], additionalData); err == nil {
+			return plainText, EnvelopeKeyVersion, nil
+		}
+	}
+
+	// a legacy nonce may start with the envelope byte by chance
+	if plainText, version, staticErr := cipher.DecryptWithVersion(ciphered, additionalData); staticErr == nil {
+		return plainText, version, nil
+	}
+
+	return nil, 0, err
+}
+
+// DestroyDataKey -> Crypto-shreds everything encrypted for the owner. Other instances forget their cached
+// copy of the key on restart, so delete the data as well
+func (cipher *Cipher) DestroyDataKey(owner string) error {
+	if cipher.provider == nil {
+		return nil
+	}
+
+	cipher.dataKeysMutex.Lock()
+	delete(cipher.cachedKeys, owner)
+	cipher.dataKeysMutex.Unlock()
+
+	if cipher.dataKeys == nil {
+		return errors.New("data key store is not set")
+	}
+
+	return cipher.dataKeys.Delete(owner)
+}
+
+// dataKey -> Unwrapped keys are cached, so the provider is asked once per owner
+func (cipher *Cipher) dataKey(owner string, create bool) (cipher.AEAD, error) {
+	cipher.dataKeysMutex.Lock()
+	aead, exists := cipher.cachedKeys[owner]
+	store := cipher.dataKeys
+	cipher.dataKeysMutex.Unlock()
+
+	if exists {
+		return aead, nil
+	}
+
+	if store == nil {
+		return nil, errors.New("data key store is not set")
+	}
+
+	dataKey, err := cipher.loadDataKey(store, owner, create)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err = chacha20poly1305.New(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher.dataKeysMutex.Lock()
+	cipher.cachedKeys[owner] = aead
+	cipher.dataKeysMutex.Unlock()
+
+	return aead, nil
+}
+
+func (cipher *Cipher) loadDataKey(store DataKeyStore, owner string, create bool) ([]byte, error) {
+	wrappedKey, err := store.Get(owner)
+	if err == nil {
+		return cipher.provider.UnwrapKey(wrappedKey)
+	}
+
+	if !create || !errors.Is(err, ErrDataKeyNotFound) {
+		return nil, err
+	}
+
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err = cipher.provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Create(owner, wrappedKey); err != nil {
+		if !errors.Is(err, ErrDataKeyExists) {
+			return nil, err
+		}
+
+		// a concurrent encrypt created the key first, everyone has to use that one
+		if wrappedKey, err = store.Get(owner); err != nil {
+			return nil, err
+		}
+
+		return cipher.provider.UnwrapKey(wrappedKey)
+	}
+
+	return dataKey, nil
+}
@@ -0,0 +1,290 @@
+package cipher
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type memoryDataKeys struct {
+	mutex sync.Mutex
+	keys  map[string][]byte
+	gets  int
+}
+
+func newMemoryDataKeys() *memoryDataKeys {
+	return &memoryDataKeys{keys: make(map[string][]byte)}
+}
+
+func (store *memoryDataKeys) Get(owner string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.gets++
+
+	wrappedKey, exists := store.keys[owner]
+	if !exists {
+		return nil, ErrDataKeyNotFound
+	}
+
+	return wrappedKey, nil
+}
+
+func (store *memoryDataKeys) Create(owner string, wrappedKey []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.keys[owner]; exists {
+		return ErrDataKeyExists
+	}
+
+	store.keys[owner] = wrappedKey
+	return nil
+}
+
+func (store *memoryDataKeys) Delete(owner string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.keys, owner)
+	return nil
+}
+
+func newEnvelopeCipher(t *testing.T, provider KeyProvider, store DataKeyStore) *Cipher {
+	t.Helper()
+
+	cipher, err := NewWithKeys(map[uint32]string{1: "static secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipher.UseKeyProvider(provider, store)
+	return cipher
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	provider, err := NewLocalKeyProvider("master secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemoryDataKeys()
+	cipher := newEnvelopeCipher(t, provider, store)
+
+	encrypted, err := cipher.EncryptFor("chat:1", []byte("hello"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.keys) != 1 {
+		t.Fatalf("expected one data key, got %d", len(store.keys))
+	}
+
+	plainText, version, err := cipher.DecryptForWithVersion("chat:1", encrypted, []byte("ad"))
+	if err != nil || string(plainText) != "hello" || version != EnvelopeKeyVersion {
+		t.Fatalf("got %q, version %d, err %v", plainText, version, err)
+	}
+
+	if cipher.LatestVersion() != EnvelopeKeyVersion {
+		t.Errorf("expected latest version to be the envelope, got %d", cipher.LatestVersion())
+	}
+
+	// every owner has its own key
+	if _, err := cipher.DecryptFor("chat:2", encrypted, []byte("ad")); err == nil {
+		t.Error("expected another owner's key to fail")
+	}
+
+	// static ciphertexts from before the provider still open
+	static, _ := cipher.EncryptWithAD([]byte("old"), []byte("ad"))
+	plainText, version, err = cipher.DecryptForWithVersion("chat:1", static, []byte("ad"))
+	if err != nil || string(plainText) != "old" || version != 1 {
+		t.Errorf("static: got %q, version %d, err %v", plainText, version, err)
+	}
+
+	// a second instance unwraps the stored key
+	other := newEnvelopeCipher(t, provider, store)
+	if plainText, err := other.DecryptFor("chat:1", encrypted, []byte("ad")); err != nil || string(plainText) != "hello" {
+		t.Errorf("other instance: got %q, err %v", plainText, err)
+	}
+}
+
+func TestDestroyDataKey(t *testing.T) {
+	provider, _ := NewLocalKeyProvider("master secret")
+	store := newMemoryDataKeys()
+	cipher := newEnvelopeCipher(t, provider, store)
+
+	encrypted, err := cipher.EncryptFor("chat:1", []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cipher.DestroyDataKey("chat:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cipher.DecryptFor("chat:1", encrypted, nil); !errors.Is(err, ErrDataKeyNotFound) {
+		t.Errorf("expected ErrDataKeyNotFound, got %v", err)
+	}
+
+	// a new message gets a new key, the old ones stay unreadable
+	if _, err := cipher.EncryptFor("chat:1", []byte("again"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cipher.DecryptFor("chat:1", encrypted, nil); err == nil {
+		t.Error("shredded message decrypted with the new key")
+	}
+}
+
+func TestDataKeyCreatedOnce(t *testing.T) {
+	provider, _ := NewLocalKeyProvider("master secret")
+	store := newMemoryDataKeys()
+
+	// separate instances race to create the key of the same owner
+	var wg sync.WaitGroup
+	encrypted := make([][]byte, 8)
+	for idx := range encrypted {
+		cipher := newEnvelopeCipher(t, provider, store)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			encrypted[idx], _ = cipher.EncryptFor("group:1", []byte("hello"), nil)
+		}()
+	}
+	wg.Wait()
+
+	reader := newEnvelopeCipher(t, provider, store)
+	for idx, ciphered := range encrypted {
+		if _, err := reader.DecryptFor("group:1", ciphered, nil); err != nil {
+			t.Errorf("ciphertext %d: %v", idx, err)
+		}
+	}
+}
+
+func TestDataKeyCache(t *testing.T) {
+	provider, _ := NewLocalKeyProvider("master secret")
+	store := newMemoryDataKeys()
+	cipher := newEnvelopeCipher(t, provider, store)
+
+	for range 3 {
+		if _, err := cipher.EncryptFor("chat:1", []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if store.gets != 1 {
+		t.Errorf("expected the store to be read once, got %d", store.gets)
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte("file secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stub, err := NewKMSStub(map[string]string{"chat-app": "kms secret"}, "kms-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	t.Setenv("ENCRYPTION_MASTER_KEY", "env secret")
+	t.Setenv("ENCRYPTION_MASTER_KEY_FILE", keyFile)
+	t.Setenv("ENCRYPTION_KMS_URL", server.URL)
+	t.Setenv("ENCRYPTION_KMS_KEY_ID", "chat-app")
+	t.Setenv("ENCRYPTION_KMS_TOKEN", "kms-token")
+
+	for _, name := range []string{"env", "file", "kms"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("ENCRYPTION_KEY_PROVIDER", name)
+
+			provider, err := KeyProviderFromEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wrapped, err := provider.WrapKey([]byte("data key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			unwrapped, err := provider.UnwrapKey(wrapped)
+			if err != nil || string(unwrapped) != "data key" {
+				t.Errorf("got %q, err %v", unwrapped, err)
+			}
+
+			cipher := newEnvelopeCipher(t, provider, newMemoryDataKeys())
+			encrypted, err := cipher.EncryptFor("chat:1", []byte("hello"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plainText, err := cipher.DecryptFor("chat:1", encrypted, nil); err != nil || string(plainText) != "hello" {
+				t.Errorf("got %q, err %v", plainText, err)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		t.Setenv("ENCRYPTION_KEY_PROVIDER", "vault")
+
+		if _, err := KeyProviderFromEnv(); err == nil {
+			t.Error("expected an error for an unknown provider")
+		}
+	})
+}
+
+func TestKMSKeyProviderErrors(t *testing.T) {
+	stub, _ := NewKMSStub(map[string]string{"chat-app": "kms secret"}, "kms-token")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	wrongToken, _ := NewKMSKeyProvider(server.URL, "chat-app", "nope")
+	if _, err := wrongToken.WrapKey([]byte("data key")); err == nil {
+		t.Error("expected an error with a wrong token")
+	}
+
+	unknownKey, _ := NewKMSKeyProvider(server.URL, "other", "kms-token")
+	if _, err := unknownKey.WrapKey([]byte("data key")); err == nil {
+		t.Error("expected an error for an unknown key id")
+	}
+
+	provider, _ := NewKMSKeyProvider(server.URL, "chat-app", "kms-token")
+	if _, err := provider.UnwrapKey([]byte("not a wrapped key")); err == nil {
+		t.Error("expected an error for a broken wrapped key")
+	}
+
+	if _, err := NewKMSKeyProvider("", "chat-app", ""); err == nil {
+		t.Error("expected an error without a url")
+	}
+}
+
+func TestNewWithKeyProviderOnly(t *testing.T) {
+	t.Setenv("ENCRYPTION_SECRET_KEY", "")
+	t.Setenv("ENCRYPTION_KEYS", "")
+	t.Setenv("ENCRYPTION_KEY_PROVIDER", "env")
+	t.Setenv("ENCRYPTION_MASTER_KEY", "env secret")
+
+	cipher := New()
+	cipher.SetDataKeyStore(newMemoryDataKeys())
+
+	encrypted, err := cipher.EncryptFor("user:1", []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plainText, err := cipher.DecryptFor("user:1", encrypted, nil); err != nil || string(plainText) != "hello" {
+		t.Errorf("got %q, err %v", plainText, err)
+	}
+
+	if _, err := cipher.Encrypt([]byte("hello")); !errors.Is(err, ErrNoStaticKey) {
+		t.Errorf("expected ErrNoStaticKey, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package cipher
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyProvider -> Holds the master key and wraps the data keys with it. The master key never leaves the provider,
+// only wrapped data keys are stored next to the data
+type KeyProvider interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// dataKeyAD -> wrapped data keys can't be swapped with other ciphertexts of the same master key
+var dataKeyAD = []byte("chat_app data key")
+
+// LocalKeyProvider -> Master key in process memory, from an env var or a key file
+type LocalKeyProvider struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKeyProvider -> The secret is hashed into the master key, like the static keys
+func NewLocalKeyProvider(secret string) (*LocalKeyProvider, error) {
+	if secret == "" {
+		return nil, errors.New("master key is empty")
+	}
+
+	hashedKey := sha256.Sum256([]byte(secret))
+
+	aead, err := chacha20poly1305.New(hashedKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalKeyProvider{aead: aead}, nil
+}
+
+// NewEnvKeyProvider -> Master key from an env var
+func NewEnvKeyProvider(name string) (*LocalKeyProvider, error) {
+	secret := os.Getenv(name)
+	if secret == "" {
+		return nil, fmt.Errorf("%s env var is empty", name)
+	}
+
+	return NewLocalKeyProvider(secret)
+}
+
+// NewFileKeyProvider -> Master key from a file, e.g. a mounted secret. Surrounding whitespace is ignored
+func NewFileKeyProvider(path string) (*LocalKeyProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading master key file: %w", err)
+	}
+
+	return NewLocalKeyProvider(strings.TrimSpace(string(content)))
+}
+
+func (provider *LocalKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.aead.Seal(nonce, nonce, dataKey, dataKeyAD), nil
+}
+
+func (provider *LocalKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) < nonceSize {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	return provider.aead.Open(nil, wrappedKey[:nonceSize], wrappedKey[nonceSize:], dataKeyAD)
+}
+
+// KeyProviderFromEnv -> ENCRYPTION_KEY_PROVIDER picks the backend: "env" (ENCRYPTION_MASTER_KEY),
+// "file" (ENCRYPTION_MASTER_KEY_FILE) or "kms" (ENCRYPTION_KMS_URL, ENCRYPTION_KMS_KEY_ID, ENCRYPTION_KMS_TOKEN).
+// Empty means no envelope encryption, only the static keys are used
+func KeyProviderFromEnv() (KeyProvider, error) {
+	switch provider := os.Getenv("ENCRYPTION_KEY_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "env":
+		return NewEnvKeyProvider("ENCRYPTION_MASTER_KEY")
+	case "file":
+		return NewFileKeyProvider(os.Getenv("ENCRYPTION_MASTER_KEY_FILE"))
+	case "kms":
+		return NewKMSKeyProvider(os.Getenv("ENCRYPTION_KMS_URL"), os.Getenv("ENCRYPTION_KMS_KEY_ID"),
+			os.Getenv("ENCRYPTION_KMS_TOKEN"))
+	default:
+		return nil, fmt.Errorf("unknown ENCRYPTION_KEY_PROVIDER %q, use env, file or kms", provider)
+	}
+}
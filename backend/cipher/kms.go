@@ -0,0 +1,191 @@
+package cipher
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KMSKeyProvider -> Wraps data keys with a key that lives in a KMS-style HTTP service:
+//
+//	POST {url}/v1/keys/{key_id}/encrypt {"plaintext": base64} -> {"ciphertext": base64}
+//	POST {url}/v1/keys/{key_id}/decrypt {"ciphertext": base64} -> {"plaintext": base64}
+//
+// KMSStub serves the same API for local runs and tests
+type KMSKeyProvider struct {
+	url    string
+	keyId  string
+	token  string
+	client *http.Client
+}
+
+type kmsRequest struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func NewKMSKeyProvider(baseUrl, keyId, token string) (*KMSKeyProvider, error) {
+	if baseUrl == "" || keyId == "" {
+		return nil, errors.New("ENCRYPTION_KMS_URL and ENCRYPTION_KMS_KEY_ID are required for the kms key provider")
+	}
+
+	return &KMSKeyProvider{
+		url:    strings.TrimRight(baseUrl, "/"),
+		keyId:  keyId,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (provider *KMSKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	resp, err := provider.call("encrypt", kmsRequest{Plaintext: dataKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Ciphertext, nil
+}
+
+func (provider *KMSKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	resp, err := provider.call("decrypt", kmsRequest{Ciphertext: wrappedKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Plaintext, nil
+}
+
+func (provider *KMSKeyProvider) call(operation string, input kmsRequest) (*kmsResponse, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/keys/%s/%s", provider.url, url.PathEscape(provider.keyId), operation)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if provider.token != "" {
+		req.Header.Set("Authorization", "Bearer "+provider.token)
+	}
+
+	httpResp, err := provider.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kms %s: %w", operation, err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp kmsResponse
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, 1<<20)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("kms %s: status %d: %w", operation, httpResp.StatusCode, err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kms %s: status %d: %s", operation, httpResp.StatusCode, resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// KMSStub -> Local stand-in for the KMS API, every key id is a LocalKeyProvider.
+// An empty token accepts every request
+type KMSStub struct {
+	keys  map[string]*LocalKeyProvider
+	token string
+}
+
+func NewKMSStub(secrets map[string]string, token string) (*KMSStub, error) {
+	stub := &KMSStub{
+		keys:  make(map[string]*LocalKeyProvider),
+		token: token,
+	}
+
+	for keyId, secret := range secrets {
+		provider, err := NewLocalKeyProvider(secret)
+		if err != nil {
+			return nil, fmt.Errorf("kms key %s: %w", keyId, err)
+		}
+
+		stub.keys[keyId] = provider
+	}
+
+	return stub, nil
+}
+
+func (stub *KMSStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if stub.token != "" {
+		expected := []byte("Bearer " + stub.token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeKMSResponse(w, http.StatusUnauthorized, kmsResponse{Error: "invalid token"})
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		writeKMSResponse(w, http.StatusMethodNotAllowed, kmsResponse{Error: "method not allowed"})
+		return
+	}
+
+	// /v1/keys/{key_id}/{operation}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "v1" || parts[1] != "keys" {
+		writeKMSResponse(w, http.StatusNotFound, kmsResponse{Error: "not found"})
+		return
+	}
+
+	provider, exists := stub.keys[parts[2]]
+	if !exists {
+		writeKMSResponse(w, http.StatusNotFound, kmsResponse{Error: "key not found"})
+		return
+	}
+
+	var input kmsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&input); err != nil {
+		writeKMSResponse(w, http.StatusBadRequest, kmsResponse{Error: err.Error()})
+		return
+	}
+
+	switch parts[3] {
+	case "encrypt":
+		wrapped, err := provider.WrapKey(input.Plaintext)
+		if err != nil {
+			writeKMSResponse(w, http.StatusInternalServerError, kmsResponse{Error: err.Error()})
+			return
+		}
+
+		writeKMSResponse(w, http.StatusOK, kmsResponse{Ciphertext: wrapped})
+	case "decrypt":
+		plainText, err := provider.UnwrapKey(input.Ciphertext)
+		if err != nil {
+			writeKMSResponse(w, http.StatusBadRequest, kmsResponse{Error: "invalid ciphertext"})
+			return
+		}
+
+		writeKMSResponse(w, http.StatusOK, kmsResponse{Plaintext: plainText})
+	default:
+		writeKMSResponse(w, http.StatusNotFound, kmsResponse{Error: "unknown operation"})
+	}
+}
+
+func writeKMSResponse(w http.ResponseWriter, status int, resp kmsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
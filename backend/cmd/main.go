@@ -5,8 +5,10 @@ import (
 	"chat_app/database"
 	"chat_app/database/models"
 	"chat_app/handlers"
+	"chat_app/limiter"
 	"chat_app/webserver"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 
@@ -31,16 +33,34 @@ func main() {
 
 	newModels := models.New(db)
 
+	// subcommands run once and exit instead of serving
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:], newModels); err != nil {
+			slog.Error("running command", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	handlerInstance, err := handlers.New(newModels)
 	if err != nil {
 		panic(err)
 	}
 
-	wsInstance := handlers.WebsocketInit()
+	// login attempts are counted in mongo by default, "memory" is enough for a single instance
+	if viper.GetString("LOGIN_ATTEMPTS_STORE") == "memory" {
+		handlerInstance.Limiter = limiter.New(limiter.NewMemoryStore())
+	}
+
+	wsInstance, err := newWebSocketManager(newModels)
+	if err != nil {
+		panic(err)
+	}
+	defer wsInstance.Close()
+
 	handlerInstance.WebSocket = wsInstance
 
-	cipherInstance := cipher.New()
-	handlerInstance.Cipher = cipherInstance
+	handlerInstance.Cipher = newCipher(newModels)
 
 	srv := webserver.New(getPort(), handlerInstance)
 	defer srv.Close()
@@ -51,6 +71,36 @@ func main() {
 	}
 }
 
+func runCommand(name string, args []string, newModels *models.Models) error {
+	switch name {
+	case "reencrypt":
+		return runReEncrypt(args, newModels, newCipher(newModels))
+	default:
+		return fmt.Errorf("unknown command %q, available: reencrypt", name)
+	}
+}
+
+// newWebSocketManager -> Rooms are fanned out in process by default. Several instances behind a load balancer
+// need WS_BROKER=mongo, which uses change streams and so a replica set
+func newWebSocketManager(newModels *models.Models) (*handlers.WebSocketManager, error) {
+	switch viper.GetString("WS_BROKER") {
+	case "", "memory":
+		return handlers.WebsocketInit(), nil
+	case "mongo":
+		return handlers.NewWebSocketManager(newModels.BrokerEvent)
+	default:
+		return nil, fmt.Errorf("unknown WS_BROKER %q, available: memory, mongo", viper.GetString("WS_BROKER"))
+	}
+}
+
+// newCipher -> The data keys of the envelope encryption are stored in mongo
+func newCipher(newModels *models.Models) *cipher.Cipher {
+	cipherInstance := cipher.New()
+	cipherInstance.SetDataKeyStore(newModels.DataKey)
+
+	return cipherInstance
+}
+
 func loadConfig() error {
 	viper.SetConfigFile(".env")
 	viper.AutomaticEnv()
@@ -67,8 +117,25 @@ func loadConfig() error {
 	os.Setenv("DATABASE_NAME", viper.GetString("DATABASE_NAME"))
 	os.Setenv("MONGO_URI", viper.GetString("MONGO_URI"))
 	os.Setenv("PASETO_SYMMETRIC_KEY", viper.GetString("PASETO_SYMMETRIC_KEY"))
+	os.Setenv("PASETO_KEYS", viper.GetString("PASETO_KEYS"))
+	os.Setenv("PASETO_PUBLIC_KEYS", viper.GetString("PASETO_PUBLIC_KEYS"))
+	os.Setenv("PASETO_ACTIVE_KEY_ID", viper.GetString("PASETO_ACTIVE_KEY_ID"))
+	os.Setenv("PASETO_RETIRED_KEY_IDS", viper.GetString("PASETO_RETIRED_KEY_IDS"))
 	os.Setenv("ENCRYPTION_SECRET_KEY", viper.GetString("ENCRYPTION_SECRET_KEY"))
+	os.Setenv("ENCRYPTION_KEYS", viper.GetString("ENCRYPTION_KEYS"))
+	os.Setenv("MESSAGE_AD_REQUIRED", viper.GetString("MESSAGE_AD_REQUIRED"))
+	os.Setenv("MESSAGE_EDIT_WINDOW", viper.GetString("MESSAGE_EDIT_WINDOW"))
+	os.Setenv("ENCRYPTION_KEY_PROVIDER", viper.GetString("ENCRYPTION_KEY_PROVIDER"))
+	os.Setenv("ENCRYPTION_MASTER_KEY", viper.GetString("ENCRYPTION_MASTER_KEY"))
+	os.Setenv("ENCRYPTION_MASTER_KEY_FILE", viper.GetString("ENCRYPTION_MASTER_KEY_FILE"))
+	os.Setenv("ENCRYPTION_KMS_URL", viper.GetString("ENCRYPTION_KMS_URL"))
+	os.Setenv("ENCRYPTION_KMS_KEY_ID", viper.GetString("ENCRYPTION_KMS_KEY_ID"))
+	os.Setenv("ENCRYPTION_KMS_TOKEN", viper.GetString("ENCRYPTION_KMS_TOKEN"))
 	os.Setenv("CORS_ALLOWED_ORIGINS", viper.GetString("CORS_ALLOWED_ORIGINS"))
+	os.Setenv("ADMIN_USER_IDS", viper.GetString("ADMIN_USER_IDS"))
+	os.Setenv("TRUSTED_PROXIES", viper.GetString("TRUSTED_PROXIES"))
+	os.Setenv("PASSWORD_MIN_LENGTH", viper.GetString("PASSWORD_MIN_LENGTH"))
+	os.Setenv("PASSWORD_MIN_CLASSES", viper.GetString("PASSWORD_MIN_CLASSES"))
 
 	return nil
 }
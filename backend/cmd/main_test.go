@@ -0,0 +1,51 @@
+package main
+
+import (
+	"chat_app/database/models"
+	"chat_app/handlers"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadConfigEditWindow(t *testing.T) {
+	dir := t.TempDir()
+	env := "PASETO_SYMMETRIC_KEY=abcdefghijklmnopqrstuvwxyz123456\nMESSAGE_EDIT_WINDOW=1h\n"
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(dir)
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	// loadConfig exports with os.Setenv, t.Setenv puts the old values back afterwards
+	for _, key := range []string{"PASETO_SYMMETRIC_KEY", "MESSAGE_EDIT_WINDOW"} {
+		t.Setenv(key, "")
+	}
+
+	if err := loadConfig(); err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+
+	handler, err := handlers.New(&models.Models{})
+	if err != nil {
+		t.Fatalf("Failed to create the handler: %v", err)
+	}
+
+	if handler.EditWindow != time.Hour {
+		t.Fatalf("Expected the edit window of the .env file, got %s", handler.EditWindow)
+	}
+
+	now := time.Now()
+	if !handler.EditWindowClosed(now.Add(-2*time.Hour), now) {
+		t.Error("Expected an edit past the window to be rejected")
+	}
+
+	if handler.EditWindowClosed(now.Add(-time.Minute), now) {
+		t.Error("Expected an edit within the window to be allowed")
+	}
+}
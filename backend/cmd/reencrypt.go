@@ -0,0 +1,170 @@
+package main
+
+import (
+	"chat_app/cipher"
+	"chat_app/database/models"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the job also binds content to its message with associated data, runs from before that start over
+const reEncryptMigration = "reencrypt_messages_ad"
+
+var reEncryptProjection = bson.M{
+	"content":    1,
+	"chat_id":    1,
+	"group_id":   1,
+	"sender_id":  1,
+	"ad_version": 1,
+}
+
+type messageStore interface {
+	GetBatch(afterId primitive.ObjectID, projection bson.M, limit int64) ([]models.Message, error)
+	Update(filter, updates bson.M) (*mongo.UpdateResult, error)
+}
+
+type migrationStore interface {
+	Get(name string) (*models.Migration, error)
+	Save(migration *models.Migration) error
+}
+
+// runReEncrypt -> "reencrypt" subcommand: moves every message onto the newest encryption key and associated data.
+// With a key provider the newest key is the data key of the message's chat or group
+func runReEncrypt(args []string, newModels *models.Models, cipherInstance *cipher.Cipher) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	batchSize := flags.Int64("batch-size", 500, "messages loaded per batch")
+	restart := flags.Bool("restart", false, "ignore the checkpoint and start from the first message")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *batchSize < 1 {
+		return errors.New("batch-size must be positive")
+	}
+
+	_, err := reEncryptMessages(newModels.Message, newModels.Migration, cipherInstance, *batchSize, *restart)
+	return err
+}
+
+// reEncryptMessages -> Walks the messages in _id order and saves a checkpoint after every batch.
+// Messages that are already on the newest key with associated data are left alone, so running it twice is harmless
+func reEncryptMessages(messages messageStore, migrations migrationStore, cipherInstance *cipher.Cipher,
+	batchSize int64, restart bool) (*models.Migration, error) {
+
+	target := int64(cipherInstance.LatestVersion())
+
+	progress, err := migrations.Get(reEncryptMigration)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	switch {
+	case progress == nil || restart || progress.Target != target:
+		progress = &models.Migration{Name: reEncryptMigration, Target: target}
+	case progress.Completed:
+		slog.Info("messages are on the newest key already", "key_version", target)
+		return progress, nil
+	default:
+		slog.Info("resuming re-encryption", "checkpoint", progress.Checkpoint.Hex(), "processed", progress.Processed)
+	}
+
+	var reEncrypted, failed int64
+
+	for {
+		batch, err := messages.GetBatch(progress.Checkpoint, reEncryptProjection, batchSize)
+		if err != nil {
+			return progress, err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, message := range batch {
+			updated, err := reEncryptMessage(messages, cipherInstance, &message)
+			if err != nil {
+				failed++
+				slog.Warn("skipping message", "message_id", message.Id.Hex(), "error", err)
+			}
+
+			if updated {
+				reEncrypted++
+			}
+
+			progress.Checkpoint = message.Id
+			progress.Processed++
+		}
+
+		if err := migrations.Save(progress); err != nil {
+			return progress, err
+		}
+
+		slog.Info("re-encryption batch done", "processed", progress.Processed, "checkpoint", progress.Checkpoint.Hex())
+	}
+
+	progress.Completed = true
+	if err := migrations.Save(progress); err != nil {
+		return progress, err
+	}
+
+	slog.Info("re-encryption finished", "key_version", target, "processed", progress.Processed,
+		"re_encrypted", reEncrypted, "failed", failed)
+
+	return progress, nil
+}
+
+func reEncryptMessage(messages messageStore, cipherInstance *cipher.Cipher, message *models.Message) (bool, error) {
+	// image messages have no content
+	if message.Content == "" {
+		return false, nil
+	}
+
+	ciphered, err := hex.DecodeString(message.Content)
+	if err != nil {
+		return false, err
+	}
+
+	var associatedData []byte
+	if message.ADVersion != 0 {
+		associatedData = message.AssociatedData()
+	}
+
+	plainText, version, err := cipherInstance.DecryptForWithVersion(message.KeyOwner(), ciphered, associatedData)
+	if err != nil {
+		return false, err
+	}
+
+	if version == cipherInstance.LatestVersion() && message.ADVersion == models.MessageADVersion {
+		return false, nil
+	}
+
+	reCiphered, err := cipherInstance.EncryptFor(message.KeyOwner(), plainText, message.AssociatedData())
+	if err != nil {
+		return false, err
+	}
+
+	// matching the old content keeps an edit made in the meantime
+	filter := bson.M{
+		"_id":     message.Id,
+		"content": message.Content,
+	}
+
+	updates := bson.M{
+		"content":    hex.EncodeToString(reCiphered),
+		"ad_version": models.MessageADVersion,
+	}
+
+	result, err := messages.Update(filter, updates)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"chat_app/cipher"
+	"chat_app/database/models"
+	"encoding/hex"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type fakeMessages struct {
+	messages map[primitive.ObjectID]models.Message
+	batches  int
+}
+
+func newFakeMessages() *fakeMessages {
+	return &fakeMessages{messages: make(map[primitive.ObjectID]models.Message)}
+}
+
+// add -> Stores a legacy message, encrypted without associated data
+func (fake *fakeMessages) add(t *testing.T, cipherInstance *cipher.Cipher, content string) primitive.ObjectID {
+	t.Helper()
+
+	ciphered, err := cipherInstance.Encrypt([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := primitive.NewObjectID()
+	fake.messages[id] = models.Message{
+		Id:       id,
+		ChatId:   primitive.NewObjectID(),
+		SenderId: primitive.NewObjectID(),
+		Content:  hex.EncodeToString(ciphered),
+	}
+
+	return id
+}
+
+func (fake *fakeMessages) GetBatch(afterId primitive.ObjectID, _ bson.M, limit int64) ([]models.Message, error) {
+	fake.batches++
+
+	var batch []models.Message
+	for id, message := range fake.messages {
+		if id.Hex() > afterId.Hex() {
+			batch = append(batch, message)
+		}
+	}
+
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].Id.Hex() < batch[j].Id.Hex()
+	})
+
+	if int64(len(batch)) > limit {
+		batch = batch[:limit]
+	}
+
+	return batch, nil
+}
+
+func (fake *fakeMessages) Update(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	message := fake.messages[filter["_id"].(primitive.ObjectID)]
+	if message.Content != filter["content"] {
+		return &mongo.UpdateResult{}, nil
+	}
+
+	message.Content = updates["content"].(string)
+	message.ADVersion = updates["ad_version"].(int)
+	fake.messages[message.Id] = message
+
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+// version -> Key version of a message, it must decrypt with its associated data
+func (fake *fakeMessages) version(t *testing.T, cipherInstance *cipher.Cipher, id primitive.ObjectID) uint32 {
+	t.Helper()
+
+	message := fake.messages[id]
+	if message.ADVersion != models.MessageADVersion {
+		t.Errorf("message %s has ad version %d", id.Hex(), message.ADVersion)
+		return 0
+	}
+
+	ciphered, _ := hex.DecodeString(message.Content)
+	plainText, version, err := cipherInstance.DecryptForWithVersion(message.KeyOwner(), ciphered, message.AssociatedData())
+	if err != nil || string(plainText) != "hello" {
+		t.Errorf("message %s: plain text %q, err %v", id.Hex(), plainText, err)
+	}
+
+	return version
+}
+
+type fakeMigrations struct {
+	saved *models.Migration
+}
+
+func (fake *fakeMigrations) Get(string) (*models.Migration, error) {
+	if fake.saved == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	saved := *fake.saved
+	return &saved, nil
+}
+
+func (fake *fakeMigrations) Save(migration *models.Migration) error {
+	saved := *migration
+	fake.saved = &saved
+	return nil
+}
+
+func TestReEncryptMessages(t *testing.T) {
+	oldCipher, err := cipher.NewWithKeys(map[uint32]string{1: "old secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCipher, err := cipher.NewWithKeys(map[uint32]string{1: "old secret", 2: "new secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := newFakeMessages()
+	var ids []primitive.ObjectID
+	for range 4 {
+		ids = append(ids, messages.add(t, oldCipher, "hello"))
+	}
+
+	// newest key, but no associated data yet
+	ids = append(ids, messages.add(t, newCipher, "hello"))
+
+	// broken rows are skipped, not fatal
+	brokenId := primitive.NewObjectID()
+	messages.messages[brokenId] = models.Message{Id: brokenId, Content: "not hex"}
+
+	migrations := &fakeMigrations{}
+
+	progress, err := reEncryptMessages(messages, migrations, newCipher, 2, false)
+	if err != nil {
+		t.Fatalf("re-encrypting: %v", err)
+	}
+
+	if !progress.Completed || progress.Processed != 6 || progress.Target != 2 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+
+	for _, id := range ids {
+		if version := messages.version(t, newCipher, id); version != 2 {
+			t.Errorf("message %s: expected key version 2, got %d", id.Hex(), version)
+		}
+	}
+
+	// a completed job for the same key version does nothing
+	messages.batches = 0
+	if _, err := reEncryptMessages(messages, migrations, newCipher, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages.batches != 0 {
+		t.Errorf("completed job loaded %d batches", messages.batches)
+	}
+
+	// restart walks everything again but leaves up to date messages alone
+	before := messages.messages[ids[0]].Content
+
+	progress, err = reEncryptMessages(messages, migrations, newCipher, 10, true)
+	if err != nil || progress.Processed != 6 {
+		t.Errorf("restart: progress %+v, err %v", progress, err)
+	}
+
+	if messages.messages[ids[0]].Content != before {
+		t.Error("up to date message was encrypted again")
+	}
+}
+
+func TestReEncryptMessagesResumes(t *testing.T) {
+	oldCipher, _ := cipher.NewWithKeys(map[uint32]string{1: "old secret"})
+	newCipher, _ := cipher.NewWithKeys(map[uint32]string{1: "old secret", 2: "new secret"})
+
+	messages := newFakeMessages()
+	var ids []primitive.ObjectID
+	for range 4 {
+		ids = append(ids, messages.add(t, oldCipher, "hello"))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+
+	// interrupted after the first two messages
+	migrations := &fakeMigrations{saved: &models.Migration{
+		Name:       reEncryptMigration,
+		Checkpoint: ids[1],
+		Target:     2,
+		Processed:  2,
+	}}
+
+	progress, err := reEncryptMessages(messages, migrations, newCipher, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progress.Processed != 4 {
+		t.Errorf("expected 4 processed, got %d", progress.Processed)
+	}
+
+	for i, id := range ids {
+		// before the checkpoint, so not touched by the resumed run
+		if i < 2 {
+			if messages.messages[id].ADVersion != 0 {
+				t.Errorf("message %d before the checkpoint was migrated", i)
+			}
+			continue
+		}
+
+		if version := messages.version(t, newCipher, id); version != 2 {
+			t.Errorf("message %d: expected key version 2, got %d", i, version)
+		}
+	}
+}
+
+func TestRunCommandUnknown(t *testing.T) {
+	if err := runCommand("nope", nil, nil); err == nil {
+		t.Error("expected an unknown command error")
+	}
+}
+
+type fakeDataKeys map[string][]byte
+
+func (fake fakeDataKeys) Get(owner string) ([]byte, error) {
+	if wrappedKey, exists := fake[owner]; exists {
+		return wrappedKey, nil
+	}
+
+	return nil, cipher.ErrDataKeyNotFound
+}
+
+func (fake fakeDataKeys) Create(owner string, wrappedKey []byte) error {
+	fake[owner] = wrappedKey
+	return nil
+}
+
+func (fake fakeDataKeys) Delete(owner string) error {
+	delete(fake, owner)
+	return nil
+}
+
+func TestReEncryptMessagesToDataKeys(t *testing.T) {
+	staticCipher, _ := cipher.NewWithKeys(map[uint32]string{1: "old secret"})
+
+	envelopeCipher, _ := cipher.NewWithKeys(map[uint32]string{1: "old secret"})
+	provider, _ := cipher.NewLocalKeyProvider("master secret")
+	dataKeys := fakeDataKeys{}
+	envelopeCipher.UseKeyProvider(provider, dataKeys)
+
+	messages := newFakeMessages()
+	id := messages.add(t, staticCipher, "hello")
+
+	progress, err := reEncryptMessages(messages, &fakeMigrations{}, envelopeCipher, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progress.Target != int64(cipher.EnvelopeKeyVersion) {
+		t.Errorf("expected the envelope as target, got %d", progress.Target)
+	}
+
+	if version := messages.version(t, envelopeCipher, id); version != cipher.EnvelopeKeyVersion {
+		t.Errorf("expected an envelope ciphertext, got key version %d", version)
+	}
+
+	message := messages.messages[id]
+	if _, exists := dataKeys[message.KeyOwner()]; !exists {
+		t.Errorf("no data key was created for %s", message.KeyOwner())
+	}
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyModel struct {
+	collection *mongo.Collection
+}
+
+// APIKey -> Long-lived personal key for scripts and CLI tools. Only the hash of the key is stored
+type APIKey struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Username  string             `json:"-" bson:"username"`
+	Name      string             `json:"name" bson:"name"`
+	Prefix    string             `json:"prefix" bson:"prefix"` // first characters of the key, to recognize it in listings
+	HashedKey string             `json:"-" bson:"hashed_key"`
+	// e.g. messages:read, groups:write
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at" bson:"last_used_at"`
+	ExpireAt   *time.Time `json:"expire_at" bson:"expire_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+}
+
+func NewAPIKeyModel(db *mongo.Database) *APIKeyModel {
+	collection := db.Collection("api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hashed_key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on api_keys: %s", err))
+	}
+
+	return &APIKeyModel{
+		collection: collection,
+	}
+}
+
+func (apiKey *APIKeyModel) Create(userId primitive.ObjectID, username, name, prefix, hashedKey string, scopes []string,
+	expireAt *time.Time) (*mongo.InsertOneResult, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newKey := &APIKey{
+		UserId:    userId,
+		Username:  username,
+		Name:      name,
+		Prefix:    prefix,
+		HashedKey: hashedKey,
+		Scopes:    scopes,
+		ExpireAt:  expireAt,
+		CreatedAt: time.Now(),
+	}
+
+	return apiKey.collection.InsertOne(ctx, newKey)
+}
+
+func (apiKey *APIKeyModel) Get(filter, projection bson.M) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var keyInstance APIKey
+	if err := apiKey.collection.FindOne(ctx, filter, findOptions).Decode(&keyInstance); err != nil {
+		return nil, err
+	}
+
+	return &keyInstance, nil
+}
+
+func (apiKey *APIKeyModel) GetAll(filter, projection bson.M, page, pageLimit int64) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSkip((page - 1) * pageLimit)
+	findOptions.SetLimit(pageLimit)
+	findOptions.SetSort(bson.M{
+		"created_at": -1,
+	})
+
+	var keys []APIKey
+	cursor, err := apiKey.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (apiKey *APIKeyModel) Update(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	return apiKey.collection.UpdateOne(ctx, filter, update)
+}
+
+func (apiKey *APIKeyModel) UpdateAll(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	return apiKey.collection.UpdateMany(ctx, filter, update)
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"chat_app/broker"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// brokerEventTTL -> Subscribers read the events from the change stream, the documents are only kept
+	// long enough for a subscriber to resume after a lost connection
+	brokerEventTTL = 5 * time.Minute
+	// brokerRetryDelay -> Wait before a broken change stream is opened again
+	brokerRetryDelay = time.Second
+)
+
+// BrokerEventModel -> broker.Broker built on a change stream of the broker_events collection, so every
+// instance connected to the same database gets the events. Change streams need a replica set
+type BrokerEventModel struct {
+	collection *mongo.Collection
+}
+
+type BrokerEvent struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	Event     broker.Event       `bson:",inline"`
+	ExpireAt  time.Time          `bson:"expire_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func NewBrokerEventModel(db *mongo.Database) *BrokerEventModel {
+	collection := db.Collection("broker_events")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// delivered events are removed by mongo itself
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on broker_events: %s", err))
+	}
+
+	return &BrokerEventModel{
+		collection: collection,
+	}
+}
+
+func (model *BrokerEventModel) Publish(event broker.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	newEvent := &BrokerEvent{
+		Event:     event,
+		ExpireAt:  now.Add(brokerEventTTL),
+		CreatedAt: now,
+	}
+
+	_, err := model.collection.InsertOne(ctx, newEvent)
+	return err
+}
+
+// Subscribe -> Fails right away when the database doesn't support change streams. A stream that breaks
+// later is opened again and resumes after the last event it delivered
+func (model *BrokerEventModel) Subscribe(handler func(broker.Event)) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := model.watch(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			model.readStream(ctx, stream, handler)
+
+			resumeToken := stream.ResumeToken()
+			stream.Close(context.Background())
+
+			stream = model.rewatch(ctx, resumeToken)
+			if stream == nil {
+				return
+			}
+		}
+	}()
+
+	unsubscribe := func() {
+		cancel()
+		<-done
+	}
+
+	return unsubscribe, nil
+}
+
+func (model *BrokerEventModel) watch(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	return model.collection.Watch(ctx, pipeline, opts)
+}
+
+// rewatch -> Opens the stream again until it works or ctx is canceled, then returns nil
+func (model *BrokerEventModel) rewatch(ctx context.Context, resumeToken bson.Raw) *mongo.ChangeStream {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(brokerRetryDelay):
+		}
+
+		stream, err := model.watch(ctx, resumeToken)
+		if err == nil {
+			return stream
+		}
+
+		slog.Warn("reopening broker change stream", "error", err)
+
+		// the token may have left the oplog, the events in between are lost then
+		resumeToken = nil
+	}
+}
+
+func (model *BrokerEventModel) readStream(ctx context.Context, stream *mongo.ChangeStream, handler func(broker.Event)) {
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument BrokerEvent `bson:"fullDocument"`
+		}
+
+		if err := stream.Decode(&change); err != nil {
+			slog.Warn("decoding broker event", "error", err)
+			continue
+		}
+
+		handler(change.FullDocument.Event)
+	}
+
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		slog.Warn("reading broker change stream", "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"chat_app/cipher"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DataKeyModel -> Wrapped data keys of the envelope encryption, implements cipher.DataKeyStore
+type DataKeyModel struct {
+	collection *mongo.Collection
+}
+
+type DataKey struct {
+	Owner      string    `json:"owner" bson:"_id"`
+	WrappedKey []byte    `json:"-" bson:"wrapped_key"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+func NewDataKeyModel(db *mongo.Database) *DataKeyModel {
+	return &DataKeyModel{
+		collection: db.Collection("data_keys"),
+	}
+}
+
+// ChatKeyOwner -> Data key owner of a chat (regular or secret)
+func ChatKeyOwner(chatId primitive.ObjectID) string {
+	return "chat:" + chatId.Hex()
+}
+
+func GroupKeyOwner(groupId primitive.ObjectID) string {
+	return "group:" + groupId.Hex()
+}
+
+func UserKeyOwner(userId primitive.ObjectID) string {
+	return "user:" + userId.Hex()
+}
+
+func (dataKey *DataKeyModel) Get(owner string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var dataKeyInstance DataKey
+	if err := dataKey.collection.FindOne(ctx, bson.M{"_id": owner}).Decode(&dataKeyInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, cipher.ErrDataKeyNotFound
+		}
+
+		return nil, err
+	}
+
+	return dataKeyInstance.WrappedKey, nil
+}
+
+func (dataKey *DataKeyModel) Create(owner string, wrappedKey []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newKey := &DataKey{
+		Owner:      owner,
+		WrappedKey: wrappedKey,
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := dataKey.collection.InsertOne(ctx, newKey); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return cipher.ErrDataKeyExists
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (dataKey *DataKeyModel) Delete(owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := dataKey.collection.DeleteOne(ctx, bson.M{"_id": owner})
+	return err
+}
@@ -31,7 +31,7 @@ type Group struct {
 	AvatarUrl       string               `json:"avatar_url" bson:"avatar_url"`
 	Type            string               `json:"type" bson:"type"` // public or private (private needs apporval)
 	InviteLink      string               `json:"invite_link" bson:"invite_link"`
-	PinnedMessageId primitive.ObjectID   `json:"pinned_message_id" bson:"pinned_message_id"`
+	PinnedMessageId primitive.ObjectID   `json:"pinned_message_id" bson:"pinned_message_id"` // first of the pins
 	LastMessageId   primitive.ObjectID   `json:"last_message_id" bson:"last_message_id"`
 	IsSecret        bool                 `json:"is_secret" bson:"is_secret"`
 	LastMessageAt   time.Time            `json:"last_message_at" bson:"last_message_at"`
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"chat_app/limiter"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttemptModel -> Mongo backed limiter.Store, so the limits hold across restarts and instances
+type LoginAttemptModel struct {
+	collection *mongo.Collection
+}
+
+type LoginAttempt struct {
+	Key          string    `bson:"_id"`
+	Count        int       `bson:"count"`
+	LastFailedAt time.Time `bson:"last_failed_at"`
+	ExpireAt     time.Time `bson:"expire_at"`
+}
+
+func NewLoginAttemptModel(db *mongo.Database) *LoginAttemptModel {
+	collection := db.Collection("login_attempts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// stale counters are removed by mongo itself
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on login_attempts: %s", err))
+	}
+
+	return &LoginAttemptModel{
+		collection: collection,
+	}
+}
+
+func (attempt *LoginAttemptModel) Get(key string) (limiter.Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the ttl monitor only runs every minute, so expiry is checked here too
+	filter := bson.M{
+		"_id": key,
+		"expire_at": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+
+	var attemptInstance LoginAttempt
+	if err := attempt.collection.FindOne(ctx, filter).Decode(&attemptInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return limiter.Attempts{}, nil
+		}
+
+		return limiter.Attempts{}, err
+	}
+
+	return limiter.Attempts{Count: attemptInstance.Count, LastFailedAt: attemptInstance.LastFailedAt}, nil
+}
+
+// Increment -> Atomic upsert. An expired counter (not yet removed by the ttl index) starts over at 1
+func (attempt *LoginAttemptModel) Increment(key string, window time.Duration) (limiter.Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"count": bson.M{
+				"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$expire_at", now}},
+					bson.M{"$add": bson.A{"$count", 1}},
+					1,
+				},
+			},
+			"last_failed_at": now,
+			"expire_at":      now.Add(window),
+		}}},
+	}
+
+	findOptions := options.FindOneAndUpdate()
+	findOptions.SetUpsert(true)
+	findOptions.SetReturnDocument(options.After)
+
+	var attemptInstance LoginAttempt
+	if err := attempt.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, findOptions).Decode(&attemptInstance); err != nil {
+		return limiter.Attempts{}, err
+	}
+
+	return limiter.Attempts{Count: attemptInstance.Count, LastFailedAt: attemptInstance.LastFailedAt}, nil
+}
+
+func (attempt *LoginAttemptModel) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := attempt.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MessageEditModel -> Earlier versions of edited messages
+type MessageEditModel struct {
+	collection *mongo.Collection
+}
+
+// MessageEdit -> One replaced version of a message. The content stays encrypted as it was, with the
+// associated data of its message
+type MessageEdit struct {
+	Id        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	MessageId primitive.ObjectID `json:"message_id" bson:"message_id"`
+	// chat_id and group_id let the history go with its chat or group
+	ChatId    primitive.ObjectID `json:"-" bson:"chat_id"`
+	GroupId   primitive.ObjectID `json:"-" bson:"group_id"`
+	Content   string             `json:"content" bson:"content"`
+	ADVersion int                `json:"-" bson:"ad_version"`
+	// WrittenAt is when the version was sent or edited in, ReplacedAt when the next edit replaced it
+	WrittenAt  time.Time `json:"written_at" bson:"written_at"`
+	ReplacedAt time.Time `json:"replaced_at" bson:"replaced_at"`
+}
+
+func NewMessageEditModel(db *mongo.Database) *MessageEditModel {
+	collection := db.Collection("message_edits")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "replaced_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "chat_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "group_id", Value: 1}},
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on message_edits: %s", err))
+	}
+
+	return &MessageEditModel{
+		collection: collection,
+	}
+}
+
+// Create -> Keeps the current version of the message, right before an edit replaces it
+func (edit *MessageEditModel) Create(message *Message, replacedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	writtenAt := message.CreatedAt
+	if message.EditedAt != nil {
+		writtenAt = *message.EditedAt
+	}
+
+	var newEdit = &MessageEdit{
+		MessageId:  message.Id,
+		ChatId:     message.ChatId,
+		GroupId:    message.GroupId,
+		Content:    message.Content,
+		ADVersion:  message.ADVersion,
+		WrittenAt:  writtenAt,
+		ReplacedAt: replacedAt,
+	}
+
+	_, err := edit.collection.InsertOne(ctx, newEdit)
+	return err
+}
+
+// GetAll -> The earlier versions of the message, oldest first
+func (edit *MessageEditModel) GetAll(messageId primitive.ObjectID) ([]MessageEdit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{
+		"replaced_at": 1,
+	})
+
+	var edits []MessageEdit
+	cursor, err := edit.collection.Find(ctx, bson.M{"message_id": messageId}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &edits); err != nil {
+		return nil, err
+	}
+
+	return edits, nil
+}
+
+func (edit *MessageEditModel) DeleteAll(filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return edit.collection.DeleteMany(ctx, filter)
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -9,13 +11,53 @@ import (
 	"time"
 )
 
+// ErrMessageExists -> The sender already stored a message with this client message id
+var ErrMessageExists = errors.New("message with this client message id exists")
+
 type MessageModel struct {
 	collection *mongo.Collection
+	sequences  *RoomSequenceModel
 }
 
 func NewMessageModel(db *mongo.Database) *MessageModel {
+	collection := db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// syncing a chat from a seq
+			Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "seq", Value: 1}},
+		},
+		{
+			// syncing a group from a seq
+			Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "seq", Value: 1}},
+		},
+		{
+			// replies of a thread
+			Keys: bson.D{{Key: "reply_to_id", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{
+				"reply_to_id": bson.M{"$exists": true},
+			}),
+		},
+		{
+			// a retried send is stored once
+			Keys: bson.D{{Key: "sender_id", Value: 1}, {Key: "client_message_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"client_message_id": bson.M{"$exists": true},
+			}),
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on messages: %s", err))
+	}
+
 	return &MessageModel{
-		collection: db.Collection("messages"),
+		collection: collection,
+		sequences:  NewRoomSequenceModel(db),
 	}
 }
 
@@ -34,27 +76,113 @@ type Message struct {
 	IsDeletedForSender bool       `json:"is_deleted_for_sender" bson:"is_deleted_for_sender"`
 	EditedAt           *time.Time `json:"edited_at" bson:"edited_at"`
 	CreatedAt          time.Time  `json:"created_at" bson:"created_at"`
+	// 0 for content encrypted before the associated data existed, otherwise MessageADVersion
+	ADVersion int `json:"-" bson:"ad_version"`
+	// Seq numbers the messages of a chat or group, 0 for messages stored before it existed
+	Seq int64 `json:"seq" bson:"seq"`
+	// ClientMessageId is chosen by the sending client, unique per sender. Empty for messages sent without one
+	ClientMessageId string `json:"client_message_id,omitempty" bson:"client_message_id,omitempty"`
+	// ReplyToId is a message of the same chat or group
+	ReplyToId *primitive.ObjectID `json:"reply_to_id,omitempty" bson:"reply_to_id,omitempty"`
+	// ReplyTo is filled in by the listings, nil when the parent is gone
+	ReplyTo *MessagePreview `json:"reply_to,omitempty" bson:"-"`
+	// Reactions holds the users of every emoji, the listings return ReactionCounts instead
+	Reactions      map[string][]primitive.ObjectID `json:"-" bson:"reactions,omitempty"`
+	ReactionCounts []ReactionCount                 `json:"reactions,omitempty" bson:"-"`
 }
 
-func (message *MessageModel) Create(chatId, groupId, senderId, receiverId primitive.ObjectID, contentType, contentAddress,
-	content string, isSecret bool) (*mongo.InsertOneResult, error) {
+// ReactionCount -> Users who reacted to a message with the emoji
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// MessagePreview -> Start of the decrypted content of a message, quoted by its replies
+type MessagePreview struct {
+	Id             primitive.ObjectID `json:"id"`
+	SenderId       primitive.ObjectID `json:"sender_id"`
+	Type           string             `json:"type"`
+	Content        string             `json:"content"`
+	ContentAddress string             `json:"content_address"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+// MessageADVersion -> Layout of the associated data the content is encrypted with
+const MessageADVersion = 1
+
+// MessageAD -> Associated data of a message's content. A ciphertext copied to another message,
+// chat, group or sender doesn't decrypt
+func MessageAD(messageId, chatId, groupId, senderId primitive.ObjectID) []byte {
+	ad := make([]byte, 0, 2+4*len(messageId))
+	ad = append(ad, 'm', MessageADVersion)
+	ad = append(ad, messageId[:]...)
+	ad = append(ad, chatId[:]...)
+	ad = append(ad, groupId[:]...)
+
+	return append(ad, senderId[:]...)
+}
+
+// KeyOwner -> Data key owner of the message, its group or its chat
+func (message *Message) KeyOwner() string {
+	if !message.GroupId.IsZero() {
+		return GroupKeyOwner(message.GroupId)
+	}
+
+	return ChatKeyOwner(message.ChatId)
+}
+
+// AssociatedData -> Needs _id, chat_id, group_id and sender_id in the projection
+func (message *Message) AssociatedData() []byte {
+	return MessageAD(message.Id, message.ChatId, message.GroupId, message.SenderId)
+}
+
+// Create -> The id is chosen by the caller, since the content is encrypted with it before the insert.
+// Returns the stored message, with the next seq of its chat or group. ErrMessageExists when the sender
+// used clientMessageId before. replyToId is left out when zero
+func (message *MessageModel) Create(id, chatId, groupId, senderId, receiverId primitive.ObjectID, contentType, contentAddress,
+	content, clientMessageId string, replyToId primitive.ObjectID, isSecret bool) (*Message, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var newUser = &Message{
-		ChatId:         chatId,
-		GroupId:        groupId,
-		SenderId:       senderId,
-		ReceiverId:     receiverId,
-		Content:        content,
-		Type:           contentType,
-		ContentAddress: contentAddress,
-		IsSecret:       isSecret,
-		CreatedAt:      time.Now(),
+	var newMessage = &Message{
+		Id:              id,
+		ChatId:          chatId,
+		GroupId:         groupId,
+		SenderId:        senderId,
+		ReceiverId:      receiverId,
+		Content:         content,
+		Type:            contentType,
+		ContentAddress:  contentAddress,
+		IsSecret:        isSecret,
+		ClientMessageId: clientMessageId,
+		CreatedAt:       time.Now(),
+		ADVersion:       MessageADVersion,
 	}
 
-	return message.collection.InsertOne(ctx, newUser)
+	if !replyToId.IsZero() {
+		newMessage.ReplyToId = &replyToId
+	}
+
+	// a failed insert leaves a gap in the numbering, never a duplicate. Concurrent sends can be stored out
+	// of seq order, so sync waits a moment at a gap
+	seq, err := message.sequences.Next(newMessage.KeyOwner())
+	if err != nil {
+		return nil, err
+	}
+
+	newMessage.Seq = seq
+
+	if _, err := message.collection.InsertOne(ctx, newMessage); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrMessageExists
+		}
+
+		return nil, err
+	}
+
+	return newMessage, nil
 }
 
 func (message *MessageModel) GetAll(filter, projection bson.M, page, pageLimit int64) ([]Message, error) {
@@ -121,3 +249,181 @@ func (message *MessageModel) Update(filter, updates bson.M) (*mongo.UpdateResult
 
 	return message.collection.UpdateOne(ctx, filter, update)
 }
+
+// GetAfterSeq -> Messages of the chat or group matched by roomFilter with a seq above afterSeq, in seq order
+func (message *MessageModel) GetAfterSeq(roomFilter bson.M, afterSeq, limit int64) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"seq": bson.M{
+			"$gt": afterSeq,
+		},
+	}
+
+	for key, value := range roomFilter {
+		filter[key] = value
+	}
+
+	findOptions := options.Find()
+	findOptions.SetLimit(limit)
+	findOptions.SetSort(bson.M{
+		"seq": 1,
+	})
+
+	var messages []Message
+	cursor, err := message.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// CountUnread -> Messages of the chat or group matched by roomFilter after seq that others sent. Counting
+// stops at limit, so a large backlog costs no more than limit index entries
+func (message *MessageModel) CountUnread(roomFilter bson.M, afterSeq int64, userId primitive.ObjectID,
+	limit int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"seq": bson.M{
+			"$gt": afterSeq,
+		},
+		"sender_id": bson.M{
+			"$ne": userId,
+		},
+	}
+
+	for key, value := range roomFilter {
+		filter[key] = value
+	}
+
+	return message.collection.CountDocuments(ctx, filter, options.Count().SetLimit(limit))
+}
+
+// AddReaction -> Adds the user to the emoji of the message matched by filter. A new emoji is only added while
+// the message has fewer than maxEmojis, so nothing matches once the cap is reached
+func (message *MessageModel) AddReaction(filter bson.M, emoji string, userId primitive.ObjectID,
+	maxEmojis int) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	field := "reactions." + emoji
+
+	capFilter := bson.M{
+		"$or": []bson.M{
+			{field: bson.M{"$exists": true}},
+			{"$expr": bson.M{
+				"$lt": []any{
+					bson.M{"$size": bson.M{"$objectToArray": bson.M{"$ifNull": []any{"$reactions", bson.M{}}}}},
+					maxEmojis,
+				},
+			}},
+		},
+	}
+
+	update := bson.M{
+		"$addToSet": bson.M{
+			field: userId,
+		},
+	}
+
+	return message.collection.UpdateOne(ctx, bson.M{"$and": []bson.M{filter, capFilter}}, update)
+}
+
+// RemoveReaction -> Removes the user from the emoji of the message matched by filter, and the emoji once
+// nobody is left
+func (message *MessageModel) RemoveReaction(filter bson.M, emoji string, userId primitive.ObjectID) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	field := "reactions." + emoji
+
+	update := bson.M{
+		"$pull": bson.M{
+			field: userId,
+		},
+	}
+
+	result, err := message.collection.UpdateOne(ctx, filter, update)
+	if err != nil || result.ModifiedCount == 0 {
+		return result, err
+	}
+
+	emptyFilter := bson.M{
+		"$and": []bson.M{filter, {field: bson.M{"$size": 0}}},
+	}
+
+	if _, err := message.collection.UpdateOne(ctx, emptyFilter, bson.M{"$unset": bson.M{field: ""}}); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetReplies -> Direct replies to any of the parents, oldest first
+func (message *MessageModel) GetReplies(parentIds []primitive.ObjectID, limit int64) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"reply_to_id": bson.M{
+			"$in": parentIds,
+		},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetLimit(limit)
+	findOptions.SetSort(bson.M{
+		"created_at": 1,
+	})
+
+	var messages []Message
+	cursor, err := message.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetBatch -> Messages after afterId in _id order, for jobs that walk the whole collection
+func (message *MessageModel) GetBatch(afterId primitive.ObjectID, projection bson.M, limit int64) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": bson.M{
+			"$gt": afterId,
+		},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetLimit(limit)
+	findOptions.SetSort(bson.M{
+		"_id": 1,
+	})
+
+	var messages []Message
+	cursor, err := message.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
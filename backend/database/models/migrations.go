@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MigrationModel struct {
+	collection *mongo.Collection
+}
+
+// Migration -> Checkpoint of a batch job, so it can resume after an interruption
+type Migration struct {
+	Name string `json:"name" bson:"_id"`
+	// last processed document, the job continues after it
+	Checkpoint primitive.ObjectID `json:"checkpoint" bson:"checkpoint"`
+	// what the job migrates to, e.g. the encryption key version. A new target starts the job over
+	Target    int64     `json:"target" bson:"target"`
+	Processed int64     `json:"processed" bson:"processed"`
+	Completed bool      `json:"completed" bson:"completed"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func NewMigrationModel(db *mongo.Database) *MigrationModel {
+	return &MigrationModel{
+		collection: db.Collection("migrations"),
+	}
+}
+
+func (migration *MigrationModel) Get(name string) (*Migration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var migrationInstance Migration
+	if err := migration.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&migrationInstance); err != nil {
+		return nil, err
+	}
+
+	return &migrationInstance, nil
+}
+
+// Save -> Upserts the checkpoint
+func (migration *MigrationModel) Save(migrationInstance *Migration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	migrationInstance.UpdatedAt = time.Now()
+
+	_, err := migration.collection.ReplaceOne(ctx, bson.M{"_id": migrationInstance.Name}, migrationInstance,
+		options.Replace().SetUpsert(true))
+
+	return err
+}
@@ -3,23 +3,45 @@ package models
 import "go.mongodb.org/mongo-driver/mongo"
 
 type Models struct {
-	User        *UserModel
-	Chat        *ChatModel
-	SecretChat  *SecretChatModel
-	Message     *MessageModel
-	SaveMessage *SaveMessageModel
-	Group       *GroupModel
-	Approval    *ApprovalModel
+	User          *UserModel
+	Chat          *ChatModel
+	SecretChat    *SecretChatModel
+	Message       *MessageModel
+	SaveMessage   *SaveMessageModel
+	Group         *GroupModel
+	Approval      *ApprovalModel
+	Session       *SessionModel
+	APIKey        *APIKeyModel
+	LoginAttempt  *LoginAttemptModel
+	PasswordReset *PasswordResetModel
+	Migration     *MigrationModel
+	DataKey       *DataKeyModel
+	BrokerEvent   *BrokerEventModel
+	RoomSequence  *RoomSequenceModel
+	MessageEdit   *MessageEditModel
+	Pin           *PinModel
+	ReadMarker    *ReadMarkerModel
 }
 
 func New(db *mongo.Database) *Models {
 	return &Models{
-		User:        NewUserModel(db),
-		Chat:        NewChatModel(db),
-		SecretChat:  NewSecretChatModel(db),
-		Message:     NewMessageModel(db),
-		SaveMessage: NewSaveMessageModel(db),
-		Group:       NewGroupModel(db),
-		Approval:    NewApprovalModel(db),
+		User:          NewUserModel(db),
+		Chat:          NewChatModel(db),
+		SecretChat:    NewSecretChatModel(db),
+		Message:       NewMessageModel(db),
+		SaveMessage:   NewSaveMessageModel(db),
+		Group:         NewGroupModel(db),
+		Approval:      NewApprovalModel(db),
+		Session:       NewSessionModel(db),
+		APIKey:        NewAPIKeyModel(db),
+		LoginAttempt:  NewLoginAttemptModel(db),
+		PasswordReset: NewPasswordResetModel(db),
+		Migration:     NewMigrationModel(db),
+		DataKey:       NewDataKeyModel(db),
+		BrokerEvent:   NewBrokerEventModel(db),
+		RoomSequence:  NewRoomSequenceModel(db),
+		MessageEdit:   NewMessageEditModel(db),
+		Pin:           NewPinModel(db),
+		ReadMarker:    NewReadMarkerModel(db),
 	}
 }
@@ -29,7 +29,7 @@ func setupModelsTestDB(t testing.TB) {
 func cleanupModelsTestDB(t testing.TB) {
 	if modelsTestDB != nil {
 		// Clean up test data by dropping all collections
-		collections := []string{"users", "chats", "secret_chats", "messages", "save_messages", "groups", "approvals"}
+		collections := []string{"users", "chats", "secret_chats", "messages", "save_messages", "groups", "approvals", "sessions", "api_keys", "login_attempts", "password_resets", "migrations", "data_keys", "broker_events", "room_sequences", "message_edits", "pins", "read_markers"}
 		for _, collectionName := range collections {
 			err := modelsTestDB.Collection(collectionName).Drop(context.Background())
 			if err != nil {
@@ -80,6 +80,39 @@ func TestNew(t *testing.T) {
 	if models.Approval == nil {
 		t.Error("Expected Approval model, got nil")
 	}
+	if models.Session == nil {
+		t.Error("Expected Session model, got nil")
+	}
+	if models.APIKey == nil {
+		t.Error("Expected APIKey model, got nil")
+	}
+	if models.LoginAttempt == nil {
+		t.Error("Expected LoginAttempt model, got nil")
+	}
+	if models.PasswordReset == nil {
+		t.Error("Expected PasswordReset model, got nil")
+	}
+	if models.Migration == nil {
+		t.Error("Expected Migration model, got nil")
+	}
+	if models.DataKey == nil {
+		t.Error("Expected DataKey model, got nil")
+	}
+	if models.BrokerEvent == nil {
+		t.Error("Expected BrokerEvent model, got nil")
+	}
+	if models.RoomSequence == nil {
+		t.Error("Expected RoomSequence model, got nil")
+	}
+	if models.MessageEdit == nil {
+		t.Error("Expected MessageEdit model, got nil")
+	}
+	if models.Pin == nil {
+		t.Error("Expected Pin model, got nil")
+	}
+	if models.ReadMarker == nil {
+		t.Error("Expected ReadMarker model, got nil")
+	}
 }
 
 func TestNewWithNilDatabase(t *testing.T) {
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PasswordResetModel struct {
+	collection *mongo.Collection
+}
+
+// PasswordReset -> Single use token issued by an admin. Only the hash of the token is stored
+type PasswordReset struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	IssuedBy    primitive.ObjectID `json:"issued_by" bson:"issued_by"`
+	HashedToken string             `json:"-" bson:"hashed_token"`
+	ExpireAt    time.Time          `json:"expire_at" bson:"expire_at"`
+	UsedAt      *time.Time         `json:"used_at,omitempty" bson:"used_at"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+func NewPasswordResetModel(db *mongo.Database) *PasswordResetModel {
+	collection := db.Collection("password_resets")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hashed_token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			// expired tokens are removed by mongo itself
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on password_resets: %s", err))
+	}
+
+	return &PasswordResetModel{
+		collection: collection,
+	}
+}
+
+func (reset *PasswordResetModel) Create(userId, issuedBy primitive.ObjectID, hashedToken string,
+	expireAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newReset := &PasswordReset{
+		UserId:      userId,
+		IssuedBy:    issuedBy,
+		HashedToken: hashedToken,
+		ExpireAt:    expireAt,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err := reset.collection.InsertOne(ctx, newReset)
+	return err
+}
+
+func (reset *PasswordResetModel) Get(filter, projection bson.M) (*PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var resetInstance PasswordReset
+	if err := reset.collection.FindOne(ctx, filter, findOptions).Decode(&resetInstance); err != nil {
+		return nil, err
+	}
+
+	return &resetInstance, nil
+}
+
+func (reset *PasswordResetModel) Update(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	return reset.collection.UpdateOne(ctx, filter, update)
+}
+
+func (reset *PasswordResetModel) DeleteAll(filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return reset.collection.DeleteMany(ctx, filter)
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrAlreadyPinned -> The message is pinned already
+	ErrAlreadyPinned = errors.New("message is pinned already")
+	// ErrTooManyPins -> The chat or group has the most pins already
+	ErrTooManyPins = errors.New("the most messages are pinned already")
+)
+
+// PinModel -> Pinned messages of chats and groups, several per chat or group in the order of Position
+type PinModel struct {
+	collection *mongo.Collection
+}
+
+type Pin struct {
+	Id        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	MessageId primitive.ObjectID `json:"message_id" bson:"message_id"`
+	// one of them is set, like on the message
+	ChatId   primitive.ObjectID `json:"chat_id,omitempty" bson:"chat_id,omitempty"`
+	GroupId  primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	PinnedBy primitive.ObjectID `json:"pinned_by" bson:"pinned_by"`
+	Position int64              `json:"position" bson:"position"`
+	PinnedAt time.Time          `json:"pinned_at" bson:"pinned_at"`
+}
+
+func NewPinModel(db *mongo.Database) *PinModel {
+	collection := db.Collection("pins")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "message_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "position", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "position", Value: 1}},
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on pins: %s", err))
+	}
+
+	return &PinModel{
+		collection: collection,
+	}
+}
+
+// Create -> Pins the message after the last pin of its chat or group. ErrAlreadyPinned when it is pinned,
+// ErrTooManyPins when the chat or group would have more than limit pins
+func (pin *PinModel) Create(message *Message, pinnedBy primitive.ObjectID, limit int64) (*Pin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var newPin = &Pin{
+		Id:        primitive.NewObjectID(),
+		MessageId: message.Id,
+		ChatId:    message.ChatId,
+		GroupId:   message.GroupId,
+		PinnedBy:  pinnedBy,
+		PinnedAt:  time.Now(),
+	}
+
+	roomFilter := bson.M{"chat_id": message.ChatId}
+	if !message.GroupId.IsZero() {
+		roomFilter = bson.M{"group_id": message.GroupId}
+	}
+
+	findOptions := options.FindOne()
+	findOptions.SetSort(bson.M{"position": -1})
+
+	var lastPin Pin
+	err := pin.collection.FindOne(ctx, roomFilter, findOptions).Decode(&lastPin)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if err == nil {
+		newPin.Position = lastPin.Position + 1
+	}
+
+	if _, err := pin.collection.InsertOne(ctx, newPin); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyPinned
+		}
+
+		return nil, err
+	}
+
+	// counted after the insert, so pins made at the same time can't pass the limit together. Both may be
+	// taken back, never kept
+	count, err := pin.collection.CountDocuments(ctx, roomFilter)
+	if err == nil && count <= limit {
+		return newPin, nil
+	}
+
+	if _, deleteErr := pin.collection.DeleteOne(ctx, bson.M{"_id": newPin.Id}); deleteErr != nil {
+		return nil, errors.Join(err, deleteErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTooManyPins
+}
+
+// GetAll -> Pins of the chat or group matched by roomFilter, in order
+func (pin *PinModel) GetAll(roomFilter bson.M) ([]Pin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "position", Value: 1}, {Key: "pinned_at", Value: 1}})
+
+	var pins []Pin
+	cursor, err := pin.collection.Find(ctx, roomFilter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &pins); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+func (pin *PinModel) Count(roomFilter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return pin.collection.CountDocuments(ctx, roomFilter)
+}
+
+// SetPositions -> Numbers the pins of the messages in the given order. Pins of other chats or groups are not
+// matched by roomFilter and left alone
+func (pin *PinModel) SetPositions(roomFilter bson.M, messageIds []primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	updates := make([]mongo.WriteModel, 0, len(messageIds))
+	for position, messageId := range messageIds {
+		filter := bson.M{"message_id": messageId}
+		for key, value := range roomFilter {
+			filter[key] = value
+		}
+
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$set": bson.M{"position": int64(position)}}))
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	_, err := pin.collection.BulkWrite(ctx, updates)
+	return err
+}
+
+func (pin *PinModel) Delete(filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return pin.collection.DeleteOne(ctx, filter)
+}
+
+func (pin *PinModel) DeleteAll(filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return pin.collection.DeleteMany(ctx, filter)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPinModelCreateLimit(t *testing.T) {
+	setupTestDB(t)
+	defer cleanupTestDB(t)
+
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	pinModel := NewPinModel(testDB)
+	defer testDB.Collection("pins").Drop(context.Background())
+
+	t.Run("Pin Past The Limit", func(t *testing.T) {
+		chatId := primitive.NewObjectID()
+
+		for idx := 0; idx < 2; idx++ {
+			message := &Message{Id: primitive.NewObjectID(), ChatId: chatId}
+			if _, err := pinModel.Create(message, primitive.NewObjectID(), 2); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		message := &Message{Id: primitive.NewObjectID(), ChatId: chatId}
+		if _, err := pinModel.Create(message, primitive.NewObjectID(), 2); !errors.Is(err, ErrTooManyPins) {
+			t.Errorf("Expected %v, got %v", ErrTooManyPins, err)
+		}
+
+		if count, _ := pinModel.Count(bson.M{"chat_id": chatId}); count != 2 {
+			t.Errorf("Expected 2 pins, got %d", count)
+		}
+	})
+
+	t.Run("Concurrent Pins Stay Within The Limit", func(t *testing.T) {
+		const limit = 5
+		groupId := primitive.NewObjectID()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 4*limit)
+
+		for idx := 0; idx < 4*limit; idx++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				message := &Message{Id: primitive.NewObjectID(), GroupId: groupId}
+				_, err := pinModel.Create(message, primitive.NewObjectID(), limit)
+				errs <- err
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil && !errors.Is(err, ErrTooManyPins) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+
+		if count, _ := pinModel.Count(bson.M{"group_id": groupId}); count > limit {
+			t.Errorf("Expected at most %d pins, got %d", limit, count)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReadMarkerModel -> How far each user has read each chat or group, one document per user and room
+type ReadMarkerModel struct {
+	collection *mongo.Collection
+}
+
+type ReadMarker struct {
+	Id     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserId primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// Room is the data key owner of the chat or group, like in RoomSequence
+	Room string `json:"room" bson:"room"`
+	// Seq of the last message read, the messages up to it count as read
+	Seq    int64     `json:"seq" bson:"seq"`
+	ReadAt time.Time `json:"read_at" bson:"read_at"`
+}
+
+func NewReadMarkerModel(db *mongo.Database) *ReadMarkerModel {
+	collection := db.Collection("read_markers")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "room", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// markers of the other participant, and deleting a room's markers
+			Keys: bson.D{{Key: "room", Value: 1}},
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on read_markers: %s", err))
+	}
+
+	return &ReadMarkerModel{
+		collection: collection,
+	}
+}
+
+// Advance -> Moves the user's marker of the room up to seq, never back. One upsert touching a single
+// document, however large the group is. False when the marker was at seq or past it already
+func (marker *ReadMarkerModel) Advance(userId primitive.ObjectID, room string, seq int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id": userId,
+		"room":    room,
+	}
+
+	update := bson.M{
+		"$max": bson.M{
+			"seq":     seq,
+			"read_at": time.Now(),
+		},
+	}
+
+	updateOptions := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.Before).
+		SetProjection(bson.M{"seq": 1})
+
+	var before ReadMarker
+	err := marker.collection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&before)
+	if err != nil {
+		// there was no marker, the upsert created it
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return before.Seq < seq, nil
+}
+
+// GetSeqs -> Seq of each room's marker matched by filter, keyed by room. Rooms without one are left out
+func (marker *ReadMarkerModel) GetSeqs(filter bson.M) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.M{"room": 1, "seq": 1})
+
+	var markers []ReadMarker
+	cursor, err := marker.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &markers); err != nil {
+		return nil, err
+	}
+
+	seqs := make(map[string]int64, len(markers))
+	for _, readMarker := range markers {
+		seqs[readMarker.Room] = readMarker.Seq
+	}
+
+	return seqs, nil
+}
+
+func (marker *ReadMarkerModel) DeleteAll(filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return marker.collection.DeleteMany(ctx, filter)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RoomSequenceModel -> Counter per chat or group, numbering its messages 1, 2, 3...
+type RoomSequenceModel struct {
+	collection *mongo.Collection
+}
+
+type RoomSequence struct {
+	// Room is the data key owner of the chat or group, see Message.KeyOwner
+	Room string `json:"room" bson:"_id"`
+	Seq  int64  `json:"seq" bson:"seq"`
+}
+
+func NewRoomSequenceModel(db *mongo.Database) *RoomSequenceModel {
+	return &RoomSequenceModel{
+		collection: db.Collection("room_sequences"),
+	}
+}
+
+// Next -> Increments the counter of the room atomically, the first call returns 1
+func (sequence *RoomSequenceModel) Next(room string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": room,
+	}
+
+	update := bson.M{
+		"$inc": bson.M{
+			"seq": 1,
+		},
+	}
+
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var sequenceInstance RoomSequence
+	if err := sequence.collection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&sequenceInstance); err != nil {
+		return 0, err
+	}
+
+	return sequenceInstance.Seq, nil
+}
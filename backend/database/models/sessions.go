@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SessionModel struct {
+	collection *mongo.Collection
+}
+
+// Session -> One logged in device. Id is the session id carried in every paseto payload
+type Session struct {
+	Id     string             `json:"id" bson:"_id"`
+	UserId primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// id of the only refresh token that may still be exchanged (rotates on every refresh)
+	RefreshTokenId string     `json:"-" bson:"refresh_token_id"`
+	Device         string     `json:"device" bson:"device"`
+	IP             string     `json:"ip" bson:"ip"`
+	LastSeenAt     time.Time  `json:"last_seen_at" bson:"last_seen_at"`
+	ExpireAt       time.Time  `json:"expire_at" bson:"expire_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" bson:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+}
+
+func NewSessionModel(db *mongo.Database) *SessionModel {
+	collection := db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			// expired sessions are removed by mongo itself
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating index on sessions: %s", err))
+	}
+
+	return &SessionModel{
+		collection: collection,
+	}
+}
+
+func (session *SessionModel) Create(sessionId string, userId primitive.ObjectID, refreshTokenId, device, ip string,
+	expireAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newSession := &Session{
+		Id:             sessionId,
+		UserId:         userId,
+		RefreshTokenId: refreshTokenId,
+		Device:         device,
+		IP:             ip,
+		LastSeenAt:     time.Now(),
+		ExpireAt:       expireAt,
+		CreatedAt:      time.Now(),
+	}
+
+	_, err := session.collection.InsertOne(ctx, newSession)
+	return err
+}
+
+func (session *SessionModel) Get(filter, projection bson.M) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var sessionInstance Session
+	if err := session.collection.FindOne(ctx, filter, findOptions).Decode(&sessionInstance); err != nil {
+		return nil, err
+	}
+
+	return &sessionInstance, nil
+}
+
+func (session *SessionModel) GetAll(filter, projection bson.M, page, pageLimit int64) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSkip((page - 1) * pageLimit)
+	findOptions.SetLimit(pageLimit)
+	findOptions.SetSort(bson.M{
+		"last_seen_at": -1,
+	})
+
+	var sessions []Session
+	cursor, err := session.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (session *SessionModel) Update(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	return session.collection.UpdateOne(ctx, filter, update)
+}
+
+func (session *SessionModel) UpdateAll(filter, updates bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	return session.collection.UpdateMany(ctx, filter, update)
+}
+
+// Revoke -> Marks one session of the user as revoked
+func (session *SessionModel) Revoke(sessionId string, userId primitive.ObjectID) (*mongo.UpdateResult, error) {
+	filter := bson.M{
+		"_id":        sessionId,
+		"user_id":    userId,
+		"revoked_at": nil,
+	}
+
+	return session.Update(filter, bson.M{"revoked_at": time.Now()})
+}
+
+// RevokeAllExcept -> Revokes every active session of the user except keepSessionId (pass "" to revoke all)
+func (session *SessionModel) RevokeAllExcept(userId primitive.ObjectID, keepSessionId string) (*mongo.UpdateResult, error) {
+	filter := bson.M{
+		"user_id":    userId,
+		"revoked_at": nil,
+	}
+
+	if keepSessionId != "" {
+		filter["_id"] = bson.M{"$ne": keepSessionId}
+	}
+
+	return session.UpdateAll(filter, bson.M{"revoked_at": time.Now()})
+}
+
+// IsRevoked -> Implements paseto.SessionChecker. Unknown and expired sessions count as revoked
+func (session *SessionModel) IsRevoked(sessionId string) bool {
+	filter := bson.M{
+		"_id":        sessionId,
+		"revoked_at": nil,
+		"expire_at": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	_, err := session.Get(filter, projection)
+	return err != nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -20,7 +21,17 @@ type User struct {
 	Username       string             `json:"username" bson:"username"`
 	HashedPassword string             `json:"hashed_password" bson:"hashed_password"`
 	AvatarUrl      string             `json:"avatar_url" bson:"avatar_url"`
-	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	// two-factor auth. The secret is encrypted, recovery codes are hashed and removed once used
+	TOTPEnabled   bool     `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret    []byte   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPLastStep  int64    `json:"-" bson:"totp_last_step,omitempty"` // last accepted time step, against code replay
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+	// set after too many failed logins, no password is checked before it passes
+	LockedUntil *time.Time `json:"-" bson:"locked_until,omitempty"`
+	// set when the last device disconnects, only shown through the presence of the user
+	LastSeenAt   *time.Time `json:"-" bson:"last_seen_at,omitempty"`
+	HideLastSeen bool       `json:"hide_last_seen" bson:"hide_last_seen"`
+	CreatedAt    time.Time  `json:"created_at" bson:"created_at"`
 }
 
 func NewUserModel(db *mongo.Database) *UserModel {
@@ -84,6 +95,28 @@ func (user *UserModel) Get(filter bson.M, projection bson.M) (*User, error) {
 	return &userInstance, nil
 }
 
+func (user *UserModel) GetAll(filter bson.M, projection bson.M, page, pageLimit int64) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSkip((page - 1) * pageLimit)
+	findOptions.SetLimit(pageLimit)
+
+	var users []User
+	cursor, err := user.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (user *UserModel) Delete(filter bson.M) (*mongo.DeleteResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -101,3 +134,54 @@ func (user *UserModel) Update(filter bson.M, updates bson.M) (*mongo.UpdateResul
 
 	return user.collection.UpdateOne(ctx, filter, update)
 }
+
+// UseRecoveryCode -> Removes the hashed recovery code. Matches nothing when the code was already used
+func (user *UserModel) UseRecoveryCode(userId primitive.ObjectID, hashedCode string) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":            userId,
+		"recovery_codes": hashedCode,
+	}
+
+	update := bson.M{
+		"$pull": bson.M{
+			"recovery_codes": hashedCode,
+		},
+	}
+
+	return user.collection.UpdateOne(ctx, filter, update)
+}
+
+// SetLastSeen -> Returns the user with its privacy settings, nil when there is no such user
+func (user *UserModel) SetLastSeen(userId primitive.ObjectID, lastSeenAt time.Time) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": userId,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"last_seen_at": lastSeenAt,
+		},
+	}
+
+	findOptions := options.FindOneAndUpdate()
+	findOptions.SetProjection(bson.M{"hide_last_seen": 1, "last_seen_at": 1})
+	findOptions.SetReturnDocument(options.After)
+
+	var userInstance User
+	err := user.collection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&userInstance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &userInstance, nil
+}
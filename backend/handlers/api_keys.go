@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"chat_app/paseto"
+	"chat_app/utils"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// API key scopes are "<resource>:read" or "<resource>:write". Each resource is one route group
+const (
+	ScopeChats         = "chats"
+	ScopeMessages      = "messages"
+	ScopeGroups        = "groups"
+	ScopeSavedMessages = "saved-messages"
+	ScopeApprovals     = "approvals"
+)
+
+const apiKeyPrefix = "cak_"
+
+var apiKeyResources = []string{ScopeChats, ScopeMessages, ScopeGroups, ScopeSavedMessages, ScopeApprovals}
+
+// IsAPIKey -> Tells personal api keys apart from paseto tokens
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// RequiredScope -> Safe methods need "<resource>:read", everything else "<resource>:write". Routes with side
+// effects must not be GET or HEAD, see readOnlyGetRoutes in the webserver tests
+func RequiredScope(resource, method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return resource + ":read"
+	}
+
+	return resource + ":write"
+}
+
+// hasScope -> A write scope implies the read scope of the same resource
+func hasScope(granted []string, required string) bool {
+	if slices.Contains(granted, required) {
+		return true
+	}
+
+	resource, access, _ := strings.Cut(required, ":")
+	return access == "read" && slices.Contains(granted, resource+":write")
+}
+
+func validScope(scope string) bool {
+	resource, access, found := strings.Cut(scope, ":")
+	if !found || (access != "read" && access != "write") {
+		return false
+	}
+
+	return slices.Contains(apiKeyResources, resource)
+}
+
+// AuthenticateAPIKey -> Verifies the key and its scope, and returns a payload for the key owner
+func (handler *Handler) AuthenticateAPIKey(key, requiredScope string) (*paseto.Payload, *utils.ErrorResponse) {
+	filter := bson.M{
+		"hashed_key": utils.HashToken(key),
+		"revoked_at": nil,
+	}
+
+	keyInstance, err := handler.Models.APIKey.Get(filter, bson.M{})
+	if err != nil {
+		return nil, &utils.ErrorResponse{Type: "apiKeyNotValid", Detail: "api key is invalid or revoked"}
+	}
+
+	if keyInstance.ExpireAt != nil && time.Now().After(*keyInstance.ExpireAt) {
+		return nil, &utils.ErrorResponse{Type: "apiKeyNotValid", Detail: "api key has expired"}
+	}
+
+	if !hasScope(keyInstance.Scopes, requiredScope) {
+		return nil, &utils.ErrorResponse{Type: "apiKeyScope", Detail: fmt.Sprintf("api key lacks the %s scope", requiredScope)}
+	}
+
+	if _, err := handler.Models.APIKey.Update(bson.M{"_id": keyInstance.Id}, bson.M{"last_used_at": time.Now()}); err != nil {
+		slog.Warn("updating api key last use", "error", err, "api_key_id", keyInstance.Id.Hex())
+	}
+
+	payload := &paseto.Payload{
+		Kind:      paseto.APIKey,
+		UserId:    keyInstance.UserId,
+		Username:  keyInstance.Username,
+		CreatedAt: keyInstance.CreatedAt,
+	}
+
+	return payload, nil
+}
+
+func (handler *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 means no expiry
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	if input.Name == "" {
+		utils.WriteError(w, http.StatusBadRequest, "apiKeyName", "name is required")
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "apiKeyScope", "at least one scope is required")
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !validScope(scope) {
+			utils.WriteError(w, http.StatusBadRequest, "apiKeyScope", fmt.Sprintf("scope '%s' is invalid. Must be "+
+				"<resource>:read or <resource>:write with resource in: %s", scope, strings.Join(apiKeyResources, ", ")))
+			return
+		}
+	}
+
+	if input.ExpiresInDays < 0 {
+		utils.WriteError(w, http.StatusBadRequest, "apiKeyExpiry", "expires_in_days can not be negative")
+		return
+	}
+
+	var expireAt *time.Time
+	if input.ExpiresInDays > 0 {
+		expiry := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		expireAt = &expiry
+	}
+
+	key := apiKeyPrefix + rand.Text()
+	prefix := key[:len(apiKeyPrefix)+6]
+
+	result, err := handler.Models.APIKey.Create(payload.UserId, payload.Username, input.Name, prefix,
+		utils.HashToken(key), input.Scopes, expireAt)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "createApiKey", err.Error())
+		return
+	}
+
+	// the plain key is only shown once
+	resp := map[string]any{
+		"api_key_id": result.InsertedID.(primitive.ObjectID).Hex(),
+		"api_key":    key,
+		"scopes":     input.Scopes,
+		"expire_at":  expireAt,
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+func (handler *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{
+		"user_id":    payload.UserId,
+		"revoked_at": nil,
+	}
+
+	page, pageLimit, errResp := utils.ParsePageAndLimitQueryParams(r.URL)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	keys, err := handler.Models.APIKey.GetAll(filter, bson.M{}, page, pageLimit)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utils.WriteError(w, http.StatusInternalServerError, "getApiKeys", err.Error())
+		return
+	}
+
+	resp := map[string]any{
+		"api_keys": keys,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (handler *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	apiKeyId := chi.URLParam(r, "api_key_id")
+	if apiKeyId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "api key id is missing")
+		return
+	}
+
+	apiKeyObjectId, errResp := utils.ToObjectId(apiKeyId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        apiKeyObjectId,
+		"user_id":    payload.UserId,
+		"revoked_at": nil,
+	}
+
+	result, err := handler.Models.APIKey.Update(filter, bson.M{"revoked_at": time.Now()})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "revokeApiKey", err.Error())
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "revokeApiKey", "no active api key with this id exists")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "api key revoked successfully")
+}
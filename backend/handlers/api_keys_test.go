@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method   string
+		expected string
+	}{
+		{http.MethodGet, "messages:read"},
+		{http.MethodHead, "messages:read"},
+		{http.MethodPost, "messages:write"},
+		{http.MethodPut, "messages:write"},
+		{http.MethodDelete, "messages:write"},
+	}
+
+	for _, tt := range tests {
+		if got := RequiredScope(ScopeMessages, tt.method); got != tt.expected {
+			t.Errorf("RequiredScope(%s) = %s, expected %s", tt.method, got, tt.expected)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required string
+		expected bool
+	}{
+		{"Exact read scope", []string{"messages:read"}, "messages:read", true},
+		{"Write implies read", []string{"messages:write"}, "messages:read", true},
+		{"Read does not imply write", []string{"messages:read"}, "messages:write", false},
+		{"Other resource", []string{"groups:write"}, "messages:read", false},
+		{"No scopes", nil, "messages:read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasScope(tt.granted, tt.required); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	valid := []string{"chats:read", "messages:write", "saved-messages:read", "approvals:write"}
+	for _, scope := range valid {
+		if !validScope(scope) {
+			t.Errorf("Expected %q to be valid", scope)
+		}
+	}
+
+	invalid := []string{"", "messages", "messages:admin", "users:read", "secret-chats:read"}
+	for _, scope := range invalid {
+		if validScope(scope) {
+			t.Errorf("Expected %q to be invalid", scope)
+		}
+	}
+}
+
+func TestIsAPIKey(t *testing.T) {
+	if !IsAPIKey("cak_ABCDEF") {
+		t.Error("Expected cak_ prefixed token to be an api key")
+	}
+	if IsAPIKey("v2.local.abcdef") {
+		t.Error("Paseto token should not be an api key")
+	}
+}
@@ -12,9 +12,8 @@ import (
 )
 
 func (handler *Handler) CreateApproval(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -87,9 +86,8 @@ func (handler *Handler) CreateApproval(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) EditApprovalStatus(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -139,9 +137,8 @@ func (handler *Handler) EditApprovalStatus(w http.ResponseWriter, r *http.Reques
 
 // GetReceivedApprovals -> You are the group owner and other people have requested you to approve them
 func (handler *Handler) GetReceivedApprovals(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -170,9 +167,8 @@ func (handler *Handler) GetReceivedApprovals(w http.ResponseWriter, r *http.Requ
 
 // GetSentApprovals -> The approvals you have sent to other group owners
 func (handler *Handler) GetSentApprovals(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -200,9 +196,8 @@ func (handler *Handler) GetSentApprovals(w http.ResponseWriter, r *http.Request)
 }
 
 func (handler *Handler) DeleteApproval(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
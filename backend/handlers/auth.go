@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"chat_app/database/models"
+	"chat_app/paseto"
 	"chat_app/utils"
 	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const (
+	accessTokenDuration  = 15 * time.Minute
+	refreshTokenDuration = 7 * 24 * time.Hour
+)
+
 func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Username    string `json:"username"`
@@ -21,6 +31,19 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// failed registrations are throttled per ip, they can be used to enumerate usernames
+	ip := handler.clientIP(r)
+
+	retryAfter, err := handler.Limiter.RetryAfter(registerIPKey(ip), registerIPPolicy)
+	if err != nil {
+		slog.Error("checking register attempts", "error", err, "ip", ip)
+	}
+
+	if retryAfter > 0 {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
 	filter := bson.M{
 		"username": input.Username,
 	}
@@ -30,11 +53,13 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, err := handler.Models.User.Get(filter, projection); err == nil {
+		handler.recordRegisterFailure(ip)
 		utils.WriteError(w, http.StatusBadRequest, "usernameEmailTaken", "user with this username exists already")
 		return
 	}
 
 	if err := handler.CreateUser(input.Username, []byte(input.RawPassword)); err != nil {
+		handler.recordRegisterFailure(ip)
 		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
 		return
 	}
@@ -53,19 +78,30 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// checked before the user lookup, so a blocked guess costs neither a query nor a bcrypt comparison
+	ip := handler.clientIP(r)
+	if retryAfter := handler.loginRetryAfter(input.Username, ip); retryAfter > 0 {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
 	filter := bson.M{
 		"username": input.Username,
 	}
 
 	projection := bson.M{
 		"_id":             1,
+		"username":        1,
 		"hashed_password": 1,
 		"avatar_url":      1,
+		"totp_enabled":    1,
+		"locked_until":    1,
 	}
 
 	user, err := handler.Models.User.Get(filter, projection)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
+			handler.recordLoginFailure(input.Username, ip, nil)
 			utils.WriteError(w, http.StatusBadRequest, "userDoesNotExist", "user with this username does not exist")
 			return
 		}
@@ -74,37 +110,221 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if lockout := lockedFor(user); lockout > 0 {
+		writeTooManyRequests(w, lockout)
+		return
+	}
+
 	if !utils.VerifyHash(user.HashedPassword, input.RawPassword) {
+		handler.recordLoginFailure(input.Username, ip, user)
 		utils.WriteError(w, http.StatusBadRequest, "passwordValidation", "password is invalid")
 		return
 	}
 
-	token, err := handler.Paseto.CreateToken(user.Id, input.Username, 12*time.Hour)
+	if user.TOTPEnabled {
+		challengeToken, err := handler.createTwoFactorChallenge(user)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "createPasetoToken", "failed to create login challenge")
+			return
+		}
+
+		// no session yet, the client finishes the login at /api/login/2fa
+		var response = map[string]any{
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+		}
+
+		utils.WriteJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if errResp := handler.startSession(w, r, user.Id, user.Username); errResp != nil {
+		utils.WriteError(w, http.StatusInternalServerError, errResp.Type, errResp.Detail)
+		return
+	}
+
+	handler.resetLoginFailures(input.Username)
+
+	utils.WriteJSON(w, http.StatusOK, loginResponse(user))
+}
+
+func (handler *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	// best effort: the access token may already be expired, the refresh token lives longer
+	for _, cookieName := range []string{"auth_cookie", "refresh_cookie"} {
+		cookie, err := r.Cookie(cookieName)
+		if err != nil {
+			continue
+		}
+
+		payload, err := handler.Paseto.VerifyToken(cookie.Value)
+		if err != nil {
+			continue
+		}
+
+		if _, err := handler.Models.Session.Revoke(payload.SessionId.String(), payload.UserId); err != nil {
+			slog.Error("revoking session on logout", "error", err, "session_id", payload.SessionId)
+		}
+
+		handler.disconnectSession(payload.UserId, payload.SessionId.String())
+		break
+	}
+
+	clearAuthCookies(w)
+
+	utils.WriteJSON(w, http.StatusOK, "Logged out successfully")
+}
+
+// Refresh -> Exchanges the refresh cookie for a new access token and rotates the refresh token
+func (handler *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("refresh_cookie")
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "noRefreshCookie", "refresh cookie is missing")
+		return
+	}
+
+	payload, err := handler.Paseto.VerifyToken(cookie.Value)
+	if err != nil || payload.Kind != paseto.RefreshToken {
+		utils.WriteError(w, http.StatusUnauthorized, "refreshTokenNotValid", "can't verify refresh token")
+		return
+	}
+
+	sessionId := payload.SessionId.String()
+
+	refreshToken, refreshPayload, err := handler.Paseto.CreateSessionToken(payload.UserId, payload.Username,
+		payload.SessionId, paseto.RefreshToken, refreshTokenDuration)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "createPasetoToken", "failed to create refresh token")
+		return
+	}
+
+	// only the latest refresh token of an active session can be rotated
+	filter := bson.M{
+		"_id":              sessionId,
+		"user_id":          payload.UserId,
+		"refresh_token_id": payload.ID.String(),
+		"revoked_at":       nil,
+	}
+
+	updates := bson.M{
+		"refresh_token_id": refreshPayload.ID.String(),
+		"ip":               handler.clientIP(r),
+		"last_seen_at":     time.Now(),
+		"expire_at":        refreshPayload.ExpiryAt,
+	}
+
+	result, err := handler.Models.Session.Update(filter, updates)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "updateSession", "failed to rotate refresh token")
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		// an old refresh token was replayed, so the session is considered stolen
+		if _, err := handler.Models.Session.Revoke(sessionId, payload.UserId); err != nil {
+			slog.Error("revoking reused session", "error", err, "session_id", sessionId)
+		}
+
+		handler.disconnectSession(payload.UserId, sessionId)
+
+		clearAuthCookies(w)
+		utils.WriteError(w, http.StatusUnauthorized, utils.SessionRevoked.Error(), "session is no longer active")
+		return
+	}
+
+	accessToken, _, err := handler.Paseto.CreateSessionToken(payload.UserId, payload.Username, payload.SessionId,
+		paseto.AccessToken, accessTokenDuration)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "createPasetoToken", "failed to create paseto token")
+		utils.WriteError(w, http.StatusInternalServerError, "createPasetoToken", "failed to create access token")
 		return
 	}
 
+	setAuthCookies(w, accessToken, refreshToken)
+
+	utils.WriteJSON(w, http.StatusOK, "token refreshed successfully")
+}
+
+// GetPasetoPublicKeys -> Lets other services verify v4.public tokens without the server secrets
+func (handler *Handler) GetPasetoPublicKeys(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{
+		"keys": handler.Paseto.PublicKeys(),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (handler *Handler) AuthCheck(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var response = map[string]string{
+		"username": payload.Username,
+		"user_id":  payload.UserId.Hex(),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func loginResponse(user *models.User) map[string]string {
+	return map[string]string{
+		"username":   user.Username,
+		"user_id":    user.Id.Hex(),
+		"avatar_url": user.AvatarUrl,
+	}
+}
+
+// startSession -> Registers a new session for the device and sets the access and refresh cookies
+func (handler *Handler) startSession(w http.ResponseWriter, r *http.Request, userId primitive.ObjectID,
+	username string) *utils.ErrorResponse {
+
+	sessionId := uuid.New()
+
+	accessToken, _, err := handler.Paseto.CreateSessionToken(userId, username, sessionId, paseto.AccessToken,
+		accessTokenDuration)
+	if err != nil {
+		return &utils.ErrorResponse{Type: "createPasetoToken", Detail: "failed to create access token"}
+	}
+
+	refreshToken, refreshPayload, err := handler.Paseto.CreateSessionToken(userId, username, sessionId,
+		paseto.RefreshToken, refreshTokenDuration)
+	if err != nil {
+		return &utils.ErrorResponse{Type: "createPasetoToken", Detail: "failed to create refresh token"}
+	}
+
+	if err := handler.Models.Session.Create(sessionId.String(), userId, refreshPayload.ID.String(), r.UserAgent(),
+		handler.clientIP(r), refreshPayload.ExpiryAt); err != nil {
+		return &utils.ErrorResponse{Type: "createSession", Detail: "failed to create session"}
+	}
+
+	setAuthCookies(w, accessToken, refreshToken)
+
+	return nil
+}
+
+func setAuthCookies(w http.ResponseWriter, accessToken, refreshToken string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_cookie",
-		Value:    token,
+		Value:    accessToken,
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   false, // temp (for http)
 		SameSite: http.SameSiteStrictMode,
-		MaxAge:   3600 * 12, // 12 hours
+		MaxAge:   int(accessTokenDuration.Seconds()),
 	})
 
-	var response = map[string]string{
-		"username":   input.Username,
-		"user_id":    user.Id.Hex(),
-		"avatar_url": user.AvatarUrl,
-	}
-
-	utils.WriteJSON(w, http.StatusOK, response)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_cookie",
+		Value:    refreshToken,
+		Path:     "/api",
+		HttpOnly: true,
+		Secure:   false, // temp (for http)
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(refreshTokenDuration.Seconds()),
+	})
 }
 
-func (handler *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+func clearAuthCookies(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_cookie",
 		Value:    "",
@@ -115,20 +335,13 @@ func (handler *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1, // delete it
 	})
 
-	utils.WriteJSON(w, http.StatusOK, "Logged out successfully")
-}
-
-func (handler *Handler) AuthCheck(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
-		return
-	}
-
-	var response = map[string]string{
-		"username": payload.Username,
-		"user_id":  payload.UserId.Hex(),
-	}
-
-	utils.WriteJSON(w, http.StatusOK, response)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_cookie",
+		Value:    "",
+		Path:     "/api",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1, // delete it
+	})
 }
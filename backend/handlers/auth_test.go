@@ -2,10 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"chat_app/database/models"
+	"chat_app/limiter"
+	"chat_app/paseto"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestRegister(t *testing.T) {
@@ -41,6 +49,50 @@ func TestLogout(t *testing.T) {
 		if !found {
 			t.Error("Expected auth_cookie to be set for deletion")
 		}
+
+		found = false
+		for _, cookie := range cookies {
+			if cookie.Name == "refresh_cookie" && cookie.MaxAge == -1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Error("Expected refresh_cookie to be set for deletion")
+		}
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	handler := setupTestHandler()
+
+	t.Run("No Refresh Cookie", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		w := httptest.NewRecorder()
+
+		handler.Refresh(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("Access Token Used As Refresh Token", func(t *testing.T) {
+		token, _, err := handler.Paseto.CreateSessionToken(primitive.NewObjectID(), "testuser", uuid.New(),
+			paseto.AccessToken, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "refresh_cookie", Value: token})
+		w := httptest.NewRecorder()
+
+		handler.Refresh(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
 	})
 }
 
@@ -122,3 +174,79 @@ func BenchmarkLogout(b *testing.B) {
 		handler.Logout(w, req)
 	}
 }
+
+func TestWriteTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	writeTooManyRequests(w, 1500*time.Millisecond)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", retryAfter)
+	}
+}
+
+func TestLoginIPLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	handler := &Handler{Limiter: limiter.New(limiter.NewMemoryStore())}
+
+	// the ip used up its free attempts, each guess under a different username
+	for range loginIPPolicy.FreeAttempts + 1 {
+		if _, _, err := handler.Limiter.Fail(loginIPKey("203.0.113.9"), loginIPPolicy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for idx := range 3 {
+		body := bytes.NewBufferString(`{"username":"someone","password":"guess"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/login", body)
+		req.RemoteAddr = "203.0.113.9:5555"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(idx))
+
+		w := httptest.NewRecorder()
+		handler.Login(w, req)
+
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected a spoofed X-Forwarded-For to stay limited, got status %d", w.Code)
+		}
+	}
+}
+
+func TestLockedFor(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+
+	if lockedFor(&models.User{}) != 0 {
+		t.Error("User without lockout should not be locked")
+	}
+	if lockedFor(&models.User{LockedUntil: &past}) != 0 {
+		t.Error("Expired lockout should not lock the user")
+	}
+	if lockedFor(&models.User{LockedUntil: &future}) <= 0 {
+		t.Error("Active lockout should lock the user")
+	}
+}
+
+func TestGetPasetoPublicKeys(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/api/paseto/public-keys", nil)
+	w := httptest.NewRecorder()
+
+	handler.GetPasetoPublicKeys(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp map[string][]paseto.PublicKey
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// the test handler only has a symmetric key
+	if len(resp["keys"]) != 0 {
+		t.Errorf("Expected no public keys, got %v", resp["keys"])
+	}
+}
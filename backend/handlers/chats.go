@@ -3,10 +3,8 @@ package handlers
 import (
 	"chat_app/database/models"
 	"chat_app/utils"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -16,9 +14,8 @@ import (
 )
 
 func (handler *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -60,18 +57,23 @@ func (handler *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := handler.Models.Chat.Create(participants); err != nil {
+	chatId, err := handler.Models.Chat.Create(participants)
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, "createChat", err)
 		return
 	}
 
+	chat := &models.Chat{Id: chatId, Participants: participants}
+	for _, participant := range participants {
+		handler.joinRoom(participant, ChatRoom(chat, participant))
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, "chat created successfully")
 }
 
 func (handler *Handler) UploadChatImage(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, "checkAuth", err)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -116,6 +118,24 @@ func (handler *Handler) UploadChatImage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	replyToId, err := parseReplyToId(r.FormValue("reply_to_id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+		return
+	}
+
+	if !replyToId.IsZero() {
+		if _, err := handler.replyParent(bson.M{"chat_id": chatObjectId}, replyToId); err != nil {
+			if errors.Is(err, errReplyNotInRoom) {
+				utils.WriteError(w, http.StatusBadRequest, "invalidReply", err.Error())
+				return
+			}
+
+			utils.WriteError(w, http.StatusInternalServerError, "getMessage", "failed to get the replied message")
+			return
+		}
+	}
+
 	allowedFormats := []string{".jpg", ".jpeg", ".png", ".webp"}
 	// 20 MB
 	avatarAddress, err := utils.UploadFile(r, 20<<20, "file", allowedFormats)
@@ -125,8 +145,8 @@ func (handler *Handler) UploadChatImage(w http.ResponseWriter, r *http.Request)
 	}
 
 	senderId := payload.UserId
-	if _, err := handler.Models.Message.Create(chatObjectId, primitive.NilObjectID, senderId,
-		receiverObjectId, "image", avatarAddress, "", false); err != nil {
+	if _, err := handler.Models.Message.Create(primitive.NewObjectID(), chatObjectId, primitive.NilObjectID, senderId,
+		receiverObjectId, "image", avatarAddress, "", "", replyToId, false); err != nil {
 
 		utils.WriteError(w, http.StatusBadRequest, "createMsg", "failed to create message")
 		return
@@ -141,9 +161,8 @@ func (handler *Handler) UploadChatImage(w http.ResponseWriter, r *http.Request)
 
 // GetChatMessages -> Returns all the messages of the chat
 func (handler *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -181,28 +200,24 @@ func (handler *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request)
 			continue
 		}
 
-		decodedMessage, err := hex.DecodeString(messages[idx].Content)
-		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, "msgDecoding", "failed to decode the message")
-			continue
-		}
-
-		decryptedMsg, err := handler.Cipher.Decrypt(decodedMessage)
+		decryptedMsg, err := handler.decryptMessage(&messages[idx])
 		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, "msgDecryption", "failed to decrypt the message")
 			continue
 		}
 
-		messages[idx].Content = string(decryptedMsg)
+		messages[idx].Content = decryptedMsg
 	}
 
+	handler.attachReplyPreviews(messages, filter, payload.UserId)
+	attachReactionCounts(messages, payload.UserId)
+
 	utils.WriteJSON(w, http.StatusOK, messages)
 }
 
 func (handler *Handler) DeleteChat(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -212,8 +227,8 @@ func (handler *Handler) DeleteChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chatObjectId, err := utils.ToObjectId(chatId)
-	if err != nil {
+	chatObjectId, errResp := utils.ToObjectId(chatId)
+	if errResp != nil {
 		utils.WriteError(w, http.StatusBadRequest, "strToObjectId", "failed to convert chatId to objectId")
 		return
 	}
@@ -225,50 +240,24 @@ func (handler *Handler) DeleteChat(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	if _, err := handler.Models.Chat.Delete(filter); err != nil {
+	result, err := handler.Models.Chat.Delete(filter)
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, "deleteChat", "failed to delete chat instance")
 		return
 	}
 
-	if _, err := handler.DeleteChatMessages(chatObjectId); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, "deleteChatMessages", err.Error())
-		return
-	}
-
-	utils.WriteJSON(w, http.StatusOK, "chat deleted successfully")
-}
-
-func (handler *Handler) AddChatWebsocket(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
-		return
-	}
-
-	chatId := chi.URLParam(r, "chat_id")
-	if chatId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "chat id is missing")
+	// the filter matches nothing for someone else's chat, its messages and data key must stay
+	if result.DeletedCount == 0 {
+		utils.WriteError(w, http.StatusNotFound, "deleteChat", "chat does not exist")
 		return
 	}
 
-	receiverId := r.URL.Query().Get("receiver_id")
-	if receiverId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "receiver id is missing")
-		return
-	}
+	handler.closeRoom(roomId(RoomChat, chatObjectId), RoomRemovedDeleted)
 
-	wsConn, err := WebsocketUpgrade(w, r)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "upgradingWebsocket", err)
+	if _, err := handler.DeleteChatMessages(chatObjectId); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "deleteChatMessages", err.Error())
 		return
 	}
 
-	wsConn.AddChat(chatId, payload.UserId.Hex(), handler.WebSocket)
-
-	go func() {
-		if err := wsConn.HandleChatIncomingMsgs(chatId, payload.UserId.Hex(),
-			receiverId, false, handler.WebSocket, handler); err != nil {
-			slog.Error("handling incoming ws messages", "error", err)
-		}
-	}()
+	utils.WriteJSON(w, http.StatusOK, "chat deleted successfully")
 }
@@ -5,15 +5,22 @@ import (
 	"chat_app/cipher"
 	"chat_app/database/models"
 	"chat_app/paseto"
+	"chat_app/utils"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"strings"
 	"testing"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Mock handler for testing
@@ -60,6 +67,60 @@ func setupTestHandler() *MockHandler {
 	}
 }
 
+// setupDatabaseHandler -> Handler on the test database, the test is skipped when mongo is not running.
+// Data keys are stored there as well
+func setupDatabaseHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err == nil {
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		t.Skipf("MongoDB connection failed (skipping tests): %v", err)
+	}
+
+	db := client.Database("test_database")
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	provider, err := cipher.NewLocalKeyProvider("test master key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipherInstance, err := cipher.NewWithKeys(map[uint32]string{cipher.LegacyKeyVersion: "test secret key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &Handler{Models: models.New(db), WebSocket: WebsocketInit(), Cipher: cipherInstance}
+	cipherInstance.UseKeyProvider(provider, handler.Models.DataKey)
+
+	return handler
+}
+
+// authRequest -> Request as the auth middleware passes it on, with the url params of the route
+func authRequest(method, target string, body io.Reader, userId primitive.ObjectID,
+	params map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+
+	routeCtx := chi.NewRouteContext()
+	for key, value := range params {
+		routeCtx.URLParams.Add(key, value)
+	}
+
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+	ctx = utils.WithAuthPayload(ctx, &paseto.Payload{UserId: userId})
+
+	return req.WithContext(ctx)
+}
+
 // createMockAuthCookie creates a mock authentication cookie for testing
 func createMockAuthCookie() *http.Cookie {
 	return &http.Cookie{
@@ -77,158 +138,89 @@ func TestCreateChat(t *testing.T) {
 	t.Skip("Skipping HTTP handler tests that require authentication - focus on WebSocket tests")
 }
 
-// TestAddChatWebsocket - Skip this test since it requires full authentication setup
-func TestAddChatWebsocket(t *testing.T) {
+func TestGetChatMessages(t *testing.T) {
 	t.Skip("Skipping HTTP handler tests that require authentication - focus on WebSocket tests")
 }
 
-func TestWebSocketMessageSending(t *testing.T) {
-	handler := setupTestHandler()
+func TestDeleteChatOfSomeoneElse(t *testing.T) {
+	handler := setupDatabaseHandler(t)
+
+	userId, contactId, strangerId := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
 
-	t.Run("Chat Message Sending", func(t *testing.T) {
-		// Create test server
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			upgrader := websocket.Upgrader{
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-			}
-			conn, err := upgrader.Upgrade(w, r, nil)
-			if err != nil {
-				t.Errorf("Failed to upgrade connection: %v", err)
-				return
-			}
-			defer conn.Close()
-
-			// Read messages
-			for {
-				_, message, err := conn.ReadMessage()
-				if err != nil {
-					break
-				}
-
-				// Verify message format
-				var chatMsg ChatMessage
-				if err := json.Unmarshal(message, &chatMsg); err != nil {
-					t.Errorf("Failed to unmarshal message: %v", err)
-					continue
-				}
-
-				// Verify message structure
-				if chatMsg.SenderId == "" {
-					t.Error("Message should have sender_id")
-				}
-				if chatMsg.ContentType == "" {
-					t.Error("Message should have content_type")
-				}
-			}
-		}))
-		defer server.Close()
-
-		// Connect to the test server
-		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
-		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	// a message of the chat, encrypted with its data key
+	storeMessage := func(t *testing.T, chatId primitive.ObjectID, isSecret bool) {
+		t.Helper()
+
+		content, err := handler.Cipher.EncryptFor(models.ChatKeyOwner(chatId), []byte("hello"), nil)
 		if err != nil {
-			t.Fatalf("Failed to connect: %v", err)
-		}
-		defer conn.Close()
-
-		// Add connection to WebSocket manager
-		wsConn := &WsConnection{Conn: conn}
-		wsConn.AddChat("test_chat", "user1", handler.WebSocket)
-
-		// Send test message
-		message := ChatMessage{
-			SenderId:       "user1",
-			ReceiverId:     "user2",
-			Content:        "Hello, World!",
-			ContentType:    "text",
-			ContentAddress: "",
+			t.Fatalf("Failed to encrypt: %v", err)
 		}
 
-		messageBytes, _ := json.Marshal(message)
-		err = conn.WriteMessage(websocket.TextMessage, messageBytes)
+		_, err = handler.Models.Message.Create(primitive.NewObjectID(), chatId, primitive.NilObjectID, userId, contactId,
+			"text", "", string(content), "", primitive.NilObjectID, isSecret)
 		if err != nil {
-			t.Errorf("Failed to send message: %v", err)
+			t.Fatalf("Failed to create message: %v", err)
 		}
+	}
 
-		// Give some time for message processing
-		time.Sleep(100 * time.Millisecond)
-	})
+	checkSurvived := func(t *testing.T, chatId primitive.ObjectID) {
+		t.Helper()
 
-	t.Run("Group Message Sending", func(t *testing.T) {
-		// Create test server
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			upgrader := websocket.Upgrader{
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-			}
-			conn, err := upgrader.Upgrade(w, r, nil)
-			if err != nil {
-				t.Errorf("Failed to upgrade connection: %v", err)
-				return
-			}
-			defer conn.Close()
-
-			// Read messages
-			for {
-				_, message, err := conn.ReadMessage()
-				if err != nil {
-					break
-				}
-
-				// Verify message format
-				var groupMsg GroupMessage
-				if err := json.Unmarshal(message, &groupMsg); err != nil {
-					t.Errorf("Failed to unmarshal message: %v", err)
-					continue
-				}
-
-				// Verify message structure
-				if groupMsg.SenderId == "" {
-					t.Error("Message should have sender_id")
-				}
-				if groupMsg.ContentType == "" {
-					t.Error("Message should have content_type")
-				}
-			}
-		}))
-		defer server.Close()
-
-		// Connect to the test server
-		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
-		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-		if err != nil {
-			t.Fatalf("Failed to connect: %v", err)
+		if _, err := handler.Models.DataKey.Get(models.ChatKeyOwner(chatId)); err != nil {
+			t.Errorf("Expected the data key to survive, got %v", err)
 		}
-		defer conn.Close()
-
-		// Add connection to WebSocket manager
-		wsConn := &WsConnection{Conn: conn}
-		wsConn.AddGroup("test_group", "user1", handler.WebSocket)
-
-		// Send test message
-		message := GroupMessage{
-			SenderId:       "user1",
-			Content:        "Hello, Group!",
-			ContentType:    "text",
-			ContentAddress: "",
+
+		messages, err := handler.Models.Message.GetAll(bson.M{"chat_id": chatId}, bson.M{}, 1, 10)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("Failed to get messages: %v", err)
 		}
 
-		messageBytes, _ := json.Marshal(message)
-		err = conn.WriteMessage(websocket.TextMessage, messageBytes)
+		if len(messages) != 1 {
+			t.Errorf("Expected the message to survive, got %d messages", len(messages))
+		}
+	}
+
+	t.Run("Chat", func(t *testing.T) {
+		chatId, err := handler.Models.Chat.Create([]primitive.ObjectID{userId, contactId})
 		if err != nil {
-			t.Errorf("Failed to send message: %v", err)
+			t.Fatalf("Failed to create chat: %v", err)
 		}
 
-		// Give some time for message processing
-		time.Sleep(100 * time.Millisecond)
+		storeMessage(t, chatId, false)
+
+		req := authRequest("DELETE", "/api/chat/delete/"+chatId.Hex(), nil, strangerId,
+			map[string]string{"chat_id": chatId.Hex()})
+		w := httptest.NewRecorder()
+
+		handler.DeleteChat(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+
+		checkSurvived(t, chatId)
 	})
-}
 
-func TestGetChatMessages(t *testing.T) {
-	t.Skip("Skipping HTTP handler tests that require authentication - focus on WebSocket tests")
+	t.Run("Secret Chat", func(t *testing.T) {
+		chatId, err := handler.Models.SecretChat.Create(userId, contactId)
+		if err != nil {
+			t.Fatalf("Failed to create secret chat: %v", err)
+		}
+
+		storeMessage(t, chatId, true)
+
+		req := authRequest("DELETE", "/api/secret-chat/delete/"+chatId.Hex(), nil, strangerId,
+			map[string]string{"secret_chat_id": chatId.Hex()})
+		w := httptest.NewRecorder()
+
+		handler.DeleteSecretChat(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+
+		checkSurvived(t, chatId)
+	})
 }
 
 // Benchmark tests
@@ -249,31 +241,3 @@ func BenchmarkCreateChat(b *testing.B) {
 		handler.CreateChat(w, req)
 	}
 }
-
-func BenchmarkWebSocketMessageSending(b *testing.B) {
-	handler := setupTestHandler()
-
-	// Setup test connection
-	conn := createTestConnectionForBenchmark(b)
-	defer conn.Close()
-
-	wsConn := &WsConnection{Conn: conn}
-	wsConn.AddChat("benchmark_chat", "user1", handler.WebSocket)
-
-	message := ChatMessage{
-		SenderId:       "user1",
-		ReceiverId:     "user2",
-		Content:        "Benchmark message",
-		ContentType:    "text",
-		ContentAddress: "",
-	}
-	messageBytes, _ := json.Marshal(message)
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		err := conn.WriteMessage(websocket.TextMessage, messageBytes)
-		if err != nil {
-			b.Errorf("Failed to send message: %v", err)
-		}
-	}
-}
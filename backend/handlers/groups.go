@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"chat_app/database/models"
 	"chat_app/utils"
 	"crypto/rand"
-	"encoding/hex"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -19,9 +19,8 @@ import (
 )
 
 func (handler *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -61,9 +60,12 @@ func (handler *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	groupId := result.InsertedID.(primitive.ObjectID)
+	handler.joinRoom(payload.UserId, GroupRoom(&models.Group{Id: groupId, IsSecret: isSecret}))
+
 	response := map[string]string{
 		"message":     "group created successfully",
-		"group_id":    result.InsertedID.(primitive.ObjectID).Hex(),
+		"group_id":    groupId.Hex(),
 		"owner_id":    payload.UserId.Hex(),
 		"invite_link": inviteLink,
 		"avatar_url":  avatarUrl,
@@ -73,9 +75,8 @@ func (handler *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -162,9 +163,8 @@ func (handler *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) JoinGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -183,6 +183,7 @@ func (handler *Handler) JoinGroup(w http.ResponseWriter, r *http.Request) {
 		"members":        1,
 		"banned_members": 1,
 		"type":           1,
+		"is_secret":      1,
 	}
 
 	groupInstance, err := handler.Models.Group.Get(filter, projection)
@@ -224,13 +225,14 @@ func (handler *Handler) JoinGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	handler.joinRoom(payload.UserId, GroupRoom(groupInstance))
+
 	utils.WriteJSON(w, http.StatusOK, "user joined successfully")
 }
 
 func (handler *Handler) RemoveUserFromGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -310,13 +312,14 @@ func (handler *Handler) RemoveUserFromGroup(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	handler.evictFromRoom(userObjectId, roomId(RoomGroup, groupObjectId), RoomRemovedKicked)
+
 	utils.WriteJSON(w, http.StatusOK, "member removed successfully")
 }
 
 func (handler *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -363,6 +366,8 @@ func (handler *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	handler.closeRoom(roomId(RoomGroup, groupObjectId), RoomRemovedDeleted)
+
 	if _, err := handler.DeleteGroupMessages(groupObjectId); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, "deleteGroupMessages", err.Error())
 		return
@@ -382,8 +387,8 @@ func (handler *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 
 // GetGroupMessages -> Returns all the messages of the group
 func (handler *Handler) GetGroupMessages(w http.ResponseWriter, r *http.Request) {
-	if _, errResp := utils.CheckAuth(r, handler.Paseto); errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -419,21 +424,18 @@ func (handler *Handler) GetGroupMessages(w http.ResponseWriter, r *http.Request)
 	}
 
 	for idx := range messages {
-		decodedMessage, err := hex.DecodeString(messages[idx].Content)
-		if err != nil {
-			slog.Warn("failed to decode message", "err", err, "msgID", messages[idx].Id.Hex())
-			continue
-		}
-
-		decryptedMsg, err := handler.Cipher.Decrypt(decodedMessage)
+		decryptedMsg, err := handler.decryptMessage(&messages[idx])
 		if err != nil {
 			slog.Warn("failed to decrypt message", "err", err, "msgID", messages[idx].Id.Hex())
 			continue
 		}
 
-		messages[idx].Content = string(decryptedMsg)
+		messages[idx].Content = decryptedMsg
 	}
 
+	handler.attachReplyPreviews(messages, filter, payload.UserId)
+	attachReactionCounts(messages, payload.UserId)
+
 	resp := map[string]any{
 		"messages": messages,
 	}
@@ -443,8 +445,7 @@ func (handler *Handler) GetGroupMessages(w http.ResponseWriter, r *http.Request)
 
 // GetGroupMembers -> Returns all the members (users) of the group
 func (handler *Handler) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
-	if _, errResp := utils.CheckAuth(r, handler.Paseto); errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	if _, ok := authPayload(w, r); !ok {
 		return
 	}
 
@@ -492,9 +493,8 @@ func (handler *Handler) GetGroupMembers(w http.ResponseWriter, r *http.Request)
 }
 
 func (handler *Handler) BanMemberFromGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -575,13 +575,14 @@ func (handler *Handler) BanMemberFromGroup(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	handler.evictFromRoom(targetUserObjectId, roomId(RoomGroup, groupObjectId), RoomRemovedBanned)
+
 	utils.WriteJSON(w, http.StatusOK, "user banned from this group successfully")
 }
 
 func (handler *Handler) UnBanMemberFromGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -666,9 +667,8 @@ func (handler *Handler) UnBanMemberFromGroup(w http.ResponseWriter, r *http.Requ
 }
 
 func (handler *Handler) LeaveGroup(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -725,6 +725,8 @@ func (handler *Handler) LeaveGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	handler.evictFromRoom(payload.UserId, roomId(RoomGroup, groupObjectId), RoomRemovedLeft)
+
 	utils.WriteJSON(w, http.StatusOK, "you left the group successfully")
 }
 
@@ -755,34 +757,3 @@ func (handler *Handler) isSecretGroup(url *url.URL) bool {
 	isSecretBool, _ := strconv.ParseBool(isSecretStr)
 	return isSecretBool
 }
-
-// AddGroupWebsocket -> Establish WebSocketManager
-func (handler *Handler) AddGroupWebsocket(w http.ResponseWriter, r *http.Request) {
-	groupId := chi.URLParam(r, "group_id")
-	if groupId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "group id is missing")
-		return
-	}
-
-	isSecret := handler.isSecretGroup(r.URL)
-
-	senderId := r.URL.Query().Get("sender_id")
-	if senderId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "sender id is missing")
-		return
-	}
-
-	wsConn, err := WebsocketUpgrade(w, r)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "upgradingWebsocket", err)
-		return
-	}
-
-	wsConn.AddGroup(groupId, senderId, handler.WebSocket)
-
-	go func() {
-		if err := wsConn.HandleGroupIncomingMsgs(groupId, senderId, isSecret, handler.WebSocket, handler); err != nil {
-			slog.Error("handling incoming ws messages", "error", err)
-		}
-	}()
-}
@@ -3,7 +3,18 @@ package handlers
 import (
 	"chat_app/cipher"
 	"chat_app/database/models"
+	"chat_app/limiter"
 	"chat_app/paseto"
+	"chat_app/utils"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Handler struct {
@@ -11,6 +22,17 @@ type Handler struct {
 	Paseto    *paseto.Maker
 	WebSocket *WebSocketManager
 	Cipher    *cipher.Cipher
+	Limiter   *limiter.Limiter
+	// PasswordPolicy is checked for every new password
+	PasswordPolicy utils.PasswordPolicy
+	// Admins can issue password reset tokens. Set with ADMIN_USER_IDS
+	Admins []primitive.ObjectID
+	// RequireMessageAD refuses message content encrypted without associated data. Set with MESSAGE_AD_REQUIRED
+	RequireMessageAD bool
+	// TrustedProxies may set X-Forwarded-For. Set with TRUSTED_PROXIES
+	TrustedProxies []*net.IPNet
+	// EditWindow is how long after sending a message can be edited, 0 for always. Set with MESSAGE_EDIT_WINDOW
+	EditWindow time.Duration
 }
 
 func New(models *models.Models) (*Handler, error) {
@@ -19,10 +41,100 @@ func New(models *models.Models) (*Handler, error) {
 		return nil, err
 	}
 
+	pasetoInstance.SetSessionChecker(models.Session)
+
+	passwordPolicy, err := utils.PasswordPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := parseAdminIds(os.Getenv("ADMIN_USER_IDS"))
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := utils.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
+	editWindow, err := parseEditWindow(os.Getenv("MESSAGE_EDIT_WINDOW"))
+	if err != nil {
+		return nil, err
+	}
+
 	var handler = &Handler{
-		Models: models,
-		Paseto: pasetoInstance,
+		Models:         models,
+		Paseto:         pasetoInstance,
+		Limiter:        limiter.New(models.LoginAttempt),
+		PasswordPolicy: passwordPolicy,
+		Admins:         admins,
+		// only safe once the reencrypt command has moved every message to associated data
+		RequireMessageAD: os.Getenv("MESSAGE_AD_REQUIRED") == "true",
+		EditWindow:       editWindow,
+		TrustedProxies:   trustedProxies,
 	}
 
 	return handler, nil
 }
+
+// IsAdmin -> Admins are configured by user id, a username could be registered again after a delete
+func (handler *Handler) IsAdmin(userId primitive.ObjectID) bool {
+	return slices.Contains(handler.Admins, userId)
+}
+
+// EditWindowClosed -> Whether a message sent at sentAt can't be edited anymore
+func (handler *Handler) EditWindowClosed(sentAt, now time.Time) bool {
+	return handler.EditWindow > 0 && now.Sub(sentAt) > handler.EditWindow
+}
+
+// clientIP -> The caller ip, X-Forwarded-For only counts when set by one of the trusted proxies
+func (handler *Handler) clientIP(r *http.Request) string {
+	return utils.ClientIP(r, handler.TrustedProxies)
+}
+
+// parseAdminIds -> Comma separated user ids
+func parseAdminIds(value string) ([]primitive.ObjectID, error) {
+	var admins []primitive.ObjectID
+
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("ADMIN_USER_IDS contains an invalid user id: %s", id)
+		}
+
+		admins = append(admins, objectId)
+	}
+
+	return admins, nil
+}
+
+// parseEditWindow -> A duration such as 15m or 48h, empty for no limit
+func parseEditWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("MESSAGE_EDIT_WINDOW must be a positive duration such as 48h: %s", value)
+	}
+
+	return window, nil
+}
+
+// authPayload -> Returns the payload verified by the auth middleware.
+// Fails closed with 401 when the route was mounted outside the authenticated route groups
+func authPayload(w http.ResponseWriter, r *http.Request) (*paseto.Payload, bool) {
+	payload, ok := utils.AuthPayload(r.Context())
+	if !ok {
+		utils.WriteError(w, http.StatusUnauthorized, utils.NoAuthPayload, "request is not authenticated")
+		return nil, false
+	}
+
+	return payload, true
+}
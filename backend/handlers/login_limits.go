@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/limiter"
+	"chat_app/utils"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	loginUserPolicy = limiter.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+
+	// an ip may be shared by many users (NAT, offices), so it gets more room
+	loginIPPolicy = limiter.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+
+	registerIPPolicy = limiter.Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+const (
+	// failures of one username before the account itself gets locked
+	lockoutThreshold = 10
+	lockoutDuration  = 15 * time.Minute
+)
+
+func loginUserKey(username string) string {
+	return "login:user:" + strings.ToLower(username)
+}
+
+func loginIPKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+func registerIPKey(ip string) string {
+	return "register:ip:" + ip
+}
+
+// loginRetryAfter -> Longest wait left on the username and the ip. Fails open when the store is unavailable
+func (handler *Handler) loginRetryAfter(username, ip string) time.Duration {
+	var retryAfter time.Duration
+
+	checks := []struct {
+		key    string
+		policy limiter.Policy
+	}{
+		{loginUserKey(username), loginUserPolicy},
+		{loginIPKey(ip), loginIPPolicy},
+	}
+
+	for _, check := range checks {
+		wait, err := handler.Limiter.RetryAfter(check.key, check.policy)
+		if err != nil {
+			slog.Error("checking login attempts", "error", err, "key", check.key)
+			continue
+		}
+
+		retryAfter = max(retryAfter, wait)
+	}
+
+	return retryAfter
+}
+
+// recordLoginFailure -> Counts a failed password or 2FA code. user is nil when the username does not exist
+func (handler *Handler) recordLoginFailure(username, ip string, user *models.User) {
+	if _, _, err := handler.Limiter.Fail(loginIPKey(ip), loginIPPolicy); err != nil {
+		slog.Error("recording failed login", "error", err, "ip", ip)
+	}
+
+	count, _, err := handler.Limiter.Fail(loginUserKey(username), loginUserPolicy)
+	if err != nil {
+		slog.Error("recording failed login", "error", err, "username", username)
+		return
+	}
+
+	if user == nil || count < lockoutThreshold {
+		return
+	}
+
+	if _, err := handler.Models.User.Update(bson.M{"_id": user.Id}, bson.M{"locked_until": time.Now().Add(lockoutDuration)}); err != nil {
+		slog.Error("locking user", "error", err, "user_id", user.Id.Hex())
+	}
+}
+
+func (handler *Handler) recordRegisterFailure(ip string) {
+	if _, _, err := handler.Limiter.Fail(registerIPKey(ip), registerIPPolicy); err != nil {
+		slog.Error("recording failed register", "error", err, "ip", ip)
+	}
+}
+
+// resetLoginFailures -> Called once the whole login (including 2FA) succeeded
+func (handler *Handler) resetLoginFailures(username string) {
+	if err := handler.Limiter.Reset(loginUserKey(username)); err != nil {
+		slog.Error("resetting login attempts", "error", err, "username", username)
+	}
+}
+
+// lockedFor -> Time left on the account lockout
+func lockedFor(user *models.User) time.Duration {
+	if user.LockedUntil == nil {
+		return 0
+	}
+
+	return max(time.Until(*user.LockedUntil), 0)
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.WriteError(w, http.StatusTooManyRequests, "tooManyAttempts",
+		fmt.Sprintf("too many failed attempts, try again in %d seconds", seconds))
+}
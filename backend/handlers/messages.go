@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"chat_app/database/models"
 	"chat_app/utils"
 	"encoding/hex"
 	"errors"
@@ -12,71 +13,115 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+var (
+	errMessageWithoutAD = errors.New("message content has no associated data")
+	errMessageNotFound  = errors.New("message does not exist")
+	errEditWindowClosed = errors.New("message is too old to be edited")
+	errEditConflict     = errors.New("message was edited at the same time, try again")
+)
+
+// editAttempts -> Tries of an edit that another edit of the same message keeps beating
+const editAttempts = 3
+
 func (handler *Handler) storeChatMsgToDB(chatId, senderId, receiverId string, contentType, contentAddress,
-	content string, isSecret bool) error {
+	content, clientMessageId string, replyToId primitive.ObjectID, isSecret bool) (*models.Message, error) {
 	chatObjectId, err := utils.ToObjectId(chatId)
 	if err != nil {
-		return errors.New(err.Type)
+		return nil, errors.New(err.Type)
 	}
 
 	senderObjectId, err := utils.ToObjectId(senderId)
 	if err != nil {
-		return errors.New(err.Type)
+		return nil, errors.New(err.Type)
 	}
 
 	receiverObjectId, err := utils.ToObjectId(receiverId)
 	if err != nil {
-		return errors.New(err.Type)
-	}
-
-	ciphered, err2 := handler.Cipher.Encrypt([]byte(content))
-	if err2 != nil {
-		return err2
+		return nil, errors.New(err.Type)
 	}
 
-	encodedCipher := hex.EncodeToString(ciphered)
+	messageId := primitive.NewObjectID()
 
-	if _, err := handler.Models.Message.Create(chatObjectId, primitive.NilObjectID, senderObjectId, receiverObjectId,
-		contentType, contentAddress, encodedCipher, isSecret); err != nil {
-		return err
+	encodedCipher, err2 := handler.encryptMessage(models.ChatKeyOwner(chatObjectId), content,
+		models.MessageAD(messageId, chatObjectId, primitive.NilObjectID, senderObjectId))
+	if err2 != nil {
+		return nil, err2
 	}
 
-	return nil
+	return handler.Models.Message.Create(messageId, chatObjectId, primitive.NilObjectID, senderObjectId,
+		receiverObjectId, contentType, contentAddress, encodedCipher, clientMessageId, replyToId, isSecret)
 }
 
 func (handler *Handler) storeGroupMsgToDB(groupId, senderId, contentType, contentAddress,
-	content string, isSecret bool) error {
+	content, clientMessageId string, replyToId primitive.ObjectID, isSecret bool) (*models.Message, error) {
 	senderObjectId, errResp := utils.ToObjectId(senderId)
 	if errResp != nil {
-		return errors.New(errResp.Type)
+		return nil, errors.New(errResp.Type)
+	}
+
+	groupObjectId, errResp := utils.ToObjectId(groupId)
+	if errResp != nil {
+		return nil, errors.New(errResp.Type)
 	}
 
-	ciphered, err := handler.Cipher.Encrypt([]byte(content))
+	messageId := primitive.NewObjectID()
+
+	encodedCipher, err := handler.encryptMessage(models.GroupKeyOwner(groupObjectId), content,
+		models.MessageAD(messageId, primitive.NilObjectID, groupObjectId, senderObjectId))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	encodedCipher := hex.EncodeToString(ciphered)
+	return handler.Models.Message.Create(messageId, primitive.NilObjectID, groupObjectId, senderObjectId,
+		primitive.NilObjectID, contentType, contentAddress, encodedCipher, clientMessageId, replyToId, isSecret)
+}
 
-	groupObjectId, errResp := utils.ToObjectId(groupId)
-	if errResp != nil {
-		return errors.New(errResp.Type)
+// encryptMessage -> Hex encoded content, bound to the message with its associated data.
+// With a key provider it is sealed with the data key of the chat or group
+func (handler *Handler) encryptMessage(keyOwner, content string, associatedData []byte) (string, error) {
+	ciphered, err := handler.Cipher.EncryptFor(keyOwner, []byte(content), associatedData)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(ciphered), nil
+}
+
+// decryptMessage -> Content written before the associated data existed is opened without it,
+// unless RequireMessageAD is set (after the reencrypt command migrated those messages)
+func (handler *Handler) decryptMessage(message *models.Message) (string, error) {
+	if message.Content == "" {
+		return "", nil
+	}
+
+	decodedMessage, err := hex.DecodeString(message.Content)
+	if err != nil {
+		return "", err
 	}
 
-	if _, err := handler.Models.Message.Create(primitive.NilObjectID, groupObjectId, senderObjectId,
-		primitive.NilObjectID, contentType, contentAddress, encodedCipher, isSecret); err != nil {
-		return err
+	var associatedData []byte
+	if message.ADVersion == 0 {
+		if handler.RequireMessageAD {
+			return "", errMessageWithoutAD
+		}
+	} else {
+		associatedData = message.AssociatedData()
+	}
+
+	decryptedMsg, err := handler.Cipher.DecryptFor(message.KeyOwner(), decodedMessage, associatedData)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	return string(decryptedMsg), nil
 }
 
 func (handler *Handler) UploadImageChatMessage(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -128,9 +173,8 @@ func (handler *Handler) UploadImageChatMessage(w http.ResponseWriter, r *http.Re
 }
 
 func (handler *Handler) UploadImageGroupMessage(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -182,9 +226,8 @@ func (handler *Handler) UploadImageGroupMessage(w http.ResponseWriter, r *http.R
 }
 
 func (handler *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -194,54 +237,219 @@ func (handler *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messageObjectId, err := utils.ToObjectId(messageId)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
 		return
 	}
 
-	filter := bson.M{
-		"_id":       messageObjectId,
-		"sender_id": payload.UserId,
+	var input struct {
+		NewContent string `json:"new_content"`
 	}
 
-	projection := bson.M{"_id": 1}
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", "failed to parse the body data")
+		return
+	}
 
-	if _, err := handler.Models.Message.Get(filter, projection); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
+	message, err := handler.editMessage(payload.UserId, messageObjectId, input.NewContent, nil)
+	if err != nil {
+		if errors.Is(err, errMessageNotFound) {
 			utils.WriteError(w, http.StatusBadRequest, "getMsg", "msg with this id and sender id does not exist")
 			return
 		}
 
-		utils.WriteError(w, http.StatusBadRequest, "getMsg", "failed to get msg")
+		if errors.Is(err, errEditWindowClosed) {
+			utils.WriteError(w, http.StatusForbidden, "editWindowClosed", err.Error())
+			return
+		}
+
+		if errors.Is(err, errEditConflict) {
+			utils.WriteError(w, http.StatusConflict, "editConflict", err.Error())
+			return
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, "updateMsg", "failed to update the message")
 		return
 	}
 
-	var input struct {
-		NewContent string `json:"new_content"`
+	handler.broadcastMessageEvent(message, EventMessageEdit, MessageEditData{
+		MessageId:      message.Id.Hex(),
+		Content:        input.NewContent,
+		SenderId:       payload.UserId.Hex(),
+		SenderUsername: payload.Username,
+		EditedAt:       message.EditedAt,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, "message updated successfully")
+}
+
+// GetMessageHistory -> Earlier versions of an edited message, oldest first. Any member of the message's chat
+// or group can read them
+func (handler *Handler) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
 	}
 
-	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, "parseJson", "failed to parse the body data")
+	messageId := chi.URLParam(r, "message_id")
+	if messageId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", "message id is missing")
 		return
 	}
 
-	updates := bson.M{
-		"content": input.NewContent,
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
 	}
 
-	if _, err := handler.Models.Message.Update(filter, updates); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, "updateMsg", "failed to update the message")
+	projection := bson.M{
+		"_id":       1,
+		"chat_id":   1,
+		"group_id":  1,
+		"sender_id": 1,
+		"is_secret": 1,
+	}
+
+	message, err := handler.Models.Message.Get(bson.M{"_id": messageObjectId}, projection)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getMsg", "failed to get the message")
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, "message updated successfully")
+	isMember, err := handler.isRoomMember(RoomOfMessage(message), payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return
+	}
+
+	if !isMember {
+		utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+		return
+	}
+
+	edits, err := handler.Models.MessageEdit.GetAll(message.Id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "fetchEdits", "failed to fetch the edit history")
+		return
+	}
+
+	for idx := range edits {
+		// a version is sealed with the associated data of its message
+		version := *message
+		version.Content = edits[idx].Content
+		version.ADVersion = edits[idx].ADVersion
+
+		decryptedMsg, err := handler.decryptMessage(&version)
+		if err != nil {
+			slog.Warn("failed to decrypt message version", "err", err, "msgID", message.Id.Hex())
+			edits[idx].Content = ""
+			continue
+		}
+
+		edits[idx].Content = decryptedMsg
+	}
+
+	resp := map[string]any{
+		"message_id": message.Id.Hex(),
+		"edits":      edits,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// editMessage -> Replaces the content of a message of the sender. The new content is encrypted like a new
+// message, with the message's own associated data, and the replaced version is kept in the edit history.
+// scope narrows the lookup, e.g. to the room of a websocket event
+func (handler *Handler) editMessage(senderId, messageId primitive.ObjectID, newContent string,
+	scope bson.M) (*models.Message, error) {
+	filter := bson.M{
+		"_id":       messageId,
+		"sender_id": senderId,
+	}
+
+	for key, value := range scope {
+		filter[key] = value
+	}
+
+	projection := bson.M{
+		"_id":        1,
+		"chat_id":    1,
+		"group_id":   1,
+		"sender_id":  1,
+		"is_secret":  1,
+		"content":    1,
+		"ad_version": 1,
+		"created_at": 1,
+		"edited_at":  1,
+	}
+
+	for attempt := 0; attempt < editAttempts; attempt++ {
+		message, err := handler.Models.Message.Get(filter, projection)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, errMessageNotFound
+			}
+
+			return nil, err
+		}
+
+		now := time.Now()
+		if handler.EditWindowClosed(message.CreatedAt, now) {
+			return nil, errEditWindowClosed
+		}
+
+		encodedCipher, err := handler.encryptMessage(message.KeyOwner(), newContent, message.AssociatedData())
+		if err != nil {
+			return nil, err
+		}
+
+		// matches only the version read above, a concurrent edit makes this one read again
+		updateFilter := bson.M{
+			"_id":       message.Id,
+			"content":   message.Content,
+			"edited_at": message.EditedAt,
+		}
+
+		updates := bson.M{
+			"content":    encodedCipher,
+			"ad_version": models.MessageADVersion,
+			"edited_at":  now,
+		}
+
+		result, err := handler.Models.Message.Update(updateFilter, updates)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.MatchedCount == 0 {
+			continue
+		}
+
+		// message still holds the version the update replaced
+		if err := handler.Models.MessageEdit.Create(message, now); err != nil {
+			slog.Error("storing edit history", "error", err, "message_id", message.Id)
+		}
+
+		message.Content = encodedCipher
+		message.ADVersion = models.MessageADVersion
+		message.EditedAt = &now
+
+		return message, nil
+	}
+
+	return nil, errEditConflict
 }
 
 func (handler *Handler) DeleteMessageForSender(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -291,9 +499,8 @@ func (handler *Handler) DeleteMessageForSender(w http.ResponseWriter, r *http.Re
 }
 
 func (handler *Handler) DeleteMessageForReceiver(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -345,9 +552,8 @@ func (handler *Handler) DeleteMessageForReceiver(w http.ResponseWriter, r *http.
 }
 
 func (handler *Handler) DeleteMessageForAll(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -363,31 +569,59 @@ func (handler *Handler) DeleteMessageForAll(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	message, err := handler.deleteMessageForAll(payload.UserId, messageObjectId, nil)
+	if err != nil {
+		if errors.Is(err, errMessageNotFound) {
+			utils.WriteError(w, http.StatusBadRequest, "getMsg", "msg with this users or id does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, "deleteMsg", "failed to delete msg")
+		return
+	}
+
+	handler.broadcastMessageEvent(message, EventMessageDelete, MessageDeleteData{
+		MessageId: message.Id.Hex(),
+		DeletedBy: payload.UserId.Hex(),
+	})
+
+	utils.WriteJSON(w, http.StatusOK, "message deleted successfully")
+}
+
+// deleteMessageForAll -> Deletes a message of the sender or the receiver, with its image.
+// scope narrows the lookup, e.g. to the room of a websocket event
+func (handler *Handler) deleteMessageForAll(userId, messageId primitive.ObjectID, scope bson.M) (*models.Message, error) {
 	filter := bson.M{
-		"_id": messageObjectId,
+		"_id": messageId,
 		"$or": []bson.M{
-			{"sender_id": payload.UserId},
-			{"receiver_id": payload.UserId},
+			{"sender_id": userId},
+			{"receiver_id": userId},
 		},
 	}
 
+	for key, value := range scope {
+		filter[key] = value
+	}
+
 	projection := bson.M{
+		"_id":             1,
+		"chat_id":         1,
+		"group_id":        1,
+		"is_secret":       1,
 		"content_address": 1,
 	}
 
-	msg, err := handler.Models.Message.Get(filter, projection)
+	message, err := handler.Models.Message.Get(filter, projection)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.WriteError(w, http.StatusBadRequest, "getMsg", "msg with this users or id does not exist")
-			return
+			return nil, errMessageNotFound
 		}
 
-		utils.WriteError(w, http.StatusBadRequest, "getMsg", "failed to get msg")
-		return
+		return nil, err
 	}
 
-	if msg.ContentAddress != "" {
-		path := filepath.Join("uploads", msg.ContentAddress)
+	if message.ContentAddress != "" {
+		path := filepath.Join("uploads", message.ContentAddress)
 
 		if err := os.Remove(path); err != nil {
 			slog.Error("remove msg image", "error", err)
@@ -396,16 +630,25 @@ func (handler *Handler) DeleteMessageForAll(w http.ResponseWriter, r *http.Reque
 
 	deletedResult, err := handler.Models.Message.Delete(filter)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, "deleteMsg", "failed to delete msg")
-		return
+		return nil, err
 	}
 
 	if deletedResult.DeletedCount == 0 {
-		utils.WriteError(w, http.StatusBadRequest, "deleteMsg", "no msg with this id exists")
-		return
+		return nil, errMessageNotFound
 	}
 
-	utils.WriteJSON(w, http.StatusOK, "message deleted successfully")
+	if _, err := handler.Models.MessageEdit.DeleteAll(bson.M{"message_id": message.Id}); err != nil {
+		slog.Error("deleting edit history", "error", err, "message_id", message.Id)
+	}
+
+	unpinned, err := handler.Models.Pin.Delete(bson.M{"message_id": message.Id})
+	if err != nil {
+		slog.Error("unpinning deleted message", "error", err, "message_id", message.Id)
+	} else if unpinned.DeletedCount > 0 {
+		handler.pinsChanged(RoomOfMessage(message), PinsUnpinned, message.Id, userId)
+	}
+
+	return message, nil
 }
 
 func (handler *Handler) DeleteMessagesByFilter(filter bson.M) {
@@ -446,6 +689,10 @@ func (handler *Handler) DeleteMessagesByFilter(filter bson.M) {
 func (handler *Handler) DeleteChatMessages(chatId primitive.ObjectID) (*mongo.DeleteResult, error) {
 	filter := bson.M{"chat_id": chatId}
 	handler.DeleteMessagesByFilter(filter)
+	handler.deleteEditHistory(filter)
+	handler.deletePins(filter)
+	handler.deleteReadMarkers(models.ChatKeyOwner(chatId))
+	handler.destroyDataKey(models.ChatKeyOwner(chatId))
 	return handler.Models.Message.DeleteAll(filter)
 
 }
@@ -453,5 +700,30 @@ func (handler *Handler) DeleteChatMessages(chatId primitive.ObjectID) (*mongo.De
 func (handler *Handler) DeleteGroupMessages(groupId primitive.ObjectID) (*mongo.DeleteResult, error) {
 	filter := bson.M{"group_id": groupId}
 	handler.DeleteMessagesByFilter(filter)
+	handler.deleteEditHistory(filter)
+	handler.deletePins(filter)
+	handler.deleteReadMarkers(models.GroupKeyOwner(groupId))
+	handler.destroyDataKey(models.GroupKeyOwner(groupId))
 	return handler.Models.Message.DeleteAll(filter)
 }
+
+// deleteEditHistory -> Removes the earlier versions of the messages of a chat or group
+func (handler *Handler) deleteEditHistory(filter bson.M) {
+	if _, err := handler.Models.MessageEdit.DeleteAll(filter); err != nil {
+		slog.Error("deleting edit history", "error", err)
+	}
+}
+
+// deletePins -> Removes the pins of a chat or group
+func (handler *Handler) deletePins(filter bson.M) {
+	if _, err := handler.Models.Pin.DeleteAll(filter); err != nil {
+		slog.Error("deleting pins", "error", err)
+	}
+}
+
+// destroyDataKey -> Crypto-shred: copies of the messages (e.g. in backups) can't be decrypted anymore
+func (handler *Handler) destroyDataKey(keyOwner string) {
+	if err := handler.Cipher.DestroyDataKey(keyOwner); err != nil {
+		slog.Error("destroying data key", "error", err, "owner", keyOwner)
+	}
+}
@@ -2,10 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"chat_app/database/models"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestUploadImageChatMessage(t *testing.T) {
@@ -17,8 +25,8 @@ func TestUploadImageChatMessage(t *testing.T) {
 
 		handler.UploadImageChatMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -29,8 +37,8 @@ func TestUploadImageChatMessage(t *testing.T) {
 
 		handler.UploadImageChatMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -41,8 +49,8 @@ func TestUploadImageChatMessage(t *testing.T) {
 
 		handler.UploadImageChatMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -53,9 +61,9 @@ func TestUploadImageChatMessage(t *testing.T) {
 
 		handler.UploadImageChatMessage(w, req)
 
-		// Should return 400 due to missing file data in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -69,8 +77,8 @@ func TestUploadImageGroupMessage(t *testing.T) {
 
 		handler.UploadImageGroupMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -81,8 +89,8 @@ func TestUploadImageGroupMessage(t *testing.T) {
 
 		handler.UploadImageGroupMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -93,8 +101,8 @@ func TestUploadImageGroupMessage(t *testing.T) {
 
 		handler.UploadImageGroupMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -105,9 +113,9 @@ func TestUploadImageGroupMessage(t *testing.T) {
 
 		handler.UploadImageGroupMessage(w, req)
 
-		// Should return 400 due to missing file data in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -121,8 +129,8 @@ func TestEditMessage(t *testing.T) {
 
 		handler.EditMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -133,8 +141,8 @@ func TestEditMessage(t *testing.T) {
 
 		handler.EditMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -145,8 +153,8 @@ func TestEditMessage(t *testing.T) {
 
 		handler.EditMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -158,8 +166,8 @@ func TestEditMessage(t *testing.T) {
 
 		handler.EditMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -176,9 +184,9 @@ func TestEditMessage(t *testing.T) {
 
 		handler.EditMessage(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -192,8 +200,8 @@ func TestDeleteMessageForSender(t *testing.T) {
 
 		handler.DeleteMessageForSender(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -204,8 +212,8 @@ func TestDeleteMessageForSender(t *testing.T) {
 
 		handler.DeleteMessageForSender(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -216,8 +224,8 @@ func TestDeleteMessageForSender(t *testing.T) {
 
 		handler.DeleteMessageForSender(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -228,9 +236,9 @@ func TestDeleteMessageForSender(t *testing.T) {
 
 		handler.DeleteMessageForSender(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -244,8 +252,8 @@ func TestDeleteMessageForReceiver(t *testing.T) {
 
 		handler.DeleteMessageForReceiver(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -256,8 +264,8 @@ func TestDeleteMessageForReceiver(t *testing.T) {
 
 		handler.DeleteMessageForReceiver(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -268,8 +276,8 @@ func TestDeleteMessageForReceiver(t *testing.T) {
 
 		handler.DeleteMessageForReceiver(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -280,9 +288,9 @@ func TestDeleteMessageForReceiver(t *testing.T) {
 
 		handler.DeleteMessageForReceiver(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -296,8 +304,8 @@ func TestDeleteMessageForAll(t *testing.T) {
 
 		handler.DeleteMessageForAll(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -308,8 +316,8 @@ func TestDeleteMessageForAll(t *testing.T) {
 
 		handler.DeleteMessageForAll(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -320,8 +328,8 @@ func TestDeleteMessageForAll(t *testing.T) {
 
 		handler.DeleteMessageForAll(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -332,9 +340,9 @@ func TestDeleteMessageForAll(t *testing.T) {
 
 		handler.DeleteMessageForAll(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -409,4 +417,212 @@ func BenchmarkDeleteMessageForAll(b *testing.B) {
 
 		handler.DeleteMessageForAll(w, req)
 	}
-} 
\ No newline at end of file
+}
+
+func TestMessageAssociatedData(t *testing.T) {
+	handler := setupTestHandler()
+
+	message := models.Message{
+		Id:        primitive.NewObjectID(),
+		ChatId:    primitive.NewObjectID(),
+		SenderId:  primitive.NewObjectID(),
+		ADVersion: models.MessageADVersion,
+	}
+
+	content, err := handler.encryptMessage(message.KeyOwner(), "hello", message.AssociatedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message.Content = content
+
+	plainText, err := handler.decryptMessage(&message)
+	if err != nil || plainText != "hello" {
+		t.Fatalf("expected hello, got %q (err %v)", plainText, err)
+	}
+
+	// the same content copied into another message, chat or sender must not decrypt
+	copies := []models.Message{message, message, message}
+	copies[0].Id = primitive.NewObjectID()
+	copies[1].ChatId = primitive.NewObjectID()
+	copies[2].SenderId = primitive.NewObjectID()
+
+	for idx := range copies {
+		if _, err := handler.decryptMessage(&copies[idx]); err == nil {
+			t.Errorf("copy %d decrypted", idx)
+		}
+	}
+
+	// copied into a legacy message it must not decrypt without the associated data either
+	legacyCopy := message
+	legacyCopy.ADVersion = 0
+	if _, err := handler.decryptMessage(&legacyCopy); err == nil {
+		t.Error("content with associated data decrypted as a legacy message")
+	}
+}
+
+func TestDecryptLegacyMessage(t *testing.T) {
+	handler := setupTestHandler()
+
+	ciphered, err := handler.Cipher.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := models.Message{
+		Id:      primitive.NewObjectID(),
+		Content: hex.EncodeToString(ciphered),
+	}
+
+	plainText, err := handler.decryptMessage(&message)
+	if err != nil || plainText != "hello" {
+		t.Fatalf("expected hello, got %q (err %v)", plainText, err)
+	}
+
+	handler.RequireMessageAD = true
+	if _, err := handler.decryptMessage(&message); !errors.Is(err, errMessageWithoutAD) {
+		t.Errorf("expected errMessageWithoutAD, got %v", err)
+	}
+}
+
+func TestIsValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👩‍💻", true},
+		{"🇮🇷", true},
+		{"1️⃣", true},
+		{"", false},
+		{"a", false},
+		{"ok", false},
+		{"👍.", false},
+		{"$👍", false},
+		{"👍 👍", false},
+		{"é", false},
+		{strings.Repeat("👍", 9), false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidEmoji(tt.emoji); got != tt.want {
+			t.Errorf("isValidEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
+
+func TestReactionCounts(t *testing.T) {
+	viewer := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+
+	message := &models.Message{
+		Reactions: map[string][]primitive.ObjectID{
+			"🔥": {other},
+			"👍": {viewer, other},
+			"😂": {other},
+			"😢": {},
+		},
+	}
+
+	counts := reactionCounts(message, viewer)
+
+	want := []models.ReactionCount{
+		{Emoji: "👍", Count: 2, ReactedByMe: true},
+		{Emoji: "🔥", Count: 1},
+		{Emoji: "😂", Count: 1},
+	}
+
+	if len(counts) != len(want) {
+		t.Fatalf("Expected %d counts, got %+v", len(want), counts)
+	}
+
+	for idx := range want {
+		if counts[idx] != want[idx] {
+			t.Errorf("Count %d: expected %+v, got %+v", idx, want[idx], counts[idx])
+		}
+	}
+}
+
+func TestParseEditWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"15m", 15 * time.Minute, false},
+		{"48h", 48 * time.Hour, false},
+		{"-1h", 0, true},
+		{"two days", 0, true},
+	}
+
+	for _, tt := range tests {
+		window, err := parseEditWindow(tt.value)
+		if (err != nil) != tt.wantErr || window != tt.want {
+			t.Errorf("parseEditWindow(%q) = %s, %v, want %s (error %v)", tt.value, window, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEditMessageConcurrentHistory(t *testing.T) {
+	handler := setupDatabaseHandler(t)
+
+	senderId, chatId := primitive.NewObjectID(), primitive.NewObjectID()
+
+	original, err := handler.Models.Message.Create(primitive.NewObjectID(), chatId, primitive.NilObjectID, senderId,
+		primitive.NewObjectID(), "text", "", "original", "", primitive.NilObjectID, false)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	const editors = 8
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	edited := 0
+
+	for i := 0; i < editors; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := handler.editMessage(senderId, original.Id, "edit", nil)
+			if err != nil && !errors.Is(err, errEditConflict) {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+
+			if err == nil {
+				mutex.Lock()
+				edited++
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	edits, err := handler.Models.MessageEdit.GetAll(original.Id)
+	if err != nil {
+		t.Fatalf("Failed to get edit history: %v", err)
+	}
+
+	// every edit recorded the version it replaced, so no version is in the history twice
+	if len(edits) != edited {
+		t.Errorf("Expected %d versions in the history, got %d", edited, len(edits))
+	}
+
+	seen := make(map[string]bool, len(edits))
+	for _, edit := range edits {
+		if seen[edit.Content] {
+			t.Errorf("Version %q is in the history twice", edit.Content)
+		}
+
+		seen[edit.Content] = true
+	}
+
+	if edited > 0 && !seen["original"] {
+		t.Error("Expected the original version in the history")
+	}
+}
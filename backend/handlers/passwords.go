@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"chat_app/utils"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	passwordResetTokenPrefix   = "prt_"
+	passwordResetTokenDuration = time.Hour
+)
+
+// ChangePassword -> Needs the current password. Every other session of the user is logged out
+func (handler *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	projection := bson.M{
+		"username":        1,
+		"hashed_password": 1,
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": payload.UserId}, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	if !utils.VerifyHash(user.HashedPassword, input.OldPassword) {
+		utils.WriteError(w, http.StatusBadRequest, "passwordValidation", "password is invalid")
+		return
+	}
+
+	if input.OldPassword == input.NewPassword {
+		utils.WriteError(w, http.StatusBadRequest, "samePassword", "new password must differ from the old one")
+		return
+	}
+
+	if errResp := handler.setPassword(user.Id, user.Username, input.NewPassword); errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	result, err := handler.Models.Session.RevokeAllExcept(payload.UserId, payload.SessionId.String())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "revokeSessions", "failed to revoke other sessions")
+		return
+	}
+
+	handler.disconnectOtherSessions(payload.UserId, payload.SessionId.String())
+
+	resp := map[string]any{
+		"revoked_sessions": result.ModifiedCount,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// IssuePasswordResetToken -> Admin only. Replaces any unused token of the user, the plain token is shown once
+func (handler *Handler) IssuePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	userId := chi.URLParam(r, "user_id")
+	if userId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "user id is missing")
+		return
+	}
+
+	userObjectId, errResp := utils.ToObjectId(userId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	if _, err := handler.Models.User.Get(bson.M{"_id": userObjectId}, bson.M{"_id": 1}); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	if _, err := handler.Models.PasswordReset.DeleteAll(bson.M{"user_id": userObjectId, "used_at": nil}); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "deleteResetTokens", "failed to delete old reset tokens")
+		return
+	}
+
+	token := passwordResetTokenPrefix + rand.Text()
+	expireAt := time.Now().Add(passwordResetTokenDuration)
+
+	if err := handler.Models.PasswordReset.Create(userObjectId, payload.UserId, utils.HashToken(token), expireAt); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "createResetToken", "failed to create reset token")
+		return
+	}
+
+	slog.Info("password reset token issued", "user_id", userId, "admin_id", payload.UserId.Hex())
+
+	resp := map[string]any{
+		"reset_token": token,
+		"expire_at":   expireAt,
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// ResetPassword -> Sets a new password with a reset token. Logs the user out everywhere and lifts a lockout
+func (handler *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	tokenFilter := bson.M{
+		"hashed_token": utils.HashToken(input.Token),
+		"used_at":      nil,
+		"expire_at": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+
+	reset, err := handler.Models.PasswordReset.Get(tokenFilter, bson.M{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusBadRequest, "resetTokenNotValid", "reset token is invalid, used or expired")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getResetToken", "failed to fetch reset token")
+		return
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": reset.UserId}, bson.M{"username": 1})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	// validated before the token is spent, so a weak password doesn't burn it
+	if errResp := handler.PasswordPolicy.Validate(user.Username, input.NewPassword); errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	tokenFilter["_id"] = reset.Id
+
+	result, err := handler.Models.PasswordReset.Update(tokenFilter, bson.M{"used_at": time.Now()})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "useResetToken", "failed to use reset token")
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "resetTokenNotValid", "reset token is invalid, used or expired")
+		return
+	}
+
+	if errResp := handler.setPassword(user.Id, user.Username, input.NewPassword); errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	if _, err := handler.Models.Session.RevokeAllExcept(user.Id, ""); err != nil {
+		slog.Error("revoking sessions after password reset", "error", err, "user_id", user.Id.Hex())
+	}
+
+	handler.disconnectUser(user.Id)
+
+	handler.resetLoginFailures(user.Username)
+
+	utils.WriteJSON(w, http.StatusOK, "password reset successfully")
+}
+
+// setPassword -> Validates and stores a new password. A lockout from failed logins ends with it
+func (handler *Handler) setPassword(userId primitive.ObjectID, username, password string) *utils.ErrorResponse {
+	if errResp := handler.PasswordPolicy.Validate(username, password); errResp != nil {
+		return errResp
+	}
+
+	hashedPassword, err := utils.Hash([]byte(password))
+	if err != nil {
+		return &utils.ErrorResponse{Type: "hashPassword", Detail: "failed to hash the password"}
+	}
+
+	updates := bson.M{
+		"hashed_password": string(hashedPassword),
+		"locked_until":    nil,
+	}
+
+	if _, err := handler.Models.User.Update(bson.M{"_id": userId}, updates); err != nil {
+		return &utils.ErrorResponse{Type: "updateUser", Detail: "failed to update password"}
+	}
+
+	return nil
+}
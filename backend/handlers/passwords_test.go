@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChangePassword(t *testing.T) {
+	handler := setupTestHandler()
+
+	body := []byte(`{"old_password": "Old-Passw0rd", "new_password": "New-Passw0rd"}`)
+	req := httptest.NewRequest("PUT", "/api/user/change-password", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler.ChangePassword(w, req)
+
+	// Should return 401 due to missing auth payload
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("POST", "/api/password/reset", bytes.NewReader([]byte("invalid json")))
+	w := httptest.NewRecorder()
+
+	handler.ResetPassword(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestParseAdminIds(t *testing.T) {
+	first := primitive.NewObjectID()
+	second := primitive.NewObjectID()
+
+	admins, err := parseAdminIds(first.Hex() + ", " + second.Hex() + ",")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(admins) != 2 || admins[0] != first || admins[1] != second {
+		t.Errorf("Expected both admin ids, got %v", admins)
+	}
+
+	if admins, err := parseAdminIds(""); err != nil || len(admins) != 0 {
+		t.Errorf("Expected no admins, got %v (err %v)", admins, err)
+	}
+
+	if _, err := parseAdminIds("not-an-id"); err == nil {
+		t.Error("Expected error for invalid admin id")
+	}
+
+	handler := &Handler{Admins: admins}
+	if handler.IsAdmin(primitive.NewObjectID()) {
+		t.Error("Unknown user should not be an admin")
+	}
+}
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/utils"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxPinsPerRoom -> Pinned messages one chat or group can have
+const maxPinsPerRoom = 50
+
+// PinnedMessage -> A pin with its decrypted message, as listed
+type PinnedMessage struct {
+	models.Pin
+	Message *models.Message `json:"message"`
+}
+
+// pinRoom -> The room of the message, once the user may change its pins. Group pins are for admins,
+// any participant can pin in chats and secret chats
+func (handler *Handler) pinRoom(w http.ResponseWriter, userId, messageId primitive.ObjectID) (*models.Message, Room, bool) {
+	projection := bson.M{
+		"_id":       1,
+		"chat_id":   1,
+		"group_id":  1,
+		"is_secret": 1,
+	}
+
+	message, err := handler.Models.Message.Get(bson.M{"_id": messageId}, projection)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+			return nil, Room{}, false
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getMsg", "failed to get the message")
+		return nil, Room{}, false
+	}
+
+	room := RoomOfMessage(message)
+
+	isMember, err := handler.isRoomMember(room, userId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return nil, Room{}, false
+	}
+
+	if !isMember {
+		utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+		return nil, Room{}, false
+	}
+
+	if room.Kind == RoomGroup {
+		group, err := handler.Models.Group.Get(bson.M{"_id": room.ObjectId}, bson.M{"admins": 1})
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "getGroup", "failed to get the group")
+			return nil, Room{}, false
+		}
+
+		if !slices.Contains(group.Admins, userId) {
+			utils.WriteError(w, http.StatusForbidden, "pinMessage", "only group admins can pin messages")
+			return nil, Room{}, false
+		}
+	}
+
+	return message, room, true
+}
+
+// PinMessage -> The message goes after the pins its chat or group has
+func (handler *Handler) PinMessage(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	messageObjectId, ok := pinMessageId(w, r)
+	if !ok {
+		return
+	}
+
+	message, room, ok := handler.pinRoom(w, payload.UserId, messageObjectId)
+	if !ok {
+		return
+	}
+
+	pin, err := handler.Models.Pin.Create(message, payload.UserId, maxPinsPerRoom)
+	if err != nil {
+		if errors.Is(err, models.ErrAlreadyPinned) {
+			utils.WriteError(w, http.StatusConflict, "pinMessage", err.Error())
+			return
+		}
+
+		if errors.Is(err, models.ErrTooManyPins) {
+			utils.WriteError(w, http.StatusConflict, "tooManyPins", err.Error())
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "pinMessage", "failed to pin the message")
+		return
+	}
+
+	handler.pinsChanged(room, PinsPinned, message.Id, payload.UserId)
+
+	utils.WriteJSON(w, http.StatusCreated, pin)
+}
+
+func (handler *Handler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	messageObjectId, ok := pinMessageId(w, r)
+	if !ok {
+		return
+	}
+
+	message, room, ok := handler.pinRoom(w, payload.UserId, messageObjectId)
+	if !ok {
+		return
+	}
+
+	result, err := handler.Models.Pin.Delete(bson.M{"message_id": message.Id})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "unpinMessage", "failed to unpin the message")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		utils.WriteError(w, http.StatusNotFound, "unpinMessage", "message is not pinned")
+		return
+	}
+
+	handler.pinsChanged(room, PinsUnpinned, message.Id, payload.UserId)
+
+	utils.WriteJSON(w, http.StatusOK, "message unpinned successfully")
+}
+
+// ReorderPins -> message_ids lists every pinned message of one chat or group, in the new order
+func (handler *Handler) ReorderPins(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MessageIds []string `json:"message_ids"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 10_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", "failed to parse the body data")
+		return
+	}
+
+	messageIds, errResp := pinOrder(input.MessageIds)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	_, room, ok := handler.pinRoom(w, payload.UserId, messageIds[0])
+	if !ok {
+		return
+	}
+
+	pins, err := handler.Models.Pin.GetAll(room.MessageFilter())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "fetchPins", "failed to fetch the pinned messages")
+		return
+	}
+
+	if !samePins(pins, messageIds) {
+		utils.WriteError(w, http.StatusBadRequest, "invalidOrder",
+			"message_ids must list every pinned message of the chat or group once")
+		return
+	}
+
+	if err := handler.Models.Pin.SetPositions(room.MessageFilter(), messageIds); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "reorderPins", "failed to reorder the pinned messages")
+		return
+	}
+
+	handler.pinsChanged(room, PinsReordered, primitive.NilObjectID, payload.UserId)
+
+	utils.WriteJSON(w, http.StatusOK, "pinned messages reordered successfully")
+}
+
+func (handler *Handler) GetChatPins(w http.ResponseWriter, r *http.Request) {
+	handler.getPins(w, r, RoomChat, "chat_id")
+}
+
+func (handler *Handler) GetSecretChatPins(w http.ResponseWriter, r *http.Request) {
+	handler.getPins(w, r, RoomSecretChat, "secret_chat_id")
+}
+
+func (handler *Handler) GetGroupPins(w http.ResponseWriter, r *http.Request) {
+	handler.getPins(w, r, RoomGroup, "group_id")
+}
+
+// getPins -> Pinned messages of the room in order, decrypted. Messages the viewer deleted for themselves are left out
+func (handler *Handler) getPins(w http.ResponseWriter, r *http.Request, kind, param string) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, param)
+	if id == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", param+" is missing")
+		return
+	}
+
+	objectId, errResp := utils.ToObjectId(id)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	room := Room{
+		Id:       roomId(kind, objectId),
+		Kind:     kind,
+		ObjectId: objectId,
+		IsSecret: kind == RoomSecretChat,
+	}
+
+	isMember, err := handler.isRoomMember(room, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return
+	}
+
+	if !isMember {
+		utils.WriteError(w, http.StatusForbidden, "getPins", "you are not a member of this chat or group")
+		return
+	}
+
+	pins, err := handler.Models.Pin.GetAll(room.MessageFilter())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "fetchPins", "failed to fetch the pinned messages")
+		return
+	}
+
+	pinned := make([]PinnedMessage, 0, len(pins))
+	if len(pins) == 0 {
+		utils.WriteJSON(w, http.StatusOK, pinned)
+		return
+	}
+
+	messageIds := make([]primitive.ObjectID, 0, len(pins))
+	for _, pin := range pins {
+		messageIds = append(messageIds, pin.MessageId)
+	}
+
+	filter := room.MessageFilter()
+	filter["_id"] = bson.M{"$in": messageIds}
+
+	messages, err := handler.Models.Message.GetAll(filter, bson.M{}, 1, int64(len(messageIds)))
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utils.WriteError(w, http.StatusInternalServerError, "fetchMessages", "failed to fetch the pinned messages")
+		return
+	}
+
+	byId := make(map[primitive.ObjectID]*models.Message, len(messages))
+	for idx := range messages {
+		message := &messages[idx]
+		if message.IsDeletedForSender && message.SenderId == payload.UserId {
+			continue
+		}
+
+		decryptedMsg, err := handler.decryptMessage(message)
+		if err != nil {
+			slog.Warn("failed to decrypt pinned message", "err", err, "msgID", message.Id.Hex())
+			continue
+		}
+
+		message.Content = decryptedMsg
+		byId[message.Id] = message
+	}
+
+	for _, pin := range pins {
+		if message, ok := byId[pin.MessageId]; ok {
+			pinned = append(pinned, PinnedMessage{Pin: pin, Message: message})
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, pinned)
+}
+
+// pinsChanged -> Tells the room the new order of its pins. A group's PinnedMessageId follows its first pin
+func (handler *Handler) pinsChanged(room Room, action string, messageId, userId primitive.ObjectID) {
+	pins, err := handler.Models.Pin.GetAll(room.MessageFilter())
+	if err != nil {
+		slog.Error("fetching pins", "error", err, "room", room.Id)
+		return
+	}
+
+	data := PinsUpdateData{
+		Action:     action,
+		MessageIds: make([]string, 0, len(pins)),
+		UpdatedBy:  userId.Hex(),
+	}
+
+	if !messageId.IsZero() {
+		data.MessageId = messageId.Hex()
+	}
+
+	for _, pin := range pins {
+		data.MessageIds = append(data.MessageIds, pin.MessageId.Hex())
+	}
+
+	if room.Kind == RoomGroup {
+		firstPin := primitive.NilObjectID
+		if len(pins) > 0 {
+			firstPin = pins[0].MessageId
+		}
+
+		updates := bson.M{"pinned_message_id": firstPin}
+		if _, err := handler.Models.Group.Update(bson.M{"_id": room.ObjectId}, updates); err != nil {
+			slog.Error("updating pinned message of group", "error", err, "group_id", room.ObjectId.Hex())
+		}
+	}
+
+	handler.publish(room.Id, "", EventPinsUpdate, data)
+}
+
+func pinMessageId(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	messageId := chi.URLParam(r, "message_id")
+	if messageId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", "message id is missing")
+		return primitive.NilObjectID, false
+	}
+
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return primitive.NilObjectID, false
+	}
+
+	return messageObjectId, true
+}
+
+// pinOrder -> The message ids of a reorder, none missing or repeated
+func pinOrder(messageIds []string) ([]primitive.ObjectID, *utils.ErrorResponse) {
+	if len(messageIds) == 0 || len(messageIds) > maxPinsPerRoom {
+		return nil, &utils.ErrorResponse{Type: "invalidOrder", Detail: "message_ids must list the pinned messages"}
+	}
+
+	objectIds := make([]primitive.ObjectID, 0, len(messageIds))
+	for _, messageId := range messageIds {
+		objectId, errResp := utils.ToObjectId(messageId)
+		if errResp != nil {
+			return nil, errResp
+		}
+
+		if slices.Contains(objectIds, objectId) {
+			return nil, &utils.ErrorResponse{Type: "invalidOrder", Detail: "message_ids has a message twice"}
+		}
+
+		objectIds = append(objectIds, objectId)
+	}
+
+	return objectIds, nil
+}
+
+// samePins -> Whether the message ids are exactly the pinned messages, in any order
+func samePins(pins []models.Pin, messageIds []primitive.ObjectID) bool {
+	if len(pins) != len(messageIds) {
+		return false
+	}
+
+	for _, pin := range pins {
+		if !slices.Contains(messageIds, pin.MessageId) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPinOrder(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tests := []struct {
+		name       string
+		messageIds []string
+		wantErr    bool
+	}{
+		{name: "Valid Order", messageIds: []string{second.Hex(), first.Hex()}},
+		{name: "Empty", messageIds: nil, wantErr: true},
+		{name: "Invalid Id", messageIds: []string{"not-an-id"}, wantErr: true},
+		{name: "Repeated Id", messageIds: []string{first.Hex(), first.Hex()}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objectIds, errResp := pinOrder(tt.messageIds)
+			if (errResp != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %+v", tt.wantErr, errResp)
+			}
+
+			if !tt.wantErr && (objectIds[0] != second || objectIds[1] != first) {
+				t.Errorf("Expected the order to be kept, got %v", objectIds)
+			}
+		})
+	}
+}
+
+func TestSamePins(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	pins := []models.Pin{{MessageId: first}, {MessageId: second}}
+
+	if !samePins(pins, []primitive.ObjectID{second, first}) {
+		t.Error("Expected the pins in another order to match")
+	}
+
+	if samePins(pins, []primitive.ObjectID{first}) {
+		t.Error("Expected a missing pin not to match")
+	}
+
+	if samePins(pins, []primitive.ObjectID{first, primitive.NewObjectID()}) {
+		t.Error("Expected a message that isn't pinned not to match")
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// maxReactionEmojis -> Distinct emojis one message can have, any number of users can share each
+	maxReactionEmojis = 20
+	// maxEmojiBytes -> Long enough for flags, skin tones and zero width joined sequences
+	maxEmojiBytes = 32
+)
+
+var (
+	errInvalidEmoji     = errors.New("emoji is invalid")
+	errTooManyReactions = errors.New("message has the most distinct emojis already")
+)
+
+// isValidEmoji -> Emojis are stored as field names, so '.' and '$' are refused along with spaces, control
+// characters and plain text
+func isValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes || strings.ContainsAny(emoji, ".$") {
+		return false
+	}
+
+	hasSymbol := false
+	for _, char := range emoji {
+		if unicode.IsSpace(char) || unicode.IsControl(char) || unicode.IsLetter(char) {
+			return false
+		}
+
+		if char > unicode.MaxASCII {
+			hasSymbol = true
+		}
+	}
+
+	return hasSymbol
+}
+
+// reactionCounts -> The reactions of the message as counts, most used first
+func reactionCounts(message *models.Message, viewerId primitive.ObjectID) []models.ReactionCount {
+	counts := make([]models.ReactionCount, 0, len(message.Reactions))
+	for emoji, userIds := range message.Reactions {
+		if len(userIds) == 0 {
+			continue
+		}
+
+		counts = append(counts, models.ReactionCount{
+			Emoji:       emoji,
+			Count:       len(userIds),
+			ReactedByMe: slices.Contains(userIds, viewerId),
+		})
+	}
+
+	slices.SortFunc(counts, func(a, b models.ReactionCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+
+		return strings.Compare(a.Emoji, b.Emoji)
+	})
+
+	return counts
+}
+
+// attachReactionCounts -> Sets ReactionCounts of the messages, seen from viewerId
+func attachReactionCounts(messages []models.Message, viewerId primitive.ObjectID) {
+	for idx := range messages {
+		if len(messages[idx].Reactions) > 0 {
+			messages[idx].ReactionCounts = reactionCounts(&messages[idx], viewerId)
+		}
+	}
+}
+
+// reactToMessage -> Adds or removes the user's reaction on a message in scope. False when nothing changed,
+// e.g. the user had reacted with the emoji already
+func (handler *Handler) reactToMessage(userId, messageId primitive.ObjectID, emoji string, add bool,
+	scope bson.M) (bool, error) {
+	if !isValidEmoji(emoji) {
+		return false, errInvalidEmoji
+	}
+
+	filter := bson.M{
+		"_id": messageId,
+	}
+
+	for key, value := range scope {
+		filter[key] = value
+	}
+
+	var result *mongo.UpdateResult
+	var err error
+	if add {
+		result, err = handler.Models.Message.AddReaction(filter, emoji, userId, maxReactionEmojis)
+	} else {
+		result, err = handler.Models.Message.RemoveReaction(filter, emoji, userId)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if result.MatchedCount > 0 {
+		return result.ModifiedCount > 0, nil
+	}
+
+	// the message is gone, or the cap kept a new emoji out
+	if _, err := handler.Models.Message.Get(filter, bson.M{"_id": 1}); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, errMessageNotFound
+		}
+
+		return false, err
+	}
+
+	if add {
+		return false, errTooManyReactions
+	}
+
+	return false, nil
+}
+
+// AddReaction -> Any member of the message's chat or group can react
+func (handler *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Emoji string `json:"emoji"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", "failed to parse the body data")
+		return
+	}
+
+	handler.updateReaction(w, r, input.Emoji, true)
+}
+
+// RemoveReaction -> The emoji is in the emoji query
+func (handler *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	handler.updateReaction(w, r, r.URL.Query().Get("emoji"), false)
+}
+
+func (handler *Handler) updateReaction(w http.ResponseWriter, r *http.Request, emoji string, add bool) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	messageId := chi.URLParam(r, "message_id")
+	if messageId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", "message id is missing")
+		return
+	}
+
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	projection := bson.M{
+		"_id":       1,
+		"chat_id":   1,
+		"group_id":  1,
+		"is_secret": 1,
+	}
+
+	message, err := handler.Models.Message.Get(bson.M{"_id": messageObjectId}, projection)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getMsg", "failed to get the message")
+		return
+	}
+
+	room := RoomOfMessage(message)
+
+	isMember, err := handler.isRoomMember(room, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return
+	}
+
+	if !isMember {
+		utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+		return
+	}
+
+	changed, err := handler.reactToMessage(payload.UserId, message.Id, emoji, add, room.MessageFilter())
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidEmoji):
+			utils.WriteError(w, http.StatusBadRequest, "invalidEmoji", err.Error())
+		case errors.Is(err, errTooManyReactions):
+			utils.WriteError(w, http.StatusConflict, "tooManyReactions", err.Error())
+		case errors.Is(err, errMessageNotFound):
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, "updateReaction", "failed to update the reaction")
+		}
+		return
+	}
+
+	if changed {
+		eventType := EventReactionRemove
+		if add {
+			eventType = EventReactionAdd
+		}
+
+		handler.publish(room.Id, "", eventType, ReactionData{
+			MessageId: message.Id.Hex(),
+			Emoji:     emoji,
+			UserId:    payload.UserId.Hex(),
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "reaction updated successfully")
+}
+
+// handleReaction -> reaction.add and reaction.remove. The room gets the event only when something changed
+func (handler *Handler) handleReaction(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	var data ReactionData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "data of "+envelope.Type+" is invalid")
+		return
+	}
+
+	if !isValidEmoji(data.Emoji) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, errInvalidEmoji.Error())
+		return
+	}
+
+	userId, messageId, ok := eventMessageIds(wsConn, envelope, room, data.MessageId)
+	if !ok {
+		return
+	}
+
+	if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+		return
+	}
+
+	changed, err := handler.reactToMessage(userId, messageId, data.Emoji, envelope.Type == EventReactionAdd,
+		room.MessageFilter())
+	if errors.Is(err, errTooManyReactions) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, err.Error())
+		return
+	}
+
+	if err != nil {
+		handler.sendMessageError(wsConn, envelope, room, err)
+		return
+	}
+
+	if !changed {
+		return
+	}
+
+	// whatever identity the client sent is replaced
+	data.UserId = wsConn.UserId
+
+	handler.publish(room.Id, "", envelope.Type, data)
+}
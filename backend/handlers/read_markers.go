@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unreadCountLimit -> Unread messages are counted up to this, clients show it as "999+"
+const unreadCountLimit = 1000
+
+// readMarkerRoom -> Read markers are keyed like the room sequences, secret chats share the chat key
+func readMarkerRoom(room Room) string {
+	if room.Kind == RoomGroup {
+		return models.GroupKeyOwner(room.ObjectId)
+	}
+
+	return models.ChatKeyOwner(room.ObjectId)
+}
+
+// markRead -> Everything in the room up to the message counts as read by the user. The other participant
+// of a chat or secret chat is told, groups get no event so a read doesn't reach every member
+func (handler *Handler) markRead(userId primitive.ObjectID, room Room, messageId primitive.ObjectID) (int64, error) {
+	filter := room.MessageFilter()
+	filter["_id"] = messageId
+
+	message, err := handler.Models.Message.Get(filter, bson.M{"seq": 1})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, errMessageNotFound
+		}
+
+		return 0, err
+	}
+
+	// stored before messages were numbered
+	if message.Seq == 0 {
+		return 0, nil
+	}
+
+	advanced, err := handler.Models.ReadMarker.Advance(userId, readMarkerRoom(room), message.Seq)
+	if err != nil {
+		return 0, err
+	}
+
+	if advanced && room.Kind != RoomGroup {
+		handler.publish(room.Id, "", EventMessageRead, ReadData{
+			MessageId: messageId.Hex(),
+			Seq:       message.Seq,
+			UserId:    userId.Hex(),
+		})
+	}
+
+	return message.Seq, nil
+}
+
+// MarkRead -> Marks the message and everything before it in its chat or group as read
+func (handler *Handler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	messageId := chi.URLParam(r, "message_id")
+	if messageId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", "message id is missing")
+		return
+	}
+
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	projection := bson.M{
+		"_id":       1,
+		"chat_id":   1,
+		"group_id":  1,
+		"is_secret": 1,
+	}
+
+	message, err := handler.Models.Message.Get(bson.M{"_id": messageObjectId}, projection)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getMsg", "failed to get the message")
+		return
+	}
+
+	room := RoomOfMessage(message)
+
+	isMember, err := handler.isRoomMember(room, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return
+	}
+
+	if !isMember {
+		utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+		return
+	}
+
+	seq, err := handler.markRead(payload.UserId, room, message.Id)
+	if err != nil {
+		if errors.Is(err, errMessageNotFound) {
+			utils.WriteError(w, http.StatusNotFound, "getMsg", "message does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "markRead", "failed to mark the message as read")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]int64{"seq": seq})
+}
+
+// handleMessageRead -> message.read from a client, the same as MarkRead
+func (handler *Handler) handleMessageRead(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	var data ReadData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "data of message.read is invalid")
+		return
+	}
+
+	userId, messageId, ok := eventMessageIds(wsConn, envelope, room, data.MessageId)
+	if !ok {
+		return
+	}
+
+	if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+		return
+	}
+
+	if _, err := handler.markRead(userId, room, messageId); err != nil {
+		if errors.Is(err, errMessageNotFound) {
+			wsConn.sendError(envelope.Id, room.Id, errCodeNotFound, "no message with this id in this room")
+			return
+		}
+
+		slog.Error("marking as read", "error", err, "room", room.Id, "user_id", wsConn.UserId)
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to mark the message as read")
+	}
+}
+
+// unreadCounts -> Unread messages of the user in each room, keyed by the hex id of the chat or group.
+// Rooms that fail to count are left out
+func (handler *Handler) unreadCounts(userId primitive.ObjectID, rooms []Room) map[string]int64 {
+	counts := make(map[string]int64, len(rooms))
+	if len(rooms) == 0 {
+		return counts
+	}
+
+	keys := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		keys = append(keys, readMarkerRoom(room))
+	}
+
+	filter := bson.M{
+		"user_id": userId,
+		"room":    bson.M{"$in": keys},
+	}
+
+	seqs, err := handler.Models.ReadMarker.GetSeqs(filter)
+	if err != nil {
+		slog.Error("fetching read markers", "error", err, "user_id", userId.Hex())
+		return counts
+	}
+
+	for idx, room := range rooms {
+		count, err := handler.Models.Message.CountUnread(room.MessageFilter(), seqs[keys[idx]], userId, unreadCountLimit)
+		if err != nil {
+			slog.Error("counting unread messages", "error", err, "room", room.Id)
+			continue
+		}
+
+		counts[room.ObjectId.Hex()] = count
+	}
+
+	return counts
+}
+
+// partnerReadSeqs -> How far the other participant of each chat or secret chat has read, keyed by the hex
+// id of the chat. The sender's messages up to it are seen
+func (handler *Handler) partnerReadSeqs(userId primitive.ObjectID, rooms []Room) map[string]int64 {
+	readSeqs := make(map[string]int64, len(rooms))
+	if len(rooms) == 0 {
+		return readSeqs
+	}
+
+	keys := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		keys = append(keys, readMarkerRoom(room))
+	}
+
+	filter := bson.M{
+		"user_id": bson.M{"$ne": userId},
+		"room":    bson.M{"$in": keys},
+	}
+
+	seqs, err := handler.Models.ReadMarker.GetSeqs(filter)
+	if err != nil {
+		slog.Error("fetching read markers", "error", err, "user_id", userId.Hex())
+		return readSeqs
+	}
+
+	for idx, room := range rooms {
+		readSeqs[room.ObjectId.Hex()] = seqs[keys[idx]]
+	}
+
+	return readSeqs
+}
+
+// deleteReadMarkers -> Removes every read marker of a chat or group
+func (handler *Handler) deleteReadMarkers(room string) {
+	if _, err := handler.Models.ReadMarker.DeleteAll(bson.M{"room": room}); err != nil {
+		slog.Error("deleting read markers", "error", err, "room", room)
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReadMarkerRoom(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name string
+		room Room
+		want string
+	}{
+		{name: "Chat", room: Room{Kind: RoomChat, ObjectId: id}, want: models.ChatKeyOwner(id)},
+		{name: "Secret Chat", room: Room{Kind: RoomSecretChat, ObjectId: id, IsSecret: true}, want: models.ChatKeyOwner(id)},
+		{name: "Group", room: Room{Kind: RoomGroup, ObjectId: id}, want: models.GroupKeyOwner(id)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// markers share the key of the room's sequence, see Message.KeyOwner
+			if got := readMarkerRoom(tt.room); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnreadCountsWithoutRooms(t *testing.T) {
+	handler := &Handler{}
+
+	if counts := handler.unreadCounts(primitive.NewObjectID(), nil); len(counts) != 0 {
+		t.Errorf("Expected no counts, got %v", counts)
+	}
+
+	if seqs := handler.partnerReadSeqs(primitive.NewObjectID(), nil); len(seqs) != 0 {
+		t.Errorf("Expected no read seqs, got %v", seqs)
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/utils"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// replyPreviewLength -> Characters of the parent's content quoted by a reply
+	replyPreviewLength = 120
+	// threadLimit -> Replies returned for one thread
+	threadLimit = 500
+)
+
+var errReplyNotInRoom = errors.New("reply_to_id is not a message of this chat or group")
+
+// parseReplyToId -> Zero when the message isn't a reply
+func parseReplyToId(replyToId string) (primitive.ObjectID, *utils.ErrorResponse) {
+	if replyToId == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	return utils.ToObjectId(replyToId)
+}
+
+// replyParent -> The message replied to, which has to be in the chat or group matched by roomFilter
+func (handler *Handler) replyParent(roomFilter bson.M, replyToId primitive.ObjectID) (*models.Message, error) {
+	filter := bson.M{
+		"_id": replyToId,
+	}
+
+	for key, value := range roomFilter {
+		filter[key] = value
+	}
+
+	message, err := handler.Models.Message.Get(filter, bson.M{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errReplyNotInRoom
+		}
+
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// messagePreview -> The decrypted content of the message, cut to replyPreviewLength characters
+func (handler *Handler) messagePreview(message *models.Message) (*models.MessagePreview, error) {
+	content, err := handler.decryptMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if runes := []rune(content); len(runes) > replyPreviewLength {
+		content = string(runes[:replyPreviewLength])
+	}
+
+	return &models.MessagePreview{
+		Id:             message.Id,
+		SenderId:       message.SenderId,
+		Type:           message.Type,
+		Content:        content,
+		ContentAddress: message.ContentAddress,
+		CreatedAt:      message.CreatedAt,
+	}, nil
+}
+
+// attachReplyPreviews -> Sets ReplyTo of every reply in the messages. Parents that were deleted, or that
+// the viewer deleted for themselves, get no preview
+func (handler *Handler) attachReplyPreviews(messages []models.Message, roomFilter bson.M, viewerId primitive.ObjectID) {
+	var parentIds []primitive.ObjectID
+	for _, message := range messages {
+		if message.ReplyToId != nil {
+			parentIds = append(parentIds, *message.ReplyToId)
+		}
+	}
+
+	if len(parentIds) == 0 {
+		return
+	}
+
+	filter := bson.M{
+		"_id": bson.M{
+			"$in": parentIds,
+		},
+	}
+
+	for key, value := range roomFilter {
+		filter[key] = value
+	}
+
+	parents, err := handler.Models.Message.GetAll(filter, bson.M{}, 1, int64(len(parentIds)))
+	if err != nil {
+		slog.Error("fetching replied messages", "error", err)
+		return
+	}
+
+	previews := make(map[primitive.ObjectID]*models.MessagePreview, len(parents))
+	for idx := range parents {
+		parent := &parents[idx]
+		if parent.IsDeletedForSender && parent.SenderId == viewerId {
+			continue
+		}
+
+		preview, err := handler.messagePreview(parent)
+		if err != nil {
+			slog.Warn("failed to decrypt replied message", "err", err, "msgID", parent.Id.Hex())
+			continue
+		}
+
+		previews[parent.Id] = preview
+	}
+
+	for idx := range messages {
+		if messages[idx].ReplyToId != nil {
+			messages[idx].ReplyTo = previews[*messages[idx].ReplyToId]
+		}
+	}
+}
+
+// GetMessageThread -> The message and every reply under it, replies of replies included, oldest first
+func (handler *Handler) GetMessageThread(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	messageId := chi.URLParam(r, "message_id")
+	if messageId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "missingParam", "message id is missing")
+		return
+	}
+
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	root, err := handler.Models.Message.Get(bson.M{"_id": messageObjectId}, bson.M{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getMessage", "message does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getMessage", "failed to get the message")
+		return
+	}
+
+	room := RoomOfMessage(root)
+
+	isMember, err := handler.isRoomMember(room, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "checkMembership", "failed to check your membership")
+		return
+	}
+
+	// same answer as a missing message, ids of other rooms are not confirmed
+	if !isMember {
+		utils.WriteError(w, http.StatusNotFound, "getMessage", "message does not exist")
+		return
+	}
+
+	replies, err := handler.threadReplies(root.Id)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "fetchMessages", "failed to fetch the replies")
+		return
+	}
+
+	thread := append([]models.Message{*root}, replies...)
+
+	for idx := range thread {
+		if thread[idx].IsDeletedForSender && thread[idx].SenderId == payload.UserId {
+			thread[idx] = models.Message{} // skip it
+			continue
+		}
+
+		decryptedMsg, err := handler.decryptMessage(&thread[idx])
+		if err != nil {
+			slog.Warn("failed to decrypt message", "err", err, "msgID", thread[idx].Id.Hex())
+			continue
+		}
+
+		thread[idx].Content = decryptedMsg
+	}
+
+	handler.attachReplyPreviews(thread, room.MessageFilter(), payload.UserId)
+	attachReactionCounts(thread, payload.UserId)
+
+	resp := map[string]any{
+		"message": thread[0],
+		"replies": thread[1:],
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// threadReplies -> Walks the replies level by level, at most threadLimit of them. Replies are always in the
+// room of their parent, so the walk never leaves the root's room
+func (handler *Handler) threadReplies(rootId primitive.ObjectID) ([]models.Message, error) {
+	var replies []models.Message
+
+	parentIds := []primitive.ObjectID{rootId}
+	for len(parentIds) > 0 && len(replies) < threadLimit {
+		level, err := handler.Models.Message.GetReplies(parentIds, int64(threadLimit-len(replies)))
+		if err != nil {
+			return nil, err
+		}
+
+		parentIds = parentIds[:0]
+		for _, reply := range level {
+			parentIds = append(parentIds, reply.Id)
+		}
+
+		replies = append(replies, level...)
+	}
+
+	slices.SortStableFunc(replies, func(a, b models.Message) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return replies, nil
+}
@@ -12,9 +12,8 @@ import (
 )
 
 func (handler *Handler) CreateSaveMessage(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -38,9 +37,8 @@ func (handler *Handler) CreateSaveMessage(w http.ResponseWriter, r *http.Request
 }
 
 func (handler *Handler) GetSaveMessages(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -69,9 +67,8 @@ func (handler *Handler) GetSaveMessages(w http.ResponseWriter, r *http.Request)
 }
 
 func (handler *Handler) EditSaveMessage(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -131,9 +128,8 @@ func (handler *Handler) EditSaveMessage(w http.ResponseWriter, r *http.Request)
 }
 
 func (handler *Handler) DeleteSaveMessage(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -17,8 +17,8 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -29,8 +29,8 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -47,8 +47,8 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -65,8 +65,8 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -83,8 +83,8 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -102,9 +102,9 @@ func TestCreateSaveMessage(t *testing.T) {
 
 		handler.CreateSaveMessage(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -118,8 +118,8 @@ func TestGetSaveMessages(t *testing.T) {
 
 		handler.GetSaveMessages(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -130,9 +130,9 @@ func TestGetSaveMessages(t *testing.T) {
 
 		handler.GetSaveMessages(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -146,8 +146,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -158,8 +158,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -170,8 +170,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -183,8 +183,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -202,8 +202,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -221,8 +221,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -240,8 +240,8 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -260,9 +260,9 @@ func TestEditSaveMessage(t *testing.T) {
 
 		handler.EditSaveMessage(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
@@ -276,8 +276,8 @@ func TestDeleteSaveMessage(t *testing.T) {
 
 		handler.DeleteSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -288,8 +288,8 @@ func TestDeleteSaveMessage(t *testing.T) {
 
 		handler.DeleteSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -300,8 +300,8 @@ func TestDeleteSaveMessage(t *testing.T) {
 
 		handler.DeleteSaveMessage(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 
@@ -312,9 +312,9 @@ func TestDeleteSaveMessage(t *testing.T) {
 
 		handler.DeleteSaveMessage(w, req)
 
-		// Should return 400 due to database connection issues in test environment
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return 401 due to invalid auth token in test environment
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
 		}
 	})
 }
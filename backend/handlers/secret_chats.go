@@ -3,9 +3,7 @@ package handlers
 import (
 	"chat_app/database/models"
 	"chat_app/utils"
-	"encoding/hex"
 	"errors"
-	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -15,9 +13,7 @@ import (
 )
 
 func (handler *Handler) GetSecretChat(w http.ResponseWriter, r *http.Request) {
-	_, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	if _, ok := authPayload(w, r); !ok {
 		return
 	}
 
@@ -47,9 +43,8 @@ func (handler *Handler) GetSecretChat(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) CreateSecretChat(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -94,6 +89,10 @@ func (handler *Handler) CreateSecretChat(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	secretChat := &models.SecretChat{Id: result, User1: payload.UserId, User2: targetUserObjectId}
+	handler.joinRoom(payload.UserId, SecretChatRoom(secretChat, payload.UserId))
+	handler.joinRoom(targetUserObjectId, SecretChatRoom(secretChat, targetUserObjectId))
+
 	resp := map[string]string{
 		"secret_chat_id": result.Hex(),
 	}
@@ -103,9 +102,8 @@ func (handler *Handler) CreateSecretChat(w http.ResponseWriter, r *http.Request)
 
 // GetSecretChatMessages -> Returns the whole messages
 func (handler *Handler) GetSecretChatMessages(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -144,29 +142,25 @@ func (handler *Handler) GetSecretChatMessages(w http.ResponseWriter, r *http.Req
 			continue
 		}
 
-		decodedMessage, err := hex.DecodeString(messages[idx].Content)
-		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, "msgDecoding", "failed to decode the message")
-			continue
-		}
-
-		decryptedMsg, err := handler.Cipher.Decrypt(decodedMessage)
+		decryptedMsg, err := handler.decryptMessage(&messages[idx])
 		if err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, "msgDecryption", "failed to decrypt the message")
 			continue
 		}
 
 		// replace it with the decrypted version
-		messages[idx].Content = string(decryptedMsg)
+		messages[idx].Content = decryptedMsg
 	}
 
+	handler.attachReplyPreviews(messages, filter, payload.UserId)
+	attachReactionCounts(messages, payload.UserId)
+
 	utils.WriteJSON(w, http.StatusOK, messages)
 }
 
 func (handler *Handler) DeleteSecretChat(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -190,11 +184,20 @@ func (handler *Handler) DeleteSecretChat(w http.ResponseWriter, r *http.Request)
 		},
 	}
 
-	if _, err := handler.Models.SecretChat.Delete(filter); err != nil {
+	result, err := handler.Models.SecretChat.Delete(filter)
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, "deleteSecretChat", err)
 		return
 	}
 
+	// the filter matches nothing for someone else's chat, its messages and data key must stay
+	if result.DeletedCount == 0 {
+		utils.WriteError(w, http.StatusNotFound, "deleteSecretChat", "secret chat does not exist")
+		return
+	}
+
+	handler.closeRoom(roomId(RoomSecretChat, chatObjectId), RoomRemovedDeleted)
+
 	filter = bson.M{
 		"chat_id":   chatObjectId,
 		"is_secret": true,
@@ -205,13 +208,17 @@ func (handler *Handler) DeleteSecretChat(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	handler.deleteEditHistory(bson.M{"chat_id": chatObjectId})
+	handler.deletePins(bson.M{"chat_id": chatObjectId})
+	handler.deleteReadMarkers(models.ChatKeyOwner(chatObjectId))
+	handler.destroyDataKey(models.ChatKeyOwner(chatObjectId))
+
 	utils.WriteJSON(w, http.StatusOK, "secret chat deleted successfully + its messages")
 }
 
 func (handler *Handler) UploadSecretChatPublicKey(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -281,9 +288,8 @@ func (handler *Handler) UploadSecretChatPublicKey(w http.ResponseWriter, r *http
 }
 
 func (handler *Handler) UploadSecretChatSymmetricKey(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -355,9 +361,8 @@ func (handler *Handler) UploadSecretChatSymmetricKey(w http.ResponseWriter, r *h
 }
 
 func (handler *Handler) ApproveSecretChat(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -381,37 +386,3 @@ func (handler *Handler) ApproveSecretChat(w http.ResponseWriter, r *http.Request
 
 	utils.WriteJSON(w, http.StatusOK, "secret chat approved")
 }
-
-func (handler *Handler) AddSecretChatWebsocket(w http.ResponseWriter, r *http.Request) {
-	chatId := chi.URLParam(r, "secret_chat_id")
-	if chatId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "chat id is missing")
-		return
-	}
-
-	senderId := r.URL.Query().Get("sender_id")
-	if senderId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "sender id is missing")
-		return
-	}
-
-	receiverId := r.URL.Query().Get("receiver_id")
-	if receiverId == "" {
-		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "receiver id is missing")
-		return
-	}
-
-	wsConn, err := WebsocketUpgrade(w, r)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "upgradingWebsocket", err)
-		return
-	}
-
-	wsConn.AddChat(chatId, senderId, handler.WebSocket)
-
-	go func() {
-		if err := wsConn.HandleChatIncomingMsgs(chatId, senderId, receiverId, true, handler.WebSocket, handler); err != nil {
-			slog.Error("handling incoming secret chat ws messages", "error", err)
-		}
-	}()
-}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"chat_app/utils"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetUserSessions -> Returns the active sessions (devices) of the user
+func (handler *Handler) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{
+		"user_id":    payload.UserId,
+		"revoked_at": nil,
+		"expire_at": bson.M{
+			"$gt": time.Now(),
+		},
+	}
+
+	projection := bson.M{
+		"device":       1,
+		"ip":           1,
+		"last_seen_at": 1,
+		"created_at":   1,
+		"expire_at":    1,
+	}
+
+	page, pageLimit, errResp := utils.ParsePageAndLimitQueryParams(r.URL)
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	sessions, err := handler.Models.Session.GetAll(filter, projection, page, pageLimit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "getSessions", err.Error())
+		return
+	}
+
+	resp := map[string]any{
+		"sessions":           sessions,
+		"current_session_id": payload.SessionId.String(),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// RevokeSession -> Logs out one device of the user
+func (handler *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	sessionId := chi.URLParam(r, "session_id")
+	if sessionId == "" {
+		utils.WriteError(w, http.StatusBadRequest, "paramMissing", "session id is missing")
+		return
+	}
+
+	result, err := handler.Models.Session.Revoke(sessionId, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "revokeSession", err.Error())
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "revokeSession", "no active session with this id exists")
+		return
+	}
+
+	handler.disconnectSession(payload.UserId, sessionId)
+
+	if sessionId == payload.SessionId.String() {
+		clearAuthCookies(w)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "session revoked successfully")
+}
+
+// RevokeAllSessions -> Logs out every other device of the user. The current session stays active
+func (handler *Handler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := handler.Models.Session.RevokeAllExcept(payload.UserId, payload.SessionId.String())
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "revokeSessions", err.Error())
+		return
+	}
+
+	handler.disconnectOtherSessions(payload.UserId, payload.SessionId.String())
+
+	resp := map[string]any{
+		"revoked_count": result.ModifiedCount,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/paseto"
+	"chat_app/utils"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	totpIssuer                 = "ChatApp"
+	twoFactorChallengeDuration = 5 * time.Minute
+	recoveryCodesCount         = 10
+)
+
+// EnrollTwoFactor -> Generates a new totp secret. 2FA stays off until the first code is confirmed
+func (handler *Handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": payload.UserId}, bson.M{"totp_enabled": 1})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	if user.TOTPEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "twoFactorEnabled", "two-factor auth is enabled already")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "generateSecret", "failed to generate totp secret")
+		return
+	}
+
+	cipheredSecret, err := handler.Cipher.EncryptFor(models.UserKeyOwner(payload.UserId), []byte(secret), nil)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "encryptSecret", "failed to encrypt totp secret")
+		return
+	}
+
+	if _, err := handler.Models.User.Update(bson.M{"_id": payload.UserId}, bson.M{"totp_secret": cipheredSecret}); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "updateUser", "failed to store totp secret")
+		return
+	}
+
+	resp := map[string]string{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(totpIssuer, payload.Username, secret),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ConfirmTwoFactor -> Turns 2FA on with the first code and returns the recovery codes (shown only once)
+func (handler *Handler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	projection := bson.M{
+		"totp_enabled": 1,
+		"totp_secret":  1,
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": payload.UserId}, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	if user.TOTPEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "twoFactorEnabled", "two-factor auth is enabled already")
+		return
+	}
+
+	if len(user.TOTPSecret) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "twoFactorNotEnrolled", "enroll two-factor auth first")
+		return
+	}
+
+	secret, err := handler.Cipher.DecryptFor(models.UserKeyOwner(payload.UserId), user.TOTPSecret, nil)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "decryptSecret", "failed to decrypt totp secret")
+		return
+	}
+
+	step, valid := utils.VerifyTOTP(string(secret), strings.TrimSpace(input.Code), time.Now())
+	if !valid {
+		utils.WriteError(w, http.StatusBadRequest, "invalidCode", "two-factor code is invalid")
+		return
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "generateRecoveryCodes", "failed to generate recovery codes")
+		return
+	}
+
+	filter := bson.M{
+		"_id":          payload.UserId,
+		"totp_enabled": false,
+	}
+
+	updates := bson.M{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": hashedCodes,
+	}
+
+	if _, err := handler.Models.User.Update(filter, updates); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "updateUser", "failed to enable two-factor auth")
+		return
+	}
+
+	resp := map[string]any{
+		"recovery_codes": recoveryCodes,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// DisableTwoFactor -> Needs the password and a current code (or a recovery code)
+func (handler *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		RawPassword string `json:"password"`
+		Code        string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": payload.UserId}, bson.M{})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "userNotFound", "user not found")
+		return
+	}
+
+	if !user.TOTPEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "twoFactorDisabled", "two-factor auth is not enabled")
+		return
+	}
+
+	if !utils.VerifyHash(user.HashedPassword, input.RawPassword) {
+		utils.WriteError(w, http.StatusBadRequest, "passwordValidation", "password is invalid")
+		return
+	}
+
+	if errResp := handler.verifySecondFactor(user, input.Code); errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	updates := bson.M{
+		"totp_enabled":   false,
+		"totp_secret":    nil,
+		"totp_last_step": 0,
+		"recovery_codes": nil,
+	}
+
+	if _, err := handler.Models.User.Update(bson.M{"_id": payload.UserId}, updates); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "updateUser", "failed to disable two-factor auth")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "two-factor auth disabled successfully")
+}
+
+// LoginTwoFactor -> Second login step: exchanges the challenge token and a code for the session cookies
+func (handler *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"` // totp code or a recovery code
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	payload, err := handler.Paseto.VerifyToken(input.ChallengeToken)
+	if err != nil || payload.Kind != paseto.TwoFactorChallenge {
+		utils.WriteError(w, http.StatusUnauthorized, "challengeNotValid", "login challenge is invalid or expired")
+		return
+	}
+
+	// the code step shares the password step counters, so codes can't be guessed with a valid password
+	ip := handler.clientIP(r)
+	if retryAfter := handler.loginRetryAfter(payload.Username, ip); retryAfter > 0 {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
+	user, err := handler.Models.User.Get(bson.M{"_id": payload.UserId}, bson.M{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusUnauthorized, "userDoesNotExist", "user does not exist anymore")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "userGetError", "failed to fetch user")
+		return
+	}
+
+	if lockout := lockedFor(user); lockout > 0 {
+		writeTooManyRequests(w, lockout)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "twoFactorDisabled", "two-factor auth is not enabled")
+		return
+	}
+
+	if errResp := handler.verifySecondFactor(user, input.Code); errResp != nil {
+		handler.recordLoginFailure(user.Username, ip, user)
+		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+		return
+	}
+
+	if errResp := handler.startSession(w, r, user.Id, user.Username); errResp != nil {
+		utils.WriteError(w, http.StatusInternalServerError, errResp.Type, errResp.Detail)
+		return
+	}
+
+	handler.resetLoginFailures(user.Username)
+
+	utils.WriteJSON(w, http.StatusOK, loginResponse(user))
+}
+
+// createTwoFactorChallenge -> Short-lived token proving the password step. It is not bound to a session
+func (handler *Handler) createTwoFactorChallenge(user *models.User) (string, error) {
+	token, _, err := handler.Paseto.CreateSessionToken(user.Id, user.Username, uuid.Nil,
+		paseto.TwoFactorChallenge, twoFactorChallengeDuration)
+
+	return token, err
+}
+
+// verifySecondFactor -> Accepts a totp code that was not used before, or an unused recovery code
+func (handler *Handler) verifySecondFactor(user *models.User, code string) *utils.ErrorResponse {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return &utils.ErrorResponse{Type: "invalidCode", Detail: "two-factor code is missing"}
+	}
+
+	secret, err := handler.Cipher.DecryptFor(models.UserKeyOwner(user.Id), user.TOTPSecret, nil)
+	if err != nil {
+		return &utils.ErrorResponse{Type: "decryptSecret", Detail: "failed to decrypt totp secret"}
+	}
+
+	if step, valid := utils.VerifyTOTP(string(secret), code, time.Now()); valid {
+		// the step filter makes every code single use, even across concurrent logins
+		filter := bson.M{
+			"_id":            user.Id,
+			"totp_last_step": bson.M{"$lt": step},
+		}
+
+		result, err := handler.Models.User.Update(filter, bson.M{"totp_last_step": step})
+		if err != nil {
+			return &utils.ErrorResponse{Type: "updateUser", Detail: "failed to verify two-factor code"}
+		}
+
+		if result.MatchedCount == 0 {
+			return &utils.ErrorResponse{Type: "codeUsed", Detail: "two-factor code was used already"}
+		}
+
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	for _, hashedCode := range user.RecoveryCodes {
+		if !utils.VerifyHash(hashedCode, normalized) {
+			continue
+		}
+
+		result, err := handler.Models.User.UseRecoveryCode(user.Id, hashedCode)
+		if err != nil {
+			return &utils.ErrorResponse{Type: "updateUser", Detail: "failed to verify recovery code"}
+		}
+
+		if result.MatchedCount == 0 {
+			return &utils.ErrorResponse{Type: "codeUsed", Detail: "recovery code was used already"}
+		}
+
+		return nil
+	}
+
+	return &utils.ErrorResponse{Type: "invalidCode", Detail: "two-factor code is invalid"}
+}
+
+// generateRecoveryCodes -> Returns the codes for the user (xxxxx-xxxxx) and their hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashedCodes := make([]string, 0, recoveryCodesCount)
+
+	for range recoveryCodesCount {
+		code := strings.ToLower(rand.Text()[:10])
+
+		hashed, err := utils.Hash([]byte(code))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashedCodes = append(hashedCodes, string(hashed))
+	}
+
+	return codes, hashedCodes, nil
+}
+
+// normalizeRecoveryCode -> Users may type the code with or without the dash, in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+
+	return strings.ReplaceAll(code, " ", "")
+}
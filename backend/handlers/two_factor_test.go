@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"chat_app/paseto"
+	"chat_app/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLoginTwoFactor(t *testing.T) {
+	handler := setupTestHandler()
+
+	accessToken, _, err := handler.Paseto.CreateSessionToken(primitive.NewObjectID(), "testuser", uuid.New(),
+		paseto.AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		challengeToken string
+	}{
+		{"Invalid Challenge Token", "invalid-token"},
+		{"Access Token Used As Challenge", accessToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{
+				"challenge_token": tt.challengeToken,
+				"code":            "123456",
+			})
+
+			req := httptest.NewRequest("POST", "/api/login/2fa", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+
+			handler.LoginTwoFactor(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+}
+
+func TestEnrollTwoFactor(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("POST", "/api/user/2fa/enroll", nil)
+	w := httptest.NewRecorder()
+
+	handler.EnrollTwoFactor(w, req)
+
+	// Should return 401 due to missing auth payload
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+
+	if len(codes) != recoveryCodesCount || len(hashedCodes) != recoveryCodesCount {
+		t.Fatalf("Expected %d codes, got %d codes and %d hashes", recoveryCodesCount, len(codes), len(hashedCodes))
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("Duplicate recovery code %s", code)
+		}
+		seen[code] = true
+
+		if !utils.VerifyHash(hashedCodes[i], normalizeRecoveryCode(code)) {
+			t.Errorf("Hash does not match recovery code %s", code)
+		}
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, input := range []string{"abcde-fghij", "ABCDE-FGHIJ", "abcdefghij", "abcde fghij"} {
+		if got := normalizeRecoveryCode(input); got != "abcdefghij" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, expected abcdefghij", input, got)
+		}
+	}
+}
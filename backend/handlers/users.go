@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"chat_app/database/models"
 	"chat_app/utils"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,6 +14,10 @@ import (
 )
 
 func (handler *Handler) CreateUser(username string, rawPassword []byte) *utils.ErrorResponse {
+	if errResp := handler.PasswordPolicy.Validate(username, string(rawPassword)); errResp != nil {
+		return errResp
+	}
+
 	hashedPassword, err := utils.Hash(rawPassword)
 	if err != nil {
 		return &utils.ErrorResponse{
@@ -31,8 +37,7 @@ func (handler *Handler) CreateUser(username string, rawPassword []byte) *utils.E
 }
 
 func (handler *Handler) SearchUser(w http.ResponseWriter, r *http.Request) {
-	if _, errResp := utils.CheckAuth(r, handler.Paseto); errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	if _, ok := authPayload(w, r); !ok {
 		return
 	}
 
@@ -56,8 +61,7 @@ func (handler *Handler) SearchUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
-	if _, errResp := utils.CheckAuth(r, handler.Paseto); errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	if _, ok := authPayload(w, r); !ok {
 		return
 	}
 
@@ -91,10 +95,98 @@ func (handler *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, userInstance)
 }
 
-func (handler *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
+// GetUserPresence -> Status of the user's live connections. Last seen is left out while the user is connected
+// or hides it
+func (handler *Handler) GetUserPresence(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	userObjectId, errResp := utils.ToObjectId(chi.URLParam(r, "user_id"))
+	if errResp != nil {
+		utils.WriteError(w, http.StatusBadRequest, errResp.Type, errResp.Detail)
+		return
+	}
+
+	// only users sharing a chat or group get the presence, the same ones the presence events reach
+	if userObjectId != payload.UserId {
+		shared, err := handler.sharesRoom(payload.UserId, userObjectId)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "getUser", "failed to get user")
+			return
+		}
+
+		if !shared {
+			utils.WriteError(w, http.StatusNotFound, "getUser", "user with this id does not exist")
+			return
+		}
+	}
+
+	projection := bson.M{
+		"last_seen_at":   1,
+		"hide_last_seen": 1,
+	}
+
+	userInstance, err := handler.Models.User.Get(bson.M{"_id": userObjectId}, projection)
 	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, err.Type, err.Detail)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.WriteError(w, http.StatusNotFound, "getUser", "user with this id does not exist")
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, "getUser", "failed to get user")
+		return
+	}
+
+	presence := PresenceData{
+		UserId: userObjectId.Hex(),
+		Status: handler.WebSocket.UserStatus(userObjectId.Hex()),
+	}
+
+	if presence.Status == StatusOffline && !userInstance.HideLastSeen {
+		presence.LastSeenAt = userInstance.LastSeenAt
+	}
+
+	utils.WriteJSON(w, http.StatusOK, presence)
+}
+
+// UpdatePrivacy -> hide_last_seen keeps last seen out of the user's presence
+func (handler *Handler) UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		HideLastSeen *bool `json:"hide_last_seen"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1_000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "parseJson", err.Error())
+		return
+	}
+
+	if input.HideLastSeen == nil {
+		utils.WriteError(w, http.StatusBadRequest, "missingField", "hide_last_seen is missing")
+		return
+	}
+
+	updates := bson.M{
+		"hide_last_seen": *input.HideLastSeen,
+	}
+
+	if _, err := handler.Models.User.Update(bson.M{"_id": payload.UserId}, updates); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "updateUser", "failed to update privacy settings")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "privacy settings updated successfully")
+}
+
+func (handler *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -127,9 +219,8 @@ func (handler *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	payload, err := utils.CheckAuth(r, handler.Paseto)
-	if err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, err.Type, err.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -156,14 +247,34 @@ func (handler *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := handler.Models.Session.RevokeAllExcept(payload.UserId, ""); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "revokeSessions", "failed to revoke user sessions")
+		return
+	}
+
+	apiKeysFilter := bson.M{
+		"user_id":    payload.UserId,
+		"revoked_at": nil,
+	}
+
+	if _, err := handler.Models.APIKey.UpdateAll(apiKeysFilter, bson.M{"revoked_at": time.Now()}); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "revokeApiKeys", "failed to revoke user api keys")
+		return
+	}
+
+	handler.destroyDataKey(models.UserKeyOwner(payload.UserId))
+
+	handler.disconnectUser(payload.UserId)
+
+	clearAuthCookies(w)
+
 	utils.WriteJSON(w, http.StatusOK, "user deleted successfully")
 }
 
 // GetUserChats -> Returns the chats themselves
 func (handler *Handler) GetUserChats(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -181,7 +292,10 @@ func (handler *Handler) GetUserChats(w http.ResponseWriter, r *http.Request) {
 
 	avatarUrls := make(map[string]string)
 	usernames := make(map[string]string)
+	rooms := make([]Room, 0, len(chats))
 	for _, chat := range chats {
+		rooms = append(rooms, ChatRoom(&chat, payload.UserId))
+
 		otherUserId := getOtherUserId(chat.Participants, payload.UserId)
 		url, _ := getUserAvatarUrl(otherUserId, handler)
 		username, _ := getUserUsername(otherUserId, handler)
@@ -191,9 +305,11 @@ func (handler *Handler) GetUserChats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := map[string]any{
-		"chats":       chats,
-		"avatar_urls": avatarUrls,
-		"usernames":   usernames,
+		"chats":         chats,
+		"avatar_urls":   avatarUrls,
+		"usernames":     usernames,
+		"unread_counts": handler.unreadCounts(payload.UserId, rooms),
+		"read_seqs":     handler.partnerReadSeqs(payload.UserId, rooms),
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
@@ -201,9 +317,8 @@ func (handler *Handler) GetUserChats(w http.ResponseWriter, r *http.Request) {
 
 // GetUserSecretChats -> Returns the secret chats themselves
 func (handler *Handler) GetUserSecretChats(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -221,7 +336,10 @@ func (handler *Handler) GetUserSecretChats(w http.ResponseWriter, r *http.Reques
 	}
 
 	usernames := make(map[string]string)
+	rooms := make([]Room, 0, len(chats))
 	for _, chat := range chats {
+		rooms = append(rooms, SecretChatRoom(&chat, payload.UserId))
+
 		otherUserId := getOtherUserId([]primitive.ObjectID{chat.User1, chat.User2}, payload.UserId)
 		username, _ := getUserUsername(otherUserId, handler)
 
@@ -229,8 +347,10 @@ func (handler *Handler) GetUserSecretChats(w http.ResponseWriter, r *http.Reques
 	}
 
 	response := map[string]any{
-		"secret_chats":     chats,
-		"secret_usernames": usernames,
+		"secret_chats":         chats,
+		"secret_usernames":     usernames,
+		"secret_unread_counts": handler.unreadCounts(payload.UserId, rooms),
+		"secret_read_seqs":     handler.partnerReadSeqs(payload.UserId, rooms),
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
@@ -238,9 +358,8 @@ func (handler *Handler) GetUserSecretChats(w http.ResponseWriter, r *http.Reques
 
 // GetUserGroups -> Returns the chats themselves
 func (handler *Handler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
-	payload, errResp := utils.CheckAuth(r, handler.Paseto)
-	if errResp != nil {
-		utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+	payload, ok := authPayload(w, r)
+	if !ok {
 		return
 	}
 
@@ -265,8 +384,14 @@ func (handler *Handler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rooms := make([]Room, 0, len(groups))
+	for _, group := range groups {
+		rooms = append(rooms, GroupRoom(&group))
+	}
+
 	response := map[string]any{
-		"groups": groups,
+		"groups":        groups,
+		"unread_counts": handler.unreadCounts(payload.UserId, rooms),
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
@@ -240,3 +240,54 @@ func BenchmarkGetUserGroups(b *testing.B) {
 		handler.GetUserGroups(w, req)
 	}
 }
+
+func TestGetUserPresenceRequiresSharedRoom(t *testing.T) {
+	handler := setupDatabaseHandler(t)
+
+	userId, err := handler.Models.User.Create("presence_user", "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	contactId, err := handler.Models.User.Create("presence_contact", "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	strangerId, err := handler.Models.User.Create("presence_stranger", "hash")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if _, err := handler.Models.Chat.Create([]primitive.ObjectID{userId, contactId}); err != nil {
+		t.Fatalf("Failed to create chat: %v", err)
+	}
+
+	getPresence := func(targetId primitive.ObjectID) int {
+		req := authRequest("GET", "/api/user/presence/"+targetId.Hex(), nil, userId,
+			map[string]string{"user_id": targetId.Hex()})
+		w := httptest.NewRecorder()
+
+		handler.GetUserPresence(w, req)
+
+		return w.Code
+	}
+
+	t.Run("Chat Participant", func(t *testing.T) {
+		if code := getPresence(contactId); code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("Own Presence", func(t *testing.T) {
+		if code := getPresence(userId); code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("No Shared Chat Or Group", func(t *testing.T) {
+		if code := getPresence(strangerId); code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, code)
+		}
+	})
+}
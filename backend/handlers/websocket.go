@@ -1,7 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
+	"chat_app/broker"
+	"chat_app/utils"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -11,20 +13,79 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// WebSocketManager -> Rooms connections
+// maxDevicesPerUser -> Live connections a user can hold at once, e.g. a phone, a laptop and a few tabs
+const maxDevicesPerUser = 10
+
+const (
+	// writeWait -> Time allowed to write one frame to the client
+	writeWait = 10 * time.Second
+	// pongWait -> A client that answers no ping for this long is disconnected
+	pongWait = 60 * time.Second
+	// pingPeriod -> Must be shorter than pongWait
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize -> Largest frame read from a client, bigger ones close the connection
+	maxMessageSize = 64 << 10
+	// sendQueueSize -> Frames queued for one connection. A client this far behind is disconnected
+	sendQueueSize = 256
+)
+
+var (
+	errRoomEmpty       = errors.New("no connections in the room")
+	errSendQueueFull   = errors.New("send queue of the connection is full")
+	errConnClosed      = errors.New("connection is closed")
+	errTooManyDevices  = errors.New("too many devices connected")
+	errInvalidDeviceId = errors.New("device id must be 1-64 letters, digits, '-' or '_'")
+)
+
+// Close codes of the application, see RFC 6455 section 7.4.2
+const (
+	// closeTokenExpired -> The access token of the handshake expired, clients refresh it and reconnect
+	closeTokenExpired = 4001
+	// closeSessionEnded -> The session was revoked, e.g. logged out or the password changed. No reconnect
+	closeSessionEnded = 4003
+)
+
+// WebSocketManager -> Live connections of this instance and the rooms they are subscribed to
 type WebSocketManager struct {
-	ChatConnections  map[string]map[string]*websocket.Conn // chatId -> userId -> ws Conn
-	GroupConnections map[string]map[string]*websocket.Conn // groupId -> userId -> ws Conn
-	UserConnections  map[string]*websocket.Conn            // userId -> ws Conn (ensures 1 connection per user)
-	ConnMutex        sync.RWMutex
+	Rooms       map[string]map[string]*WsConnection // roomId -> connection id -> connection
+	Connections map[string]map[string]*WsConnection // userId -> deviceId -> connection
+	ConnMutex   sync.RWMutex
+
+	broker     broker.Broker
+	stopBroker func()
 }
 
-// WsConnection -> Websocket connection itself for users
+// WsConnection -> Websocket connection of one device of a user, subscribed to all of the user's chats and groups
 type WsConnection struct {
-	Conn *websocket.Conn
+	Conn        *websocket.Conn
+	Id          string // userId/deviceId, unique among the live connections
+	UserId      string
+	DeviceId    string
+	Username    string
+	ConnectedAt time.Time
+	// SessionId and ExpireAt come from the access token of the handshake, set before the connection is registered
+	SessionId string
+	ExpireAt  time.Time
+
+	// rooms the connection is subscribed to and its presence status, guarded by the manager's ConnMutex
+	rooms  map[string]Room
+	status string
+
+	// last typing.start forwarded per room, only used by the read loop
+	typingAt map[string]time.Time
+
+	// gorilla/websocket allows one concurrent writer only, so frames are queued and written by writePump
+	send      chan []byte
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+
+	pingPeriod time.Duration
+	pongWait   time.Duration
 }
 
 var upgrader = websocket.Upgrader{
@@ -39,339 +100,261 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// WebsocketInit -> Constructor
+// WebsocketInit -> Constructor of a single instance manager, see NewWebSocketManager for several instances
 func WebsocketInit() *WebSocketManager {
-	return &WebSocketManager{
-		ChatConnections:  make(map[string]map[string]*websocket.Conn),
-		GroupConnections: make(map[string]map[string]*websocket.Conn),
-		UserConnections:  make(map[string]*websocket.Conn),
-		ConnMutex:        sync.RWMutex{},
-	}
-}
+	memory := broker.NewMemory()
+	ws := newWebSocketManager(memory)
 
-func WebsocketUpgrade(w http.ResponseWriter, r *http.Request) (*WsConnection, error) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return nil, err
-	}
+	// the memory broker never fails to subscribe
+	ws.stopBroker, _ = memory.Subscribe(ws.handleBrokerEvent)
 
-	return &WsConnection{
-		Conn: conn,
-	}, nil
+	return ws
 }
 
-// Close -> Close the connection
-func (wsConn *WsConnection) Close() {
-	if err := wsConn.Conn.Close(); err != nil {
-		slog.Error("closing ws conn", "error", err)
+func newWebSocketManager(eventBroker broker.Broker) *WebSocketManager {
+	return &WebSocketManager{
+		Rooms:       make(map[string]map[string]*WsConnection),
+		Connections: make(map[string]map[string]*WsConnection),
+		ConnMutex:   sync.RWMutex{},
+		broker:      eventBroker,
 	}
 }
 
-// Delete -> Delete user`s connection from either chatId or groupId. Also deletes the room if it`s empty
-func (ws *WebSocketManager) Delete(roomId, userId string) {
-	ws.ConnMutex.Lock()
-	defer ws.ConnMutex.Unlock()
-
-	// Clean up user connection tracker
-	if conn, exists := ws.UserConnections[userId]; exists {
-		slog.Info("user disconnected", "user_id", userId, "room_id", roomId)
-		conn.Close()
-		delete(ws.UserConnections, userId)
+func WebsocketUpgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return upgrader.Upgrade(w, r, nil)
+}
 
-		// Remove user from all existing rooms (chat or group)
-		ws.removeUserFromAllRooms(userId)
+// NewWsConnection -> The connection isn't subscribed to anything and writes nothing until it is registered
+func NewWsConnection(conn *websocket.Conn, userId, deviceId, username string) *WsConnection {
+	return &WsConnection{
+		Conn:        conn,
+		Id:          userId + "/" + deviceId,
+		UserId:      userId,
+		DeviceId:    deviceId,
+		Username:    username,
+		ConnectedAt: time.Now(),
+		rooms:       make(map[string]Room),
+		status:      StatusOnline,
+		typingAt:    make(map[string]time.Time),
+		send:        make(chan []byte, sendQueueSize),
+		done:        make(chan struct{}),
+		pingPeriod:  pingPeriod,
+		pongWait:    pongWait,
 	}
+}
 
-	// Try to delete from chat connections first
-	if connections, ok := ws.ChatConnections[roomId]; ok {
-		delete(connections, userId)
-		if len(connections) == 0 {
-			delete(ws.ChatConnections, roomId)
-			slog.Info("empty chat room deleted", "room_id", roomId)
-		}
-		return
+// Send -> Queues a text frame, never blocks. A connection whose queue is full can't keep up and is closed,
+// so one slow client doesn't hold up a broadcast. No close frame is written: writePump holds the write lock
+// while it waits on the same client
+func (wsConn *WsConnection) Send(payload []byte) error {
+	select {
+	case <-wsConn.done:
+		return errConnClosed
+	default:
 	}
 
-	// If not found in chat connections, try group connections
-	if connections, ok := ws.GroupConnections[roomId]; ok {
-		delete(connections, userId)
-		if len(connections) == 0 {
-			delete(ws.GroupConnections, roomId)
-			slog.Info("empty group room deleted", "room_id", roomId)
-		}
-		return
+	select {
+	case wsConn.send <- payload:
+		return nil
+	default:
+		slog.Warn("closing slow ws conn", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+		wsConn.Close()
+		return errSendQueueFull
 	}
 }
 
-// ChatMessage -> Both Regular and Secret Chats
-type ChatMessage struct {
-	SenderId       string `json:"sender_id"`
-	ReceiverId     string `json:"receiver_id"`
-	Content        string `json:"content"`         // content is only for text messages
-	ContentAddress string `json:"content_address"` // content address is only for images
-	ContentType    string `json:"content_type"`    // either an image or text
+// start -> Runs writePump once, frames queued before are written then
+func (wsConn *WsConnection) start() {
+	wsConn.startOnce.Do(func() {
+		go wsConn.writePump()
+	})
 }
 
-// AddChat -> Adds the user's websocket connection to the chat room
-// Ensures user can only be in one room at a time (either chat or group)
-// Maximum 2 users per chat (including the new user)
-func (wsConn *WsConnection) AddChat(chatId, userId string, ws *WebSocketManager) {
-	ws.ConnMutex.Lock()
-	defer ws.ConnMutex.Unlock()
+// writePump -> The only writer of data frames. Pings the client every pingPeriod, and closes the connection
+// once its access token expires
+func (wsConn *WsConnection) writePump() {
+	ticker := time.NewTicker(wsConn.pingPeriod)
 
-	// Check if user already has a connection and close it (from any room)
-	if existingConn, exists := ws.UserConnections[userId]; exists {
-		slog.Info("closing existing connection for user", "user_id", userId)
-		existingConn.Close()
-
-		// Remove user from all existing rooms (chat or group)
-		ws.removeUserFromAllRooms(userId)
+	var expired <-chan time.Time
+	if !wsConn.ExpireAt.IsZero() {
+		expiry := time.NewTimer(time.Until(wsConn.ExpireAt))
+		defer expiry.Stop()
+		expired = expiry.C
 	}
 
-	// Check chat connection limit (max 2 users)
-	connections, ok := ws.ChatConnections[chatId]
-	if !ok {
-		connections = make(map[string]*websocket.Conn)
-		ws.ChatConnections[chatId] = connections
-	} else if len(connections) >= 2 {
-		slog.Warn("chat connection limit reached", "chat_id", chatId, "current_users", len(connections))
-		return
-	}
-
-	// Add new connection
-	ws.UserConnections[userId] = wsConn.Conn
-	connections[userId] = wsConn.Conn
-
-	slog.Info("user connected to chat", "user_id", userId, "chat_id", chatId, "total_users", len(connections))
-}
-
-// HandleChatIncomingMsgs -> for both regular chat and secret chat
-func (wsConn *WsConnection) HandleChatIncomingMsgs(chatId, senderId, receiverId string, isSecret bool,
-	wsInstance *WebSocketManager, handler *Handler) error {
-
 	defer func() {
-		wsInstance.Delete(chatId, senderId)
-		if err := wsConn.Conn.Close(); err != nil {
-			slog.Error("closing ws conn", "error", err)
-		}
-		slog.Info("chat websocket handler ended", "chat_id", chatId, "user_id", senderId)
+		ticker.Stop()
+		wsConn.Close()
 	}()
 
-	slog.Info("chat websocket handler started", "chat_id", chatId, "user_id", senderId, "is_secret", isSecret)
-
 	for {
-		_, payload, err := wsConn.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				slog.Error("unexpected websocket close", "error", err, "chat_id", chatId, "user_id", senderId)
+		select {
+		case payload := <-wsConn.send:
+			wsConn.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := wsConn.Conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				slog.Warn("writing ws frame", "error", err, "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+				return
 			}
-			return fmt.Errorf("failed to ws read message: %w", err)
-		}
-
-		// Wrap the payload with sender info
-		var input ChatMessage
-
-		if err := json.Unmarshal(payload, &input); err != nil {
-			slog.Error("failed to unmarshal chat message", "error", err, "chat_id", chatId, "user_id", senderId)
-			return fmt.Errorf("failed to UnMarshal ws message: %w", err)
-		}
-
-		// Get connections with read lock for better performance
-		chatConnections := wsInstance.GetChatConnectionsReadOnly(chatId)
-		// to prevent panics...
-		if chatConnections == nil {
-			slog.Warn("no connections found for chat", "chat_id", chatId)
-			continue
-		}
-
-		// Store message to DB in background
-		go func() {
-			if err := handler.storeChatMsgToDB(chatId, senderId, receiverId, input.ContentType,
-				input.ContentAddress, input.Content, isSecret); err != nil {
-				slog.Error("failed to store chat message to DB", "error", err, "chat_id", chatId, "sender_id", senderId)
+		case <-ticker.C:
+			deadline := time.Now().Add(writeWait)
+			if err := wsConn.Conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				slog.Warn("pinging ws conn", "error", err, "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+				return
 			}
-		}()
-
-		// Use the optimized broadcast method
-		if err := wsInstance.BroadcastToRoom(chatId, senderId, websocket.TextMessage, payload); err != nil {
-			slog.Error("failed to broadcast chat message", "error", err, "chat_id", chatId, "sender_id", senderId)
-			return fmt.Errorf("failed to broadcast message: %w", err)
+		case <-expired:
+			slog.Info("closing ws conn of expired token", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+			wsConn.CloseWith(closeTokenExpired, "access token expired")
+			return
+		case <-wsConn.done:
+			return
 		}
 	}
 }
 
-// GroupMessage -> Both Regular and Secret Groups
-type GroupMessage struct {
-	SenderId       string `json:"sender_id"`
-	Content        string `json:"content"`
-	ContentAddress string `json:"content_address"`
-	ContentType    string `json:"content_type"` // either an image or text
+// CloseWith -> Sends a close frame with the code and reason, then closes the connection
+func (wsConn *WsConnection) CloseWith(code int, reason string) {
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	// control frames may be written next to writePump
+	_ = wsConn.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait))
+
+	wsConn.Close()
 }
 
-// HandleGroupIncomingMsgs -> Handles both Regular and Secret groups
-func (wsConn *WsConnection) HandleGroupIncomingMsgs(groupId, senderId string, isSecret bool, wsInstance *WebSocketManager,
-	handler *Handler) error {
+// Close -> Close the connection and stop its writePump. Safe to call more than once
+func (wsConn *WsConnection) Close() {
+	wsConn.closeOnce.Do(func() {
+		close(wsConn.done)
 
-	defer func() {
-		wsInstance.Delete(groupId, senderId)
 		if err := wsConn.Conn.Close(); err != nil {
 			slog.Error("closing ws conn", "error", err)
 		}
-		slog.Info("group websocket handler ended", "group_id", groupId, "user_id", senderId)
-	}()
-
-	slog.Info("group websocket handler started", "group_id", groupId, "user_id", senderId, "is_secret", isSecret)
+	})
+}
 
-	for {
-		_, payload, err := wsConn.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				slog.Error("unexpected websocket close", "error", err, "group_id", groupId, "user_id", senderId)
-			}
-			return fmt.Errorf("failed to read message: %w", err)
-		}
+// Register -> Subscribes the connection to its rooms. An older connection of the same device is closed
+func (ws *WebSocketManager) Register(wsConn *WsConnection, rooms []Room) error {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
 
-		var input GroupMessage
+	devices, exists := ws.Connections[wsConn.UserId]
+	if !exists {
+		devices = make(map[string]*WsConnection)
+		ws.Connections[wsConn.UserId] = devices
+	}
 
-		if err := json.Unmarshal(payload, &input); err != nil {
-			slog.Error("failed to unmarshal group message", "error", err, "group_id", groupId, "user_id", senderId)
-			return fmt.Errorf("failed to UnMarshal message: %w", err)
-		}
+	if existingConn, exists := devices[wsConn.DeviceId]; exists {
+		slog.Info("closing existing connection for device", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+		existingConn.Close()
+		ws.unsubscribeAll(existingConn)
+	} else if len(devices) >= maxDevicesPerUser {
+		return errTooManyDevices
+	}
 
-		// Store message to DB in background
-		go func() {
-			if err := handler.storeGroupMsgToDB(groupId, senderId, input.ContentType, input.ContentAddress,
-				input.Content, isSecret); err != nil {
-				slog.Error("failed to store group message to DB", "error", err, "group_id", groupId, "sender_id", senderId)
-			}
-		}()
+	devices[wsConn.DeviceId] = wsConn
 
-		// Use the optimized broadcast method
-		if err := wsInstance.BroadcastToRoom(groupId, senderId, websocket.TextMessage, payload); err != nil {
-			slog.Error("failed to broadcast group message", "error", err, "group_id", groupId, "sender_id", senderId)
-			return fmt.Errorf("failed to broadcast message: %w", err)
-		}
+	for _, room := range rooms {
+		ws.subscribe(wsConn, room)
 	}
-}
 
-// AddGroup -> Adds the user's websocket connection to the group room
-// Ensures user can only be in one room at a time (either chat or group)
-// Maximum 100 users per group (including the new user)
-func (wsConn *WsConnection) AddGroup(groupId, userId string, ws *WebSocketManager) {
-	ws.ConnMutex.Lock()
-	defer ws.ConnMutex.Unlock()
+	wsConn.start()
 
-	// Check if user already has a connection and close it (from any room)
-	if existingConn, exists := ws.UserConnections[userId]; exists {
-		slog.Info("closing existing connection for user", "user_id", userId)
-		existingConn.Close()
+	slog.Info("device connected", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId, "rooms", len(rooms))
 
-		// Remove user from all existing rooms (chat or group)
-		ws.removeUserFromAllRooms(userId)
-	}
+	return nil
+}
 
-	// Check group connection limit (max 100 users)
-	conns, ok := ws.GroupConnections[groupId]
-	if !ok {
-		conns = make(map[string]*websocket.Conn)
-		ws.GroupConnections[groupId] = conns
-	} else if len(conns) >= 100 {
-		slog.Warn("group connection limit reached", "group_id", groupId, "current_users", len(conns))
-		return
-	}
+// Unregister -> Removes the connection from all its rooms. A newer connection of the device is kept
+func (ws *WebSocketManager) Unregister(wsConn *WsConnection) {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
 
-	// Add new connection
-	ws.UserConnections[userId] = wsConn.Conn
-	conns[userId] = wsConn.Conn
+	ws.removeConnection(wsConn)
 
-	slog.Info("user connected to group", "user_id", userId, "group_id", groupId, "total_users", len(conns))
+	slog.Info("device disconnected", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
 }
 
-// GetChatConnections -> Safely get chat connections with mutex protection
-func (ws *WebSocketManager) GetChatConnections(chatId string) map[string]*websocket.Conn {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
+// JoinRoom -> Subscribes every device of the user to a room, e.g. after a chat was created
+func (ws *WebSocketManager) JoinRoom(userId string, room Room) {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
 
-	if connections, ok := ws.ChatConnections[chatId]; ok {
-		// Return a copy to avoid race conditions
-		result := make(map[string]*websocket.Conn)
-		for k, v := range connections {
-			result[k] = v
-		}
-		return result
+	for _, wsConn := range ws.Connections[userId] {
+		ws.subscribe(wsConn, room)
 	}
-	return nil
 }
 
-// GetGroupConnections -> Safely get group connections with mutex protection
-func (ws *WebSocketManager) GetGroupConnections(groupId string) map[string]*websocket.Conn {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
+// LeaveRoom -> Unsubscribes every device of the user from a room. Returns the devices that were subscribed
+func (ws *WebSocketManager) LeaveRoom(userId, roomId string) []*WsConnection {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
 
-	if connections, ok := ws.GroupConnections[groupId]; ok {
-		// Return a copy to avoid race conditions
-		result := make(map[string]*websocket.Conn)
-		for k, v := range connections {
-			result[k] = v
+	var left []*WsConnection
+	for _, wsConn := range ws.Connections[userId] {
+		if _, exists := wsConn.rooms[roomId]; exists {
+			left = append(left, wsConn)
 		}
-		return result
+
+		ws.unsubscribe(wsConn, roomId)
 	}
-	return nil
+
+	return left
 }
 
-// GetChatConnectionsReadOnly -> Get chat connections with read lock (for broadcasting)
-func (ws *WebSocketManager) GetChatConnectionsReadOnly(chatId string) map[string]*websocket.Conn {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
+// CloseRoom -> Unsubscribes every connection from a room, e.g. after the chat was deleted.
+// Returns the connections that were subscribed
+func (ws *WebSocketManager) CloseRoom(roomId string) []*WsConnection {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
 
-	if connections, ok := ws.ChatConnections[chatId]; ok {
-		// Return a copy to avoid race conditions during iteration
-		result := make(map[string]*websocket.Conn, len(connections))
-		for k, v := range connections {
-			result[k] = v
-		}
-		return result
+	connections := ws.Rooms[roomId]
+
+	closed := make([]*WsConnection, 0, len(connections))
+	for _, wsConn := range connections {
+		closed = append(closed, wsConn)
+		ws.unsubscribe(wsConn, roomId)
 	}
-	return nil
+
+	return closed
 }
 
-// GetGroupConnectionsReadOnly -> Get group connections with read lock (for broadcasting)
-func (ws *WebSocketManager) GetGroupConnectionsReadOnly(groupId string) map[string]*websocket.Conn {
+// SubscribedRoom -> The room, if the connection is subscribed to it. Frames for other rooms are refused
+func (ws *WebSocketManager) SubscribedRoom(wsConn *WsConnection, roomId string) (Room, bool) {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
-	if connections, ok := ws.GroupConnections[groupId]; ok {
-		// Return a copy to avoid race conditions during iteration
-		result := make(map[string]*websocket.Conn, len(connections))
-		for k, v := range connections {
-			result[k] = v
-		}
-		return result
-	}
-	return nil
+	room, exists := wsConn.rooms[roomId]
+	return room, exists
 }
 
-// IsUserConnectedToChat -> Check if user is already connected to a chat
-func (ws *WebSocketManager) IsUserConnectedToChat(chatId, userId string) bool {
+// GetRoomConnections -> Copy of the room's connections by connection id, safe to iterate without the lock
+func (ws *WebSocketManager) GetRoomConnections(roomId string) map[string]*WsConnection {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
-	if connections, ok := ws.ChatConnections[chatId]; ok {
-		_, exists := connections[userId]
-		return exists
+	connections, ok := ws.Rooms[roomId]
+	if !ok {
+		return nil
+	}
+
+	result := make(map[string]*WsConnection, len(connections))
+	for connId, wsConn := range connections {
+		result[connId] = wsConn
 	}
-	return false
+
+	return result
 }
 
-// IsUserConnectedToGroup -> Check if user is already connected to a group
-func (ws *WebSocketManager) IsUserConnectedToGroup(groupId, userId string) bool {
+// IsUserInRoom -> Check if any device of the user is subscribed to a room
+func (ws *WebSocketManager) IsUserInRoom(roomId, userId string) bool {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
-	if connections, ok := ws.GroupConnections[groupId]; ok {
-		_, exists := connections[userId]
-		return exists
+	for _, wsConn := range ws.Connections[userId] {
+		if _, exists := wsConn.rooms[roomId]; exists {
+			return true
+		}
 	}
+
 	return false
 }
 
@@ -380,243 +363,329 @@ func (ws *WebSocketManager) IsUserConnected(userId string) bool {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
-	_, exists := ws.UserConnections[userId]
-	return exists
+	return len(ws.Connections[userId]) > 0
 }
 
-// GetUserConnection -> Get user's current connection (if any)
-func (ws *WebSocketManager) GetUserConnection(userId string) (*websocket.Conn, bool) {
+// GetUserDevices -> Device ids of the user's live connections
+func (ws *WebSocketManager) GetUserDevices(userId string) []string {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
-	conn, exists := ws.UserConnections[userId]
-	return conn, exists
+	devices := make([]string, 0, len(ws.Connections[userId]))
+	for deviceId := range ws.Connections[userId] {
+		devices = append(devices, deviceId)
+	}
+
+	slices.Sort(devices)
+
+	return devices
 }
 
-// ForceDisconnectUser -> Force disconnect a user from all connections
+// ForceDisconnectUser -> Force disconnect every device of a user
 func (ws *WebSocketManager) ForceDisconnectUser(userId string) bool {
 	ws.ConnMutex.Lock()
 	defer ws.ConnMutex.Unlock()
 
-	if conn, exists := ws.UserConnections[userId]; exists {
-		slog.Info("force disconnecting user", "user_id", userId)
-		conn.Close()
-		delete(ws.UserConnections, userId)
-
-		// Remove from all rooms
-		for roomId, connections := range ws.ChatConnections {
-			if _, userExists := connections[userId]; userExists {
-				delete(connections, userId)
-				if len(connections) == 0 {
-					delete(ws.ChatConnections, roomId)
-				}
-			}
-		}
-		for roomId, connections := range ws.GroupConnections {
-			if _, userExists := connections[userId]; userExists {
-				delete(connections, userId)
-				if len(connections) == 0 {
-					delete(ws.GroupConnections, roomId)
-				}
-			}
+	devices, exists := ws.Connections[userId]
+	if !exists {
+		return false
+	}
+
+	slog.Info("force disconnecting user", "user_id", userId, "devices", len(devices))
+	for _, wsConn := range devices {
+		wsConn.Close()
+		ws.removeConnection(wsConn)
+	}
+
+	return true
+}
+
+// ForceDisconnectDevice -> Force disconnect one device of a user
+func (ws *WebSocketManager) ForceDisconnectDevice(userId, deviceId string) bool {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
+
+	wsConn, exists := ws.Connections[userId][deviceId]
+	if !exists {
+		return false
+	}
+
+	slog.Info("force disconnecting device", "user_id", userId, "device_id", deviceId)
+	wsConn.Close()
+	ws.removeConnection(wsConn)
+
+	return true
+}
+
+// DisconnectSessions -> Force disconnect the devices of a user whose session matches. Returns how many were closed
+func (ws *WebSocketManager) DisconnectSessions(userId string, matches func(sessionId string) bool) int {
+	ws.ConnMutex.Lock()
+
+	var ended []*WsConnection
+	for _, wsConn := range ws.Connections[userId] {
+		if matches(wsConn.SessionId) {
+			ended = append(ended, wsConn)
+			ws.removeConnection(wsConn)
 		}
-		return true
 	}
-	return false
+
+	ws.ConnMutex.Unlock()
+
+	// the close frames are written without holding the lock
+	for _, wsConn := range ended {
+		slog.Info("disconnecting ended session", "user_id", userId, "device_id", wsConn.DeviceId)
+		wsConn.CloseWith(closeSessionEnded, "session ended")
+	}
+
+	return len(ended)
 }
 
-// GetConnectionStats -> Get statistics about current connections
+// GetConnectionStats -> Get statistics about current connections, counted per device
 func (ws *WebSocketManager) GetConnectionStats() map[string]any {
 	ws.ConnMutex.RLock()
 	defer ws.ConnMutex.RUnlock()
 
 	stats := make(map[string]any)
 
-	// Count total users connected
-	stats["total_users"] = len(ws.UserConnections)
+	devices := make([]map[string]any, 0)
+	for userId, userDevices := range ws.Connections {
+		for deviceId, wsConn := range userDevices {
+			devices = append(devices, map[string]any{
+				"user_id":      userId,
+				"device_id":    deviceId,
+				"rooms":        len(wsConn.rooms),
+				"connected_at": wsConn.ConnectedAt,
+			})
+		}
+	}
+
+	stats["total_users"] = len(ws.Connections)
+	stats["total_devices"] = len(devices)
+	stats["total_rooms"] = len(ws.Rooms)
+	stats["devices"] = devices
 
-	// Count chat rooms
-	totalChatConnections := 0
-	for _, connections := range ws.ChatConnections {
-		totalChatConnections += len(connections)
+	// rooms with at least one live subscriber, by kind
+	roomsByKind := map[string]int{
+		RoomChat:       0,
+		RoomSecretChat: 0,
+		RoomGroup:      0,
 	}
-	stats["chat_rooms"] = len(ws.ChatConnections)
-	stats["chat_connections"] = totalChatConnections
 
-	// Count group rooms
-	totalGroupConnections := 0
-	for _, connections := range ws.GroupConnections {
-		totalGroupConnections += len(connections)
+	// one subscription per device and room
+	subscriptions := 0
+	for roomId, connections := range ws.Rooms {
+		kind, _, _ := strings.Cut(roomId, ":")
+		roomsByKind[kind]++
+		subscriptions += len(connections)
 	}
-	stats["group_rooms"] = len(ws.GroupConnections)
-	stats["group_connections"] = totalGroupConnections
 
-	// Add limits info
-	stats["chat_limit"] = 2
-	stats["group_limit"] = 100
+	stats["chat_rooms"] = roomsByKind[RoomChat]
+	stats["secret_chat_rooms"] = roomsByKind[RoomSecretChat]
+	stats["group_rooms"] = roomsByKind[RoomGroup]
+	stats["subscriptions"] = subscriptions
 
 	return stats
 }
 
-// CheckRoomLimits -> Check if any rooms are approaching their limits
-func (ws *WebSocketManager) CheckRoomLimits() map[string]any {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
+// BroadcastToRoom -> Queues a text frame for every device of this instance in a room except the sending
+// connection, so the sender's other devices see their own message as well. Handlers publish a broker event
+// instead, which reaches every instance
+func (ws *WebSocketManager) BroadcastToRoom(roomId, senderConnId string, payload []byte) error {
+	connections := ws.GetRoomConnections(roomId)
+	if connections == nil {
+		return fmt.Errorf("room %s: %w", roomId, errRoomEmpty)
+	}
 
-	warnings := make(map[string]any)
-
-	// Check chat rooms approaching limit
-	for chatId, connections := range ws.ChatConnections {
-		if len(connections) >= 2 {
-			warnings[fmt.Sprintf("chat_%s", chatId)] = map[string]any{
-				"type":    "chat",
-				"room_id": chatId,
-				"current": len(connections),
-				"limit":   2,
-				"status":  "at_limit",
-			}
+	var errors []string
+	successCount := 0
+
+	for connId, wsConn := range connections {
+		if connId == senderConnId {
+			continue
 		}
-	}
 
-	// Check group rooms approaching limit
-	for groupId, connections := range ws.GroupConnections {
-		if len(connections) >= 100 {
-			warnings[fmt.Sprintf("group_%s", groupId)] = map[string]any{
-				"type":    "group",
-				"room_id": groupId,
-				"current": len(connections),
-				"limit":   100,
-				"status":  "at_limit",
-			}
+		if err := wsConn.Send(payload); err != nil {
+			errors = append(errors, fmt.Sprintf("failed to send message to %s: %v", connId, err))
+		} else {
+			successCount++
 		}
 	}
 
-	return warnings
-}
+	slog.Debug("broadcast completed", "room_id", roomId, "success_count", successCount, "error_count", len(errors))
 
-// RoomExists -> Quick check if a room exists (chat or group)
-func (ws *WebSocketManager) RoomExists(roomId string) (bool, string) {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
-
-	if _, exists := ws.ChatConnections[roomId]; exists {
-		return true, "chat"
+	if len(errors) > 0 {
+		return fmt.Errorf("broadcast errors: %s", strings.Join(errors, "; "))
 	}
-	if _, exists := ws.GroupConnections[roomId]; exists {
-		return true, "group"
+
+	return nil
+}
+
+func (ws *WebSocketManager) subscribe(wsConn *WsConnection, room Room) {
+	connections, ok := ws.Rooms[room.Id]
+	if !ok {
+		connections = make(map[string]*WsConnection)
+		ws.Rooms[room.Id] = connections
 	}
-	return false, ""
+
+	connections[wsConn.Id] = wsConn
+	wsConn.rooms[room.Id] = room
 }
 
-// GetRoomType -> Get the type of room (chat or group)
-func (ws *WebSocketManager) GetRoomType(roomId string) string {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
+func (ws *WebSocketManager) unsubscribe(wsConn *WsConnection, roomId string) {
+	delete(wsConn.rooms, roomId)
 
-	if _, exists := ws.ChatConnections[roomId]; exists {
-		return "chat"
+	connections, ok := ws.Rooms[roomId]
+	if !ok || connections[wsConn.Id] != wsConn {
+		return
 	}
-	if _, exists := ws.GroupConnections[roomId]; exists {
-		return "group"
+
+	delete(connections, wsConn.Id)
+	if len(connections) == 0 {
+		delete(ws.Rooms, roomId)
 	}
-	return ""
 }
 
-// GetUserRoomInfo -> Get information about which room a user is connected to
-func (ws *WebSocketManager) GetUserRoomInfo(userId string) (string, string) {
-	ws.ConnMutex.RLock()
-	defer ws.ConnMutex.RUnlock()
-
-	// Check chat rooms
-	for chatId, connections := range ws.ChatConnections {
-		if _, exists := connections[userId]; exists {
-			return chatId, "chat"
-		}
+func (ws *WebSocketManager) unsubscribeAll(wsConn *WsConnection) {
+	for roomId := range wsConn.rooms {
+		ws.unsubscribe(wsConn, roomId)
 	}
+}
 
-	// Check group rooms
-	for groupId, connections := range ws.GroupConnections {
-		if _, exists := connections[userId]; exists {
-			return groupId, "group"
+// removeConnection -> Drops the connection and its subscriptions, unless the device has reconnected since
+func (ws *WebSocketManager) removeConnection(wsConn *WsConnection) {
+	if devices := ws.Connections[wsConn.UserId]; devices[wsConn.DeviceId] == wsConn {
+		delete(devices, wsConn.DeviceId)
+		if len(devices) == 0 {
+			delete(ws.Connections, wsConn.UserId)
 		}
 	}
 
-	return "", ""
+	ws.unsubscribeAll(wsConn)
 }
 
-// BroadcastToRoom -> Broadcast message to all users in a room except sender
-func (ws *WebSocketManager) BroadcastToRoom(roomId, senderId string, messageType int, payload []byte) error {
-	var connections map[string]*websocket.Conn
-	var roomType string
+// deviceIdFromRequest -> Device id from the handshake. Browsers can't set headers on a websocket,
+// so the query is checked as well. Clients without one get a fresh id per connection
+func deviceIdFromRequest(r *http.Request) (string, error) {
+	deviceId := r.Header.Get("X-Device-Id")
+	if deviceId == "" {
+		deviceId = r.URL.Query().Get("device_id")
+	}
 
-	// Get connections based on room type
-	if ws.GetRoomType(roomId) == "chat" {
-		connections = ws.GetChatConnectionsReadOnly(roomId)
-		roomType = "chat"
-	} else {
-		connections = ws.GetGroupConnectionsReadOnly(roomId)
-		roomType = "group"
+	if deviceId == "" {
+		return uuid.New().String(), nil
 	}
 
-	if connections == nil {
-		return fmt.Errorf("room %s (%s) not found or empty", roomId, roomType)
+	if !isValidClientId(deviceId) {
+		return "", errInvalidDeviceId
 	}
 
-	var errors []string
-	successCount := 0
+	return deviceId, nil
+}
 
-	for userId, conn := range connections {
-		if userId == senderId {
-			continue
-		}
+// isValidClientId -> Ids chosen by clients (devices, messages) are 1-64 letters, digits, '-' or '_'
+func isValidClientId(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
 
-		// Check if connection is still valid
-		if conn == nil {
-			errors = append(errors, fmt.Sprintf("nil connection for user %s", userId))
-			continue
+	for _, char := range id {
+		isValid := char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+			char == '-' || char == '_'
+		if !isValid {
+			return false
 		}
+	}
 
-		if err := conn.WriteMessage(messageType, payload); err != nil {
-			errors = append(errors, fmt.Sprintf("failed to send message to %s: %v", userId, err))
-		} else {
-			successCount++
-		}
+	return true
+}
+
+// OpenWebsocket -> One connection per device, subscribed to every chat, secret chat and group of the user
+func (handler *Handler) OpenWebsocket(w http.ResponseWriter, r *http.Request) {
+	payload, ok := authPayload(w, r)
+	if !ok {
+		return
 	}
 
-	if len(errors) > 0 {
-		slog.Warn("broadcast errors", "room_id", roomId, "room_type", roomType, "errors", errors)
+	deviceId, err := deviceIdFromRequest(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalidDeviceId", err.Error())
+		return
 	}
 
-	slog.Debug("broadcast completed", "room_id", roomId, "room_type", roomType,
-		"success_count", successCount, "error_count", len(errors))
+	// checked again on register, this only saves the upgrade
+	devices := handler.WebSocket.GetUserDevices(payload.UserId.Hex())
+	if len(devices) >= maxDevicesPerUser && !slices.Contains(devices, deviceId) {
+		utils.WriteError(w, http.StatusTooManyRequests, "tooManyDevices", errTooManyDevices.Error())
+		return
+	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("broadcast errors: %s", strings.Join(errors, "; "))
+	rooms, err := handler.userRooms(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "getRooms", "failed to load the user's chats and groups")
+		return
 	}
 
-	return nil
-}
+	conn, err := WebsocketUpgrade(w, r)
+	if err != nil {
+		// the upgrader has written the error response already
+		slog.Error("upgrading websocket", "error", err)
+		return
+	}
 
-// removeUserFromAllRooms -> Helper method to remove user from all rooms
-func (ws *WebSocketManager) removeUserFromAllRooms(userId string) {
-	// Remove from chat rooms
-	for roomId, connections := range ws.ChatConnections {
-		if _, userExists := connections[userId]; userExists {
-			delete(connections, userId)
-			if len(connections) == 0 {
-				delete(ws.ChatConnections, roomId)
-			}
-		}
+	statusBefore := handler.WebSocket.UserStatus(payload.UserId.Hex())
+
+	wsConn := NewWsConnection(conn, payload.UserId.Hex(), deviceId, payload.Username)
+	wsConn.SessionId = payload.SessionId.String()
+	wsConn.ExpireAt = payload.ExpiryAt
+
+	if err := handler.WebSocket.Register(wsConn, rooms); err != nil {
+		// another device connected in the meantime
+		wsConn.CloseWith(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+
+	if statusBefore != StatusOnline {
+		handler.announcePresence(rooms, PresenceData{UserId: wsConn.UserId, Status: StatusOnline})
 	}
 
-	// Remove from group rooms
-	for roomId, connections := range ws.GroupConnections {
-		if _, userExists := connections[userId]; userExists {
-			delete(connections, userId)
-			if len(connections) == 0 {
-				delete(ws.GroupConnections, roomId)
+	go func() {
+		if err := handler.handleIncomingMsgs(wsConn); err != nil {
+			slog.Error("handling incoming ws messages", "error", err)
+		}
+
+		handler.announceDisconnect(wsConn)
+	}()
+}
+
+// handleIncomingMsgs -> Reads frames until the connection closes or stops answering pings, see handleEvent
+func (handler *Handler) handleIncomingMsgs(wsConn *WsConnection) error {
+	defer func() {
+		handler.WebSocket.Unregister(wsConn)
+		wsConn.Close()
+		slog.Info("websocket handler ended", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+	}()
+
+	slog.Info("websocket handler started", "user_id", wsConn.UserId, "device_id", wsConn.DeviceId)
+
+	// every pong and every frame proves the client is alive
+	wsConn.Conn.SetReadLimit(maxMessageSize)
+	wsConn.Conn.SetReadDeadline(time.Now().Add(wsConn.pongWait))
+	wsConn.Conn.SetPongHandler(func(string) error {
+		return wsConn.Conn.SetReadDeadline(time.Now().Add(wsConn.pongWait))
+	})
+
+	for {
+		_, payload, err := wsConn.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Error("unexpected websocket close", "error", err, "user_id", wsConn.UserId)
 			}
+			return fmt.Errorf("failed to ws read message: %w", err)
 		}
+
+		wsConn.Conn.SetReadDeadline(time.Now().Add(wsConn.pongWait))
+
+		handler.handleEvent(wsConn, payload)
 	}
 }
 
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"chat_app/broker"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Broker event types. Every instance applies them to its own connections
+const (
+	brokerFrame      = "frame"      // Payload to every connection in Room except ExcludeConnId
+	brokerJoin       = "join"       // every device of UserId joins the Room encoded in Payload
+	brokerLeave      = "leave"      // every device of UserId leaves Room and gets Payload
+	brokerClose      = "close"      // every connection leaves Room and gets Payload
+	brokerDisconnect = "disconnect" // every device of UserId is disconnected
+	// every device of UserId signed in with the session in Payload is disconnected
+	brokerEndSession = "end_session"
+	// every device of UserId not signed in with the session in Payload is disconnected
+	brokerEndOtherSessions = "end_other_sessions"
+)
+
+// NewWebSocketManager -> Manager whose room fan-out goes through the broker, so users connected to
+// another instance get the events as well
+func NewWebSocketManager(eventBroker broker.Broker) (*WebSocketManager, error) {
+	ws := newWebSocketManager(eventBroker)
+
+	unsubscribe, err := eventBroker.Subscribe(ws.handleBrokerEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.stopBroker = unsubscribe
+
+	return ws, nil
+}
+
+// Close -> Stops receiving broker events
+func (ws *WebSocketManager) Close() {
+	if ws.stopBroker != nil {
+		ws.stopBroker()
+	}
+}
+
+// Publish -> Sends the event to every instance, this one included
+func (ws *WebSocketManager) Publish(event broker.Event) error {
+	return ws.broker.Publish(event)
+}
+
+func (ws *WebSocketManager) handleBrokerEvent(event broker.Event) {
+	switch event.Type {
+	case brokerFrame:
+		err := ws.BroadcastToRoom(event.Room, event.ExcludeConnId, event.Payload)
+		if err != nil && !errors.Is(err, errRoomEmpty) {
+			slog.Warn("failed to broadcast event", "error", err, "room", event.Room)
+		}
+	case brokerJoin:
+		var room Room
+		if err := json.Unmarshal(event.Payload, &room); err != nil {
+			slog.Error("decoding broker join", "error", err, "user_id", event.UserId)
+			return
+		}
+
+		ws.JoinRoom(event.UserId, room)
+	case brokerLeave:
+		sendAll(ws.LeaveRoom(event.UserId, event.Room), event.Payload)
+	case brokerClose:
+		sendAll(ws.CloseRoom(event.Room), event.Payload)
+	case brokerDisconnect:
+		ws.ForceDisconnectUser(event.UserId)
+	case brokerEndSession:
+		ws.DisconnectSessions(event.UserId, func(sessionId string) bool {
+			return sessionId == string(event.Payload)
+		})
+	case brokerEndOtherSessions:
+		ws.DisconnectSessions(event.UserId, func(sessionId string) bool {
+			return sessionId != string(event.Payload)
+		})
+	default:
+		slog.Warn("unknown broker event", "type", event.Type)
+	}
+}
+
+func sendAll(connections []*WsConnection, payload []byte) {
+	if payload == nil {
+		return
+	}
+
+	for _, wsConn := range connections {
+		if err := wsConn.Send(payload); err != nil {
+			slog.Warn("queueing ws event", "error", err, "user_id", wsConn.UserId)
+		}
+	}
+}
+
+// publishEvent -> Logs failures, a broken broker must not fail the request that caused the event
+func (handler *Handler) publishEvent(event broker.Event) {
+	if handler.WebSocket == nil {
+		return
+	}
+
+	if err := handler.WebSocket.Publish(event); err != nil {
+		slog.Error("publishing broker event", "error", err, "type", event.Type, "room", event.Room)
+	}
+}
+
+// joinRoom -> Subscribes every device of the user to the room, wherever they are connected
+func (handler *Handler) joinRoom(userId primitive.ObjectID, room Room) {
+	payload, err := json.Marshal(room)
+	if err != nil {
+		slog.Error("encoding room", "error", err, "room", room.Id)
+		return
+	}
+
+	handler.publishEvent(broker.Event{Type: brokerJoin, Room: room.Id, UserId: userId.Hex(), Payload: payload})
+}
+
+// disconnectUser -> Closes every connection of the user, e.g. once the account is deleted
+func (handler *Handler) disconnectUser(userId primitive.ObjectID) {
+	handler.publishEvent(broker.Event{Type: brokerDisconnect, UserId: userId.Hex()})
+}
+
+// disconnectSession -> Closes the connections opened with the session, once it is revoked
+func (handler *Handler) disconnectSession(userId primitive.ObjectID, sessionId string) {
+	handler.publishEvent(broker.Event{Type: brokerEndSession, UserId: userId.Hex(), Payload: []byte(sessionId)})
+}
+
+// disconnectOtherSessions -> Closes every connection of the user except those of keepSessionId
+func (handler *Handler) disconnectOtherSessions(userId primitive.ObjectID, keepSessionId string) {
+	handler.publishEvent(broker.Event{Type: brokerEndOtherSessions, UserId: userId.Hex(), Payload: []byte(keepSessionId)})
+}
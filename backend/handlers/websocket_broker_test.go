@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"chat_app/broker"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestInstances -> Two handlers, as if two replicas were connected to the same broker
+func newTestInstances(t *testing.T) (*Handler, *Handler) {
+	t.Helper()
+
+	eventBroker := broker.NewMemory()
+
+	first, err := NewWebSocketManager(eventBroker)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	t.Cleanup(first.Close)
+
+	second, err := NewWebSocketManager(eventBroker)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	t.Cleanup(second.Close)
+
+	return &Handler{WebSocket: first}, &Handler{WebSocket: second}
+}
+
+func TestBroker_TwoInstances(t *testing.T) {
+	group := testRoom(RoomGroup)
+	user1 := primitive.NewObjectID()
+	user2 := primitive.NewObjectID()
+
+	t.Run("Frames Reach The Other Instance", func(t *testing.T) {
+		instance1, instance2 := newTestInstances(t)
+
+		conn1, received1 := createReadingTestConnection(t)
+		conn2, received2 := createReadingTestConnection(t)
+
+		sender := NewWsConnection(conn1, user1.Hex(), "phone", "user_one")
+		instance1.WebSocket.Register(sender, []Room{group})
+		instance2.WebSocket.Register(NewWsConnection(conn2, user2.Hex(), "phone", "user_two"), []Room{group})
+
+		instance1.publish(group.Id, sender.Id, EventMessageDelete, MessageDeleteData{MessageId: "abc"})
+
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(readTestMessage(t, received2)), &envelope); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+
+		if envelope.Type != EventMessageDelete || envelope.Room != group.Id {
+			t.Errorf("Expected %s for %s, got %+v", EventMessageDelete, group.Id, envelope)
+		}
+
+		// the connection id is excluded on every instance
+		select {
+		case message := <-received1:
+			t.Errorf("the sending connection got %q", message)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("Sender's Device On The Other Instance Gets Its Own Message", func(t *testing.T) {
+		instance1, instance2 := newTestInstances(t)
+
+		phone, _ := createReadingTestConnection(t)
+		laptop, receivedLaptop := createReadingTestConnection(t)
+
+		sender := NewWsConnection(phone, user1.Hex(), "phone", "user_one")
+		instance1.WebSocket.Register(sender, []Room{group})
+		instance2.WebSocket.Register(NewWsConnection(laptop, user1.Hex(), "laptop", "user_one"), []Room{group})
+
+		instance1.publish(group.Id, sender.Id, EventMessageDelete, MessageDeleteData{MessageId: "abc"})
+
+		readTestMessage(t, receivedLaptop)
+	})
+
+	t.Run("Joins, Evictions And Closes Apply Everywhere", func(t *testing.T) {
+		instance1, instance2 := newTestInstances(t)
+		chat := testRoom(RoomChat)
+
+		conn2, received2 := createReadingTestConnection(t)
+		instance2.WebSocket.Register(NewWsConnection(conn2, user2.Hex(), "phone", "user_two"), []Room{group})
+
+		instance1.joinRoom(user2, chat)
+		if !instance2.WebSocket.IsUserInRoom(chat.Id, user2.Hex()) {
+			t.Fatal("user2 should have joined the chat on the instance it is connected to")
+		}
+
+		if room, _ := instance2.WebSocket.SubscribedRoom(instance2.WebSocket.Connections[user2.Hex()]["phone"], chat.Id); room != chat {
+			t.Errorf("Expected the joined room to arrive intact, got %+v", room)
+		}
+
+		instance1.evictFromRoom(user2, group.Id, RoomRemovedKicked)
+		if instance2.WebSocket.IsUserInRoom(group.Id, user2.Hex()) {
+			t.Error("user2 should have been evicted from the group")
+		}
+
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(readTestMessage(t, received2)), &envelope); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+
+		if envelope.Type != EventRoomRemoved || envelope.Room != group.Id {
+			t.Errorf("Expected %s for %s, got %+v", EventRoomRemoved, group.Id, envelope)
+		}
+
+		instance1.closeRoom(chat.Id, RoomRemovedDeleted)
+		if instance2.WebSocket.IsUserInRoom(chat.Id, user2.Hex()) {
+			t.Error("nobody should be in a deleted chat")
+		}
+
+		readTestMessage(t, received2)
+
+		instance1.disconnectUser(user2)
+		if instance2.WebSocket.IsUserConnected(user2.Hex()) {
+			t.Error("user2 should have been disconnected")
+		}
+	})
+}
+
+func TestBroker_EndSessions(t *testing.T) {
+	instance1, instance2 := newTestInstances(t)
+	user := primitive.NewObjectID()
+
+	connect := func(deviceId, sessionId string) {
+		wsConn := NewWsConnection(createTestConnection(t), user.Hex(), deviceId, "user")
+		wsConn.SessionId = sessionId
+		if err := instance2.WebSocket.Register(wsConn, nil); err != nil {
+			t.Fatalf("Failed to register %s: %v", deviceId, err)
+		}
+	}
+
+	connect("phone", "session-1")
+	connect("laptop", "session-2")
+	connect("tablet", "session-3")
+
+	// revoked on one instance, the devices are connected to the other
+	instance1.disconnectSession(user, "session-1")
+	if devices := instance2.WebSocket.GetUserDevices(user.Hex()); len(devices) != 2 || devices[0] != "laptop" {
+		t.Fatalf("Expected only the revoked session's device to go, got %v", devices)
+	}
+
+	instance1.disconnectOtherSessions(user, "session-2")
+	if devices := instance2.WebSocket.GetUserDevices(user.Hex()); len(devices) != 1 || devices[0] != "laptop" {
+		t.Errorf("Expected the kept session's device to stay, got %v", devices)
+	}
+}
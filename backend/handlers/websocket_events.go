@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"chat_app/broker"
+	"chat_app/database/models"
+	"chat_app/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProtocolVersion -> Version of the websocket envelope. Frames without a version are read as this one
+const ProtocolVersion = 1
+
+// Websocket event types
+const (
+	EventMessageSend    = "message.send"    // client: new message for a room
+	EventMessageAck     = "message.ack"     // server: the message of a message.send was stored
+	EventMessageNew     = "message.new"     // server: a message was sent to the room
+	EventMessageEdit    = "message.edit"    // both: the content of a message changed
+	EventMessageDelete  = "message.delete"  // both: a message was deleted for everyone
+	EventRoomRemoved    = "room.removed"    // server: the connection was unsubscribed from the room
+	EventSync           = "sync"            // client: messages after the last seq it has of each room
+	EventSyncDone       = "sync.done"       // server: the messages of a sync were sent
+	EventReactionAdd    = "reaction.add"    // both: a user reacted to a message with an emoji
+	EventReactionRemove = "reaction.remove" // both: a user took their reaction back
+	EventPinsUpdate     = "pins.update"     // server: a message was pinned or unpinned, or the pins reordered
+	EventMessageRead    = "message.read"    // both: the user read the room up to a message, only 1:1 chats are told
+	EventTypingStart    = "typing.start"    // both: the user is typing in the room, never stored
+	EventTypingStop     = "typing.stop"     // both: the user stopped typing in the room
+	EventPresence       = "presence"        // both: online or away from the client, any status from the server
+	EventError          = "error"           // server: a frame was refused
+	EventPing           = "ping"            // client: keep-alive, answered with a pong
+	EventPong           = "pong"            // server: answer to a ping
+)
+
+// Error codes of error frames
+const (
+	errCodeInvalidFrame = "invalidFrame"
+	errCodeVersion      = "unsupportedVersion"
+	errCodeUnknownType  = "unknownType"
+	errCodeUnknownRoom  = "unknownRoom"
+	errCodeForbidden    = "forbidden"
+	errCodeInvalidData  = "invalidData"
+	errCodeNotFound     = "notFound"
+	errCodeConflict     = "conflict"
+	errCodeInternal     = "internalError"
+)
+
+// Envelope -> Every websocket frame, in both directions. Id is chosen by the client and echoed in the ack or
+// error frame of the event, so the client can match them
+type Envelope struct {
+	V    int             `json:"v,omitempty"`
+	Type string          `json:"type"`
+	Id   string          `json:"id,omitempty"`
+	Room string          `json:"room,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// MessageSendData -> Data of message.send. A send retried with the same ClientMessageId is stored once
+type MessageSendData struct {
+	Content         string `json:"content"`           // content is only for text messages
+	ContentAddress  string `json:"content_address"`   // content address is only for images
+	ContentType     string `json:"content_type"`      // either an image or text
+	ClientMessageId string `json:"client_message_id"` // optional, unique per sender
+	ReplyToId       string `json:"reply_to_id"`       // optional, a message of the same room
+}
+
+// MessageNewData -> Data of message.new, built by the server and shaped like a message of the listings.
+// Sender and timestamps come from the authenticated connection and the stored message, never from the client
+type MessageNewData struct {
+	Id             string    `json:"id"`
+	ChatId         string    `json:"chat_id,omitempty"`
+	GroupId        string    `json:"group_id,omitempty"`
+	SenderId       string    `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Type           string    `json:"type"`
+	Content        string    `json:"content"`
+	ContentAddress string    `json:"content_address"`
+	IsSecret       bool      `json:"is_secret"`
+	Seq            int64     `json:"seq"`
+	CreatedAt      time.Time `json:"created_at"`
+	// ClientMessageId lets the sender's other devices match the message to their own send
+	ClientMessageId string `json:"client_message_id,omitempty"`
+	ReplyToId       string `json:"reply_to_id,omitempty"`
+	// ReplyTo quotes the parent of a live reply, synced replies only carry ReplyToId
+	ReplyTo *models.MessagePreview `json:"reply_to,omitempty"`
+}
+
+// newMessageData -> content is the plain text, message.Content holds the ciphertext
+func newMessageData(message *models.Message, senderUsername, content string) MessageNewData {
+	data := MessageNewData{
+		Id:              message.Id.Hex(),
+		SenderId:        message.SenderId.Hex(),
+		SenderUsername:  senderUsername,
+		Type:            message.Type,
+		Content:         content,
+		ContentAddress:  message.ContentAddress,
+		IsSecret:        message.IsSecret,
+		Seq:             message.Seq,
+		CreatedAt:       message.CreatedAt,
+		ClientMessageId: message.ClientMessageId,
+	}
+
+	if !message.ChatId.IsZero() {
+		data.ChatId = message.ChatId.Hex()
+	}
+
+	if !message.GroupId.IsZero() {
+		data.GroupId = message.GroupId.Hex()
+	}
+
+	if message.ReplyToId != nil {
+		data.ReplyToId = message.ReplyToId.Hex()
+	}
+
+	return data
+}
+
+// MessageAckData -> Data of message.ack. Duplicate is set when a retry found the message stored already,
+// it was not broadcast again
+type MessageAckData struct {
+	MessageId       string    `json:"message_id"`
+	Seq             int64     `json:"seq"`
+	CreatedAt       time.Time `json:"created_at"`
+	ClientMessageId string    `json:"client_message_id,omitempty"`
+	Duplicate       bool      `json:"duplicate,omitempty"`
+}
+
+// MessageEditData -> Data of message.edit. SenderId, SenderUsername and EditedAt are set by the server
+type MessageEditData struct {
+	MessageId      string     `json:"message_id"`
+	Content        string     `json:"content"`
+	SenderId       string     `json:"sender_id,omitempty"`
+	SenderUsername string     `json:"sender_username,omitempty"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+}
+
+// MessageDeleteData -> Data of message.delete. DeletedBy is set by the server
+type MessageDeleteData struct {
+	MessageId string `json:"message_id"`
+	DeletedBy string `json:"deleted_by,omitempty"`
+}
+
+// ReactionData -> Data of reaction.add and reaction.remove. UserId is set by the server
+type ReactionData struct {
+	MessageId string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	UserId    string `json:"user_id,omitempty"`
+}
+
+// ReadData -> Data of message.read. Clients send the message id, Seq and UserId are set by the server
+type ReadData struct {
+	MessageId string `json:"message_id"`
+	Seq       int64  `json:"seq,omitempty"`
+	UserId    string `json:"user_id,omitempty"`
+}
+
+// Actions of pins.update
+const (
+	PinsPinned    = "pinned"
+	PinsUnpinned  = "unpinned"
+	PinsReordered = "reordered"
+)
+
+// PinsUpdateData -> Data of pins.update. MessageIds are the pinned messages of the room after the change, in
+// order. MessageId is the message pinned or unpinned
+type PinsUpdateData struct {
+	Action     string   `json:"action"`
+	MessageId  string   `json:"message_id,omitempty"`
+	MessageIds []string `json:"message_ids"`
+	UpdatedBy  string   `json:"updated_by"`
+}
+
+// Reasons of room.removed
+const (
+	RoomRemovedKicked  = "kicked"
+	RoomRemovedBanned  = "banned"
+	RoomRemovedLeft    = "left"
+	RoomRemovedDeleted = "deleted"
+)
+
+// RoomRemovedData -> Data of room.removed
+type RoomRemovedData struct {
+	Reason string `json:"reason"`
+}
+
+// ErrorData -> Data of error frames
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// newEnvelope -> Server frame with its data marshalled
+func newEnvelope(eventType, id, room string, data any) (*Envelope, error) {
+	envelope := &Envelope{
+		V:    ProtocolVersion,
+		Type: eventType,
+		Id:   id,
+		Room: room,
+	}
+
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+
+		envelope.Data = encoded
+	}
+
+	return envelope, nil
+}
+
+// encodeEvent -> Server frame ready to be queued
+func encodeEvent(eventType, id, room string, data any) ([]byte, error) {
+	envelope, err := newEnvelope(eventType, id, room, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope)
+}
+
+// sendEvent -> Queues a frame for one connection
+func (wsConn *WsConnection) sendEvent(eventType, id, room string, data any) {
+	payload, err := encodeEvent(eventType, id, room, data)
+	if err != nil {
+		slog.Error("encoding ws event", "error", err, "type", eventType)
+		return
+	}
+
+	if err := wsConn.Send(payload); err != nil {
+		slog.Warn("queueing ws event", "error", err, "type", eventType, "user_id", wsConn.UserId)
+	}
+}
+
+// sendError -> Error frames answer a refused frame, the connection stays open
+func (wsConn *WsConnection) sendError(id, room, code, message string) {
+	wsConn.sendEvent(EventError, id, room, ErrorData{Code: code, Message: message})
+}
+
+// publish -> Queues a frame for every connection in the room except excludeConnId, on every instance
+func (handler *Handler) publish(room, excludeConnId, eventType string, data any) {
+	payload, err := encodeEvent(eventType, "", room, data)
+	if err != nil {
+		slog.Error("encoding ws event", "error", err, "type", eventType)
+		return
+	}
+
+	handler.publishEvent(broker.Event{Type: brokerFrame, Room: room, ExcludeConnId: excludeConnId, Payload: payload})
+}
+
+// evictFromRoom -> Unsubscribes every device of the user from the room and tells them why
+func (handler *Handler) evictFromRoom(userId primitive.ObjectID, room, reason string) {
+	payload, err := encodeEvent(EventRoomRemoved, "", room, RoomRemovedData{Reason: reason})
+	if err != nil {
+		slog.Error("encoding ws event", "error", err, "type", EventRoomRemoved)
+		return
+	}
+
+	handler.publishEvent(broker.Event{Type: brokerLeave, Room: room, UserId: userId.Hex(), Payload: payload})
+}
+
+// closeRoom -> Unsubscribes every connection from the room and tells them why
+func (handler *Handler) closeRoom(room, reason string) {
+	payload, err := encodeEvent(EventRoomRemoved, "", room, RoomRemovedData{Reason: reason})
+	if err != nil {
+		slog.Error("encoding ws event", "error", err, "type", EventRoomRemoved)
+		return
+	}
+
+	handler.publishEvent(broker.Event{Type: brokerClose, Room: room, Payload: payload})
+}
+
+// broadcastMessageEvent -> Tells the room of the message about a change made over http
+func (handler *Handler) broadcastMessageEvent(message *models.Message, eventType string, data any) {
+	handler.publish(RoomOfMessage(message).Id, "", eventType, data)
+}
+
+// handleEvent -> Answers one frame. Only a failing connection ends the read loop, bad frames get an error frame
+func (handler *Handler) handleEvent(wsConn *WsConnection, frame []byte) {
+	var envelope Envelope
+
+	if err := json.Unmarshal(frame, &envelope); err != nil {
+		wsConn.sendError("", "", errCodeInvalidFrame, "frame is not a valid envelope")
+		return
+	}
+
+	if envelope.V != 0 && envelope.V != ProtocolVersion {
+		wsConn.sendError(envelope.Id, envelope.Room, errCodeVersion, "protocol version is not supported")
+		return
+	}
+
+	switch envelope.Type {
+	case EventPing:
+		wsConn.sendEvent(EventPong, envelope.Id, "", nil)
+	case EventMessageSend:
+		handler.handleMessageSend(wsConn, &envelope)
+	case EventMessageEdit:
+		handler.handleMessageEdit(wsConn, &envelope)
+	case EventMessageDelete:
+		handler.handleMessageDelete(wsConn, &envelope)
+	case EventReactionAdd, EventReactionRemove:
+		handler.handleReaction(wsConn, &envelope)
+	case EventMessageRead:
+		handler.handleMessageRead(wsConn, &envelope)
+	case EventSync:
+		handler.handleSync(wsConn, &envelope)
+	case EventTypingStart, EventTypingStop:
+		handler.handleTyping(wsConn, &envelope)
+	case EventPresence:
+		handler.handlePresence(wsConn, &envelope)
+	default:
+		wsConn.sendError(envelope.Id, envelope.Room, errCodeUnknownType, "unknown event type: "+envelope.Type)
+	}
+}
+
+// eventRoom -> The room of the event, if the connection is subscribed to it
+func (handler *Handler) eventRoom(wsConn *WsConnection, envelope *Envelope) (Room, bool) {
+	room, subscribed := handler.WebSocket.SubscribedRoom(wsConn, envelope.Room)
+	if !subscribed {
+		wsConn.sendError(envelope.Id, envelope.Room, errCodeUnknownRoom, "you are not in this room")
+	}
+
+	return room, subscribed
+}
+
+func (handler *Handler) handleMessageSend(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	var data MessageSendData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "data of message.send is invalid")
+		return
+	}
+
+	if data.ClientMessageId != "" && !isValidClientId(data.ClientMessageId) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "client_message_id must be 1-64 letters, digits, '-' or '_'")
+		return
+	}
+
+	replyToId, errResp := parseReplyToId(data.ReplyToId)
+	if errResp != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "reply_to_id is invalid")
+		return
+	}
+
+	userId, errResp := utils.ToObjectId(wsConn.UserId)
+	if errResp != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "user id is invalid")
+		return
+	}
+
+	if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+		return
+	}
+
+	// a retry of a stored send is acked again, never stored or broadcast twice
+	if data.ClientMessageId != "" {
+		if handler.ackSentMessage(wsConn, envelope, room, userId, data.ClientMessageId) {
+			return
+		}
+	}
+
+	var replyTo *models.MessagePreview
+	if !replyToId.IsZero() {
+		parent, err := handler.replyParent(room.MessageFilter(), replyToId)
+		if errors.Is(err, errReplyNotInRoom) {
+			wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, err.Error())
+			return
+		}
+
+		if err != nil {
+			slog.Error("fetching replied message", "error", err, "room", room.Id, "sender_id", wsConn.UserId)
+			wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to store the message")
+			return
+		}
+
+		// the reply is sent without a quote then, listings quote it again
+		if replyTo, err = handler.messagePreview(parent); err != nil {
+			slog.Warn("failed to decrypt replied message", "err", err, "msgID", parent.Id.Hex())
+		}
+	}
+
+	message, err := handler.storeRoomMsgToDB(room, wsConn.UserId, data, replyToId)
+	if errors.Is(err, models.ErrMessageExists) {
+		// the retry raced the first send, which won
+		if !handler.ackSentMessage(wsConn, envelope, room, userId, data.ClientMessageId) {
+			wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to store the message")
+		}
+		return
+	}
+
+	if err != nil {
+		slog.Error("failed to store message to DB", "error", err, "room", room.Id, "sender_id", wsConn.UserId)
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to store the message")
+		return
+	}
+
+	wsConn.sendEvent(EventMessageAck, envelope.Id, room.Id, MessageAckData{
+		MessageId:       message.Id.Hex(),
+		Seq:             message.Seq,
+		CreatedAt:       message.CreatedAt,
+		ClientMessageId: message.ClientMessageId,
+	})
+
+	newData := newMessageData(message, wsConn.Username, data.Content)
+	newData.ReplyTo = replyTo
+
+	// only what was stored is broadcast
+	handler.publish(room.Id, wsConn.Id, EventMessageNew, newData)
+}
+
+// ackSentMessage -> Acks the message the sender stored with clientMessageId before. False when there is none
+// or it can't be looked up, an error frame has been sent for the latter
+func (handler *Handler) ackSentMessage(wsConn *WsConnection, envelope *Envelope, room Room, userId primitive.ObjectID,
+	clientMessageId string) bool {
+	message, err := handler.sentMessage(userId, clientMessageId)
+	if err != nil {
+		slog.Error("fetching sent message", "error", err, "room", room.Id, "sender_id", wsConn.UserId)
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to store the message")
+		return true
+	}
+
+	if message == nil {
+		return false
+	}
+
+	if RoomOfMessage(message).Id != room.Id {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "client_message_id was used for a message of another room")
+		return true
+	}
+
+	wsConn.sendEvent(EventMessageAck, envelope.Id, room.Id, MessageAckData{
+		MessageId:       message.Id.Hex(),
+		Seq:             message.Seq,
+		CreatedAt:       message.CreatedAt,
+		ClientMessageId: message.ClientMessageId,
+		Duplicate:       true,
+	})
+
+	return true
+}
+
+func (handler *Handler) handleMessageEdit(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	var data MessageEditData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "data of message.edit is invalid")
+		return
+	}
+
+	userId, messageId, ok := eventMessageIds(wsConn, envelope, room, data.MessageId)
+	if !ok {
+		return
+	}
+
+	if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+		return
+	}
+
+	message, err := handler.editMessage(userId, messageId, data.Content, room.MessageFilter())
+	if err != nil {
+		handler.sendMessageError(wsConn, envelope, room, err)
+		return
+	}
+
+	// whatever identity the client sent is replaced
+	data.SenderId = wsConn.UserId
+	data.SenderUsername = wsConn.Username
+	data.EditedAt = message.EditedAt
+
+	handler.publish(room.Id, "", EventMessageEdit, data)
+}
+
+func (handler *Handler) handleMessageDelete(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	var data MessageDeleteData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "data of message.delete is invalid")
+		return
+	}
+
+	userId, messageId, ok := eventMessageIds(wsConn, envelope, room, data.MessageId)
+	if !ok {
+		return
+	}
+
+	if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+		return
+	}
+
+	if _, err := handler.deleteMessageForAll(userId, messageId, room.MessageFilter()); err != nil {
+		handler.sendMessageError(wsConn, envelope, room, err)
+		return
+	}
+
+	data.DeletedBy = wsConn.UserId
+
+	handler.publish(room.Id, "", EventMessageDelete, data)
+}
+
+// authorizeRoom -> Checked right before an event touches the database. A connection that is no longer a
+// member is evicted from the room
+func (handler *Handler) authorizeRoom(wsConn *WsConnection, envelope *Envelope, room Room,
+	userId primitive.ObjectID) bool {
+	isMember, err := handler.isRoomMember(room, userId)
+	if err != nil {
+		slog.Error("checking room membership", "error", err, "room", room.Id, "user_id", wsConn.UserId)
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to check your membership")
+		return false
+	}
+
+	if !isMember {
+		handler.WebSocket.LeaveRoom(wsConn.UserId, room.Id)
+		wsConn.sendError(envelope.Id, room.Id, errCodeForbidden, "you are no longer a member of this room")
+		return false
+	}
+
+	return true
+}
+
+// eventMessageIds -> Object ids of the connection's user and of the message the event is about
+func eventMessageIds(wsConn *WsConnection, envelope *Envelope, room Room,
+	messageId string) (primitive.ObjectID, primitive.ObjectID, bool) {
+	messageObjectId, errResp := utils.ToObjectId(messageId)
+	if errResp != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInvalidData, "message_id is invalid")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	userObjectId, errResp := utils.ToObjectId(wsConn.UserId)
+	if errResp != nil {
+		wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "user id is invalid")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	return userObjectId, messageObjectId, true
+}
+
+func (handler *Handler) sendMessageError(wsConn *WsConnection, envelope *Envelope, room Room, err error) {
+	if errors.Is(err, errMessageNotFound) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeNotFound, "no message of yours with this id in this room")
+		return
+	}
+
+	if errors.Is(err, errEditWindowClosed) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeForbidden, err.Error())
+		return
+	}
+
+	if errors.Is(err, errEditConflict) {
+		wsConn.sendError(envelope.Id, room.Id, errCodeConflict, err.Error())
+		return
+	}
+
+	slog.Error("handling ws event", "error", err, "type", envelope.Type, "room", room.Id, "user_id", wsConn.UserId)
+	wsConn.sendError(envelope.Id, room.Id, errCodeInternal, "failed to update the message")
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebsocketEvents(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+	chat := testRoom(RoomChat)
+
+	client := serveTestWebsocket(t, handler, "user1", []Room{chat})
+
+	tests := []struct {
+		name     string
+		frame    string
+		wantType string
+		wantId   string
+		wantCode string
+	}{
+		{"Ping", `{"v":1,"type":"ping","id":"p1"}`, EventPong, "p1", ""},
+		{"Ping without version", `{"type":"ping","id":"p2"}`, EventPong, "p2", ""},
+		{"Malformed frame", `{"type":`, EventError, "", errCodeInvalidFrame},
+		{"Unknown version", `{"v":9,"type":"ping","id":"v9"}`, EventError, "v9", errCodeVersion},
+		{"Unknown type", `{"type":"message.explode","id":"u1"}`, EventError, "u1", errCodeUnknownType},
+		{"Room of someone else", `{"type":"message.send","id":"r1","room":"chat:` + primitive.NewObjectID().Hex() + `","data":{}}`,
+			EventError, "r1", errCodeUnknownRoom},
+		{"Invalid data", `{"type":"message.send","id":"d1","room":"` + chat.Id + `","data":"text"}`,
+			EventError, "d1", errCodeInvalidData},
+		{"Invalid client message id", `{"type":"message.send","id":"c1","room":"` + chat.Id + `","data":{"content":"hi","client_message_id":"a b"}}`,
+			EventError, "c1", errCodeInvalidData},
+		{"Invalid reply id", `{"type":"message.send","id":"rp1","room":"` + chat.Id + `","data":{"content":"hi","reply_to_id":"x"}}`,
+			EventError, "rp1", errCodeInvalidData},
+		{"Invalid emoji", `{"type":"reaction.add","id":"e1","room":"` + chat.Id + `","data":{"message_id":"` + primitive.NewObjectID().Hex() + `","emoji":"ok"}}`,
+			EventError, "e1", errCodeInvalidData},
+		{"Invalid message id", `{"type":"message.delete","id":"m1","room":"` + chat.Id + `","data":{"message_id":"x"}}`,
+			EventError, "m1", errCodeInvalidData},
+		{"Invalid read message id", `{"type":"message.read","id":"rd1","room":"` + chat.Id + `","data":{"message_id":"x"}}`,
+			EventError, "rd1", errCodeInvalidData},
+	}
+
+	// every frame is answered on the same connection, none of them ends it
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := client.WriteMessage(websocket.TextMessage, []byte(tt.frame)); err != nil {
+				t.Fatalf("Failed to send frame: %v", err)
+			}
+
+			envelope := readTestEnvelope(t, client)
+
+			if envelope.Type != tt.wantType || envelope.Id != tt.wantId {
+				t.Fatalf("Expected %s %q, got %s %q", tt.wantType, tt.wantId, envelope.Type, envelope.Id)
+			}
+
+			if envelope.V != ProtocolVersion {
+				t.Errorf("Expected version %d, got %d", ProtocolVersion, envelope.V)
+			}
+
+			if tt.wantCode == "" {
+				return
+			}
+
+			var data ErrorData
+			if err := json.Unmarshal(envelope.Data, &data); err != nil {
+				t.Fatalf("Failed to decode error data: %v", err)
+			}
+
+			if data.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s (%s)", tt.wantCode, data.Code, data.Message)
+			}
+		})
+	}
+}
+
+func TestPublishSkipsSendingConnection(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+	group := testRoom(RoomGroup)
+
+	conn1, received1 := createReadingTestConnection(t)
+	conn2, received2 := createReadingTestConnection(t)
+
+	sender := NewWsConnection(conn1, "user1", "phone", "user_one")
+	handler.WebSocket.Register(sender, []Room{group})
+	handler.WebSocket.Register(NewWsConnection(conn2, "user2", "phone", "user_two"), []Room{group})
+
+	handler.publish(group.Id, sender.Id, EventMessageDelete, MessageDeleteData{MessageId: "abc"})
+
+	var envelope Envelope
+	if err := json.Unmarshal([]byte(readTestMessage(t, received2)), &envelope); err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+
+	if envelope.Type != EventMessageDelete || envelope.Room != group.Id {
+		t.Errorf("Expected %s for %s, got %+v", EventMessageDelete, group.Id, envelope)
+	}
+
+	select {
+	case message := <-received1:
+		t.Errorf("the sending connection got %q", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// nobody connected is not an error worth logging, and must not panic
+	handler.publish(testRoom(RoomChat).Id, "", EventMessageDelete, nil)
+}
+
+func TestEvictFromRoom(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+	group := testRoom(RoomGroup)
+	userId := primitive.NewObjectID()
+
+	phone, receivedPhone := createReadingTestConnection(t)
+	laptop, receivedLaptop := createReadingTestConnection(t)
+	other, receivedOther := createReadingTestConnection(t)
+
+	handler.WebSocket.Register(NewWsConnection(phone, userId.Hex(), "phone", "banned"), []Room{group})
+	handler.WebSocket.Register(NewWsConnection(laptop, userId.Hex(), "laptop", "banned"), []Room{group})
+	handler.WebSocket.Register(NewWsConnection(other, "user2", "phone", "user_two"), []Room{group})
+
+	handler.evictFromRoom(userId, group.Id, RoomRemovedBanned)
+
+	// every device of the user is told, right away
+	for _, received := range []<-chan string{receivedPhone, receivedLaptop} {
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(readTestMessage(t, received)), &envelope); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+
+		var data RoomRemovedData
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			t.Fatalf("Failed to decode data: %v", err)
+		}
+
+		if envelope.Type != EventRoomRemoved || envelope.Room != group.Id || data.Reason != RoomRemovedBanned {
+			t.Errorf("Expected %s %s for %s, got %+v", EventRoomRemoved, RoomRemovedBanned, group.Id, envelope)
+		}
+	}
+
+	if handler.WebSocket.IsUserInRoom(group.Id, userId.Hex()) {
+		t.Error("the banned user should not be in the group anymore")
+	}
+
+	// the group keeps going for everyone else
+	handler.publish(group.Id, "", EventMessageDelete, MessageDeleteData{MessageId: "abc"})
+
+	if message := readTestMessage(t, receivedOther); !strings.Contains(message, EventMessageDelete) {
+		t.Errorf("Expected the remaining member to get %s, got %q", EventMessageDelete, message)
+	}
+
+	select {
+	case message := <-receivedPhone:
+		t.Errorf("the evicted device got %q", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// serveTestWebsocket -> Client connection to a server running the handler's read loop for userId
+func serveTestWebsocket(t *testing.T, handler *Handler, userId string, rooms []Room) *websocket.Conn {
+	t.Helper()
+
+	return serveTestWebsocketWith(t, handler, userId, rooms, nil)
+}
+
+// serveTestWebsocketWith -> setup can change the server side connection before it is registered
+func serveTestWebsocketWith(t *testing.T, handler *Handler, userId string, rooms []Room,
+	setup func(wsConn *WsConnection)) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade connection: %v", err)
+			return
+		}
+
+		wsConn := NewWsConnection(conn, userId, "test", userId)
+		if setup != nil {
+			setup(wsConn)
+		}
+
+		if err := handler.WebSocket.Register(wsConn, rooms); err != nil {
+			t.Errorf("Failed to register connection: %v", err)
+			return
+		}
+
+		go handler.handleIncomingMsgs(wsConn)
+	}))
+	t.Cleanup(server.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func readTestEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var envelope Envelope
+	if err := conn.ReadJSON(&envelope); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+
+	return envelope
+}
+
+func TestNewMessageDataIgnoresClientIdentity(t *testing.T) {
+	frame := `{"sender_id":"someone-else","sender_username":"admin","content":"hi","content_type":"text","client_message_id":"c-1"}`
+
+	var data MessageSendData
+	if err := json.Unmarshal([]byte(frame), &data); err != nil {
+		t.Fatalf("Failed to decode data: %v", err)
+	}
+
+	senderId := primitive.NewObjectID()
+	message := &models.Message{
+		Id:              primitive.NewObjectID(),
+		ChatId:          primitive.NewObjectID(),
+		SenderId:        senderId,
+		Type:            data.ContentType,
+		Content:         "ciphertext",
+		CreatedAt:       time.Now(),
+		ClientMessageId: data.ClientMessageId,
+	}
+
+	newData := newMessageData(message, "user_one", data.Content)
+
+	if newData.SenderId != senderId.Hex() || newData.SenderUsername != "user_one" {
+		t.Errorf("Expected the stored sender, got %s %s", newData.SenderId, newData.SenderUsername)
+	}
+
+	if newData.Id != message.Id.Hex() || !newData.CreatedAt.Equal(message.CreatedAt) {
+		t.Errorf("Expected the stored id and timestamp, got %s %s", newData.Id, newData.CreatedAt)
+	}
+
+	if newData.ClientMessageId != "c-1" {
+		t.Errorf("Expected the client message id to be echoed, got %q", newData.ClientMessageId)
+	}
+
+	if newData.Content != "hi" || newData.GroupId != "" {
+		t.Errorf("Expected the plain content of a chat message, got %+v", newData)
+	}
+}
+
+func TestNewMessageDataReply(t *testing.T) {
+	parentId := primitive.NewObjectID()
+	message := &models.Message{
+		Id:        primitive.NewObjectID(),
+		GroupId:   primitive.NewObjectID(),
+		SenderId:  primitive.NewObjectID(),
+		Type:      "text",
+		CreatedAt: time.Now(),
+	}
+
+	if data := newMessageData(message, "user_one", "hi"); data.ReplyToId != "" {
+		t.Errorf("Expected no reply_to_id for a message that isn't a reply, got %q", data.ReplyToId)
+	}
+
+	message.ReplyToId = &parentId
+
+	if data := newMessageData(message, "user_one", "hi"); data.ReplyToId != parentId.Hex() {
+		t.Errorf("Expected reply_to_id %s, got %q", parentId.Hex(), data.ReplyToId)
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"chat_app/utils"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// Presence statuses. A user is online while any device is, away while every device is away
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// typingInterval -> typing.start is forwarded at most once per interval and room for a connection.
+// Clients keep sending it while the user types, and drop the indicator after a few intervals without one
+const typingInterval = 3 * time.Second
+
+// TypingData -> Data of typing.start and typing.stop, set by the server
+type TypingData struct {
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// PresenceData -> Data of presence. Clients only send the status. LastSeenAt is set when the user went
+// offline, unless they hide it
+type PresenceData struct {
+	UserId     string     `json:"user_id,omitempty"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// UserStatus -> Status derived from the devices of the user connected to this instance
+func (ws *WebSocketManager) UserStatus(userId string) string {
+	ws.ConnMutex.RLock()
+	defer ws.ConnMutex.RUnlock()
+
+	return ws.userStatus(userId)
+}
+
+// SetStatus -> Changes the status of one device. Returns the status of the user before and after
+func (ws *WebSocketManager) SetStatus(wsConn *WsConnection, status string) (string, string) {
+	ws.ConnMutex.Lock()
+	defer ws.ConnMutex.Unlock()
+
+	before := ws.userStatus(wsConn.UserId)
+	wsConn.status = status
+
+	return before, ws.userStatus(wsConn.UserId)
+}
+
+// ConnectionStatus -> Status of one device
+func (ws *WebSocketManager) ConnectionStatus(wsConn *WsConnection) string {
+	ws.ConnMutex.RLock()
+	defer ws.ConnMutex.RUnlock()
+
+	return wsConn.status
+}
+
+// ConnectionRooms -> Copy of the rooms the connection is subscribed to
+func (ws *WebSocketManager) ConnectionRooms(wsConn *WsConnection) []Room {
+	ws.ConnMutex.RLock()
+	defer ws.ConnMutex.RUnlock()
+
+	rooms := make([]Room, 0, len(wsConn.rooms))
+	for _, room := range wsConn.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+func (ws *WebSocketManager) userStatus(userId string) string {
+	devices := ws.Connections[userId]
+	if len(devices) == 0 {
+		return StatusOffline
+	}
+
+	for _, wsConn := range devices {
+		if wsConn.status == StatusOnline {
+			return StatusOnline
+		}
+	}
+
+	return StatusAway
+}
+
+// handleTyping -> Forwarded to the other connections of the room, never stored. A stop is only forwarded
+// after a start was
+func (handler *Handler) handleTyping(wsConn *WsConnection, envelope *Envelope) {
+	room, ok := handler.eventRoom(wsConn, envelope)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	startedAt, isTyping := wsConn.typingAt[room.Id]
+
+	switch envelope.Type {
+	case EventTypingStart:
+		if isTyping && now.Sub(startedAt) < typingInterval {
+			return
+		}
+
+		wsConn.typingAt[room.Id] = now
+	case EventTypingStop:
+		if !isTyping {
+			return
+		}
+
+		delete(wsConn.typingAt, room.Id)
+	}
+
+	handler.publish(room.Id, wsConn.Id, envelope.Type, TypingData{UserId: wsConn.UserId, Username: wsConn.Username})
+}
+
+// handlePresence -> The client marks its device online or away. The rooms of the device are told when the
+// status of the user changes
+func (handler *Handler) handlePresence(wsConn *WsConnection, envelope *Envelope) {
+	var data PresenceData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, "", errCodeInvalidData, "data of presence is invalid")
+		return
+	}
+
+	if data.Status != StatusOnline && data.Status != StatusAway {
+		wsConn.sendError(envelope.Id, "", errCodeInvalidData, "status must be online or away")
+		return
+	}
+
+	before, after := handler.WebSocket.SetStatus(wsConn, data.Status)
+	if before == after {
+		return
+	}
+
+	handler.announcePresence(handler.WebSocket.ConnectionRooms(wsConn), PresenceData{
+		UserId: wsConn.UserId,
+		Status: after,
+	})
+}
+
+// announcePresence -> Chat partners and group members get the status in each room they share with the user
+func (handler *Handler) announcePresence(rooms []Room, data PresenceData) {
+	for _, room := range rooms {
+		handler.publish(room.Id, "", EventPresence, data)
+	}
+}
+
+// announceDisconnect -> Called once the connection is unregistered. The last device going stores last seen
+// and announces offline, the last online one going announces away.
+// Devices connected to other instances aren't counted
+func (handler *Handler) announceDisconnect(wsConn *WsConnection) {
+	status := handler.WebSocket.UserStatus(wsConn.UserId)
+	if status == StatusOnline {
+		return
+	}
+
+	// the closed connection keeps its last status
+	wasOnline := handler.WebSocket.ConnectionStatus(wsConn) == StatusOnline
+	if status == StatusAway && !wasOnline {
+		return
+	}
+
+	userId, errResp := utils.ToObjectId(wsConn.UserId)
+	if errResp != nil {
+		slog.Error("announcing presence", "error", errResp.Detail, "user_id", wsConn.UserId)
+		return
+	}
+
+	data := PresenceData{UserId: wsConn.UserId, Status: status}
+
+	if status == StatusOffline {
+		user, err := handler.Models.User.SetLastSeen(userId, time.Now())
+		if err != nil {
+			slog.Error("storing last seen", "error", err, "user_id", wsConn.UserId)
+			return
+		}
+
+		// the account was deleted
+		if user == nil {
+			return
+		}
+
+		if !user.HideLastSeen {
+			data.LastSeenAt = user.LastSeenAt
+		}
+	}
+
+	rooms, err := handler.userRooms(userId)
+	if err != nil {
+		slog.Error("loading rooms to announce presence", "error", err, "user_id", wsConn.UserId)
+		return
+	}
+
+	handler.announcePresence(rooms, data)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketManager_UserStatus(t *testing.T) {
+	ws := WebsocketInit()
+	chat := testRoom(RoomChat)
+
+	if status := ws.UserStatus("user1"); status != StatusOffline {
+		t.Fatalf("Expected %s without devices, got %s", StatusOffline, status)
+	}
+
+	phone := NewWsConnection(createTestConnection(t), "user1", "phone", "user_one")
+	laptop := NewWsConnection(createTestConnection(t), "user1", "laptop", "user_one")
+	ws.Register(phone, []Room{chat})
+	ws.Register(laptop, []Room{chat})
+
+	if before, after := ws.SetStatus(phone, StatusAway); before != StatusOnline || after != StatusOnline {
+		t.Errorf("One away device of two should keep the user online, got %s -> %s", before, after)
+	}
+
+	if before, after := ws.SetStatus(laptop, StatusAway); before != StatusOnline || after != StatusAway {
+		t.Errorf("Expected online -> away once every device is away, got %s -> %s", before, after)
+	}
+
+	ws.Unregister(laptop)
+	if status := ws.UserStatus("user1"); status != StatusAway {
+		t.Errorf("Expected %s, got %s", StatusAway, status)
+	}
+
+	ws.Unregister(phone)
+	if status := ws.UserStatus("user1"); status != StatusOffline {
+		t.Errorf("Expected %s after the last device left, got %s", StatusOffline, status)
+	}
+}
+
+func TestTypingAndPresenceEvents(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+	chat := testRoom(RoomChat)
+
+	client := serveTestWebsocket(t, handler, "user1", []Room{chat})
+
+	partner, received := createReadingTestConnection(t)
+	handler.WebSocket.Register(NewWsConnection(partner, "user2", "phone", "user_two"), []Room{chat})
+
+	send := func(t *testing.T, frame string) {
+		t.Helper()
+
+		if err := client.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+
+	readEvent := func(t *testing.T, wantType string) Envelope {
+		t.Helper()
+
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(readTestMessage(t, received)), &envelope); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+
+		if envelope.Type != wantType || envelope.Room != chat.Id {
+			t.Fatalf("Expected %s for %s, got %+v", wantType, chat.Id, envelope)
+		}
+
+		return envelope
+	}
+
+	expectNothing := func(t *testing.T) {
+		t.Helper()
+
+		select {
+		case message := <-received:
+			t.Errorf("Expected nothing to be forwarded, got %q", message)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	t.Run("Typing Is Rate Limited", func(t *testing.T) {
+		send(t, `{"type":"typing.start","room":"`+chat.Id+`"}`)
+		send(t, `{"type":"typing.start","room":"`+chat.Id+`"}`)
+
+		var data TypingData
+		if err := json.Unmarshal(readEvent(t, EventTypingStart).Data, &data); err != nil {
+			t.Fatalf("Failed to decode data: %v", err)
+		}
+
+		if data.UserId != "user1" || data.Username != "user1" {
+			t.Errorf("Expected the typing user from the connection, got %+v", data)
+		}
+
+		expectNothing(t)
+	})
+
+	t.Run("Stop Only Follows A Start", func(t *testing.T) {
+		send(t, `{"type":"typing.stop","room":"`+chat.Id+`"}`)
+		readEvent(t, EventTypingStop)
+
+		send(t, `{"type":"typing.stop","room":"`+chat.Id+`"}`)
+		expectNothing(t)
+	})
+
+	t.Run("Presence Changes Are Announced Once", func(t *testing.T) {
+		send(t, `{"type":"presence","data":{"status":"away"}}`)
+
+		var data PresenceData
+		if err := json.Unmarshal(readEvent(t, EventPresence).Data, &data); err != nil {
+			t.Fatalf("Failed to decode data: %v", err)
+		}
+
+		if data.UserId != "user1" || data.Status != StatusAway || data.LastSeenAt != nil {
+			t.Errorf("Expected user1 away, got %+v", data)
+		}
+
+		// the user's own devices are in the room as well
+		if envelope := readTestEnvelope(t, client); envelope.Type != EventPresence {
+			t.Errorf("Expected the sending device to get %s, got %s", EventPresence, envelope.Type)
+		}
+
+		send(t, `{"type":"presence","data":{"status":"away"}}`)
+		expectNothing(t)
+	})
+
+	t.Run("Invalid Presence Is Refused", func(t *testing.T) {
+		send(t, `{"type":"presence","id":"p1","data":{"status":"offline"}}`)
+
+		envelope := readTestEnvelope(t, client)
+		if envelope.Type != EventError || envelope.Id != "p1" {
+			t.Fatalf("Expected an error frame for p1, got %s %q", envelope.Type, envelope.Id)
+		}
+
+		expectNothing(t)
+	})
+}
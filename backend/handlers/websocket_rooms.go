@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Room kinds, a room id is "<kind>:<hex id>"
+const (
+	RoomChat       = "chat"
+	RoomSecretChat = "secret_chat"
+	RoomGroup      = "group"
+)
+
+// roomsPageLimit -> Page size when loading the rooms of a connecting user
+const roomsPageLimit = 100
+
+// Room -> A chat, secret chat or group a connection can be subscribed to
+type Room struct {
+	Id       string
+	Kind     string
+	ObjectId primitive.ObjectID
+	// ReceiverId is the other participant of a chat or secret chat
+	ReceiverId primitive.ObjectID
+	IsSecret   bool
+}
+
+func roomId(kind string, objectId primitive.ObjectID) string {
+	return fmt.Sprintf("%s:%s", kind, objectId.Hex())
+}
+
+// ChatRoom -> Room of a 1:1 chat, seen from userId
+func ChatRoom(chat *models.Chat, userId primitive.ObjectID) Room {
+	return Room{
+		Id:         roomId(RoomChat, chat.Id),
+		Kind:       RoomChat,
+		ObjectId:   chat.Id,
+		ReceiverId: getOtherUserId(chat.Participants, userId),
+	}
+}
+
+// SecretChatRoom -> Room of a secret chat, seen from userId
+func SecretChatRoom(chat *models.SecretChat, userId primitive.ObjectID) Room {
+	receiverId := chat.User1
+	if receiverId == userId {
+		receiverId = chat.User2
+	}
+
+	return Room{
+		Id:         roomId(RoomSecretChat, chat.Id),
+		Kind:       RoomSecretChat,
+		ObjectId:   chat.Id,
+		ReceiverId: receiverId,
+		IsSecret:   true,
+	}
+}
+
+// GroupRoom -> Room of a group or secret group
+func GroupRoom(group *models.Group) Room {
+	return Room{
+		Id:       roomId(RoomGroup, group.Id),
+		Kind:     RoomGroup,
+		ObjectId: group.Id,
+		IsSecret: group.IsSecret,
+	}
+}
+
+// RoomOfMessage -> Needs chat_id, group_id and is_secret in the projection. ReceiverId is not set
+func RoomOfMessage(message *models.Message) Room {
+	if !message.GroupId.IsZero() {
+		return GroupRoom(&models.Group{Id: message.GroupId, IsSecret: message.IsSecret})
+	}
+
+	kind := RoomChat
+	if message.IsSecret {
+		kind = RoomSecretChat
+	}
+
+	return Room{
+		Id:       roomId(kind, message.ChatId),
+		Kind:     kind,
+		ObjectId: message.ChatId,
+		IsSecret: message.IsSecret,
+	}
+}
+
+// MessageFilter -> Narrows a message query to the room
+func (room Room) MessageFilter() bson.M {
+	if room.Kind == RoomGroup {
+		return bson.M{"group_id": room.ObjectId}
+	}
+
+	return bson.M{"chat_id": room.ObjectId}
+}
+
+// userRooms -> Every chat, secret chat and group the user belongs to
+func (handler *Handler) userRooms(userId primitive.ObjectID) ([]Room, error) {
+	var rooms []Room
+
+	projection := bson.M{"_id": 1, "participants": 1}
+	chatFilter := userChatsFilter(userId)
+
+	for page := int64(1); ; page++ {
+		chats, err := handler.Models.Chat.GetAll(chatFilter, projection, page, roomsPageLimit)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		for _, chat := range chats {
+			rooms = append(rooms, ChatRoom(&chat, userId))
+		}
+
+		if len(chats) < roomsPageLimit {
+			break
+		}
+	}
+
+	projection = bson.M{"_id": 1, "user_1": 1, "user_2": 1}
+	secretChatFilter := userSecretChatsFilter(userId)
+
+	for page := int64(1); ; page++ {
+		chats, err := handler.Models.SecretChat.GetAll(secretChatFilter, projection, page, roomsPageLimit)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		for _, chat := range chats {
+			rooms = append(rooms, SecretChatRoom(&chat, userId))
+		}
+
+		if len(chats) < roomsPageLimit {
+			break
+		}
+	}
+
+	projection = bson.M{"_id": 1, "is_secret": 1}
+	groupFilter := userGroupsFilter(userId)
+
+	for page := int64(1); ; page++ {
+		groups, err := handler.Models.Group.GetAll(groupFilter, projection, page, roomsPageLimit)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			rooms = append(rooms, GroupRoom(&group))
+		}
+
+		if len(groups) < roomsPageLimit {
+			break
+		}
+	}
+
+	return rooms, nil
+}
+
+// userChatsFilter -> Chats the user takes part in
+func userChatsFilter(userId primitive.ObjectID) bson.M {
+	return bson.M{"participants": userId}
+}
+
+// userSecretChatsFilter -> Secret chats the user takes part in
+func userSecretChatsFilter(userId primitive.ObjectID) bson.M {
+	return bson.M{
+		"$or": []bson.M{
+			{"user_1": userId},
+			{"user_2": userId},
+		},
+	}
+}
+
+// userGroupsFilter -> Groups the user is a member of and not banned from
+func userGroupsFilter(userId primitive.ObjectID) bson.M {
+	return bson.M{
+		"members":        userId,
+		"banned_members": bson.M{"$ne": userId},
+	}
+}
+
+// sharesRoom -> Checks whether both users are in the same chat, secret chat or group, using the rooms
+// userRooms subscribes them to
+func (handler *Handler) sharesRoom(userId, otherId primitive.ObjectID) (bool, error) {
+	projection := bson.M{"_id": 1}
+
+	both := func(filter func(primitive.ObjectID) bson.M) bson.M {
+		return bson.M{"$and": []bson.M{filter(userId), filter(otherId)}}
+	}
+
+	lookups := []func() error{
+		func() error {
+			_, err := handler.Models.Chat.Get(both(userChatsFilter), projection)
+			return err
+		},
+		func() error {
+			_, err := handler.Models.SecretChat.Get(both(userSecretChatsFilter), projection)
+			return err
+		},
+		func() error {
+			_, err := handler.Models.Group.Get(both(userGroupsFilter), projection)
+			return err
+		},
+	}
+
+	for _, lookup := range lookups {
+		err := lookup()
+		if err == nil {
+			return true, nil
+		}
+
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// isRoomMember -> Checks the membership against the database, the subscription of a connection can be
+// older than a kick, a ban or a deleted chat
+func (handler *Handler) isRoomMember(room Room, userId primitive.ObjectID) (bool, error) {
+	projection := bson.M{"_id": 1}
+
+	var err error
+	switch room.Kind {
+	case RoomChat:
+		filter := bson.M{"_id": room.ObjectId, "participants": userId}
+		_, err = handler.Models.Chat.Get(filter, projection)
+	case RoomSecretChat:
+		filter := bson.M{
+			"_id": room.ObjectId,
+			"$or": []bson.M{
+				{"user_1": userId},
+				{"user_2": userId},
+			},
+		}
+		_, err = handler.Models.SecretChat.Get(filter, projection)
+	case RoomGroup:
+		filter := bson.M{
+			"_id":            room.ObjectId,
+			"members":        userId,
+			"banned_members": bson.M{"$ne": userId},
+		}
+		_, err = handler.Models.Group.Get(filter, projection)
+	default:
+		return false, fmt.Errorf("unknown room kind: %s", room.Kind)
+	}
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// sentMessage -> The message the sender already stored with clientMessageId, nil when there is none
+func (handler *Handler) sentMessage(senderId primitive.ObjectID, clientMessageId string) (*models.Message, error) {
+	filter := bson.M{
+		"sender_id":         senderId,
+		"client_message_id": clientMessageId,
+	}
+
+	message, err := handler.Models.Message.Get(filter, bson.M{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// storeRoomMsgToDB -> Stores a message.send as a chat or group message, depending on the room
+func (handler *Handler) storeRoomMsgToDB(room Room, senderId string, input MessageSendData,
+	replyToId primitive.ObjectID) (*models.Message, error) {
+	switch room.Kind {
+	case RoomChat, RoomSecretChat:
+		return handler.storeChatMsgToDB(room.ObjectId.Hex(), senderId, room.ReceiverId.Hex(), input.ContentType,
+			input.ContentAddress, input.Content, input.ClientMessageId, replyToId, room.IsSecret)
+	case RoomGroup:
+		return handler.storeGroupMsgToDB(room.ObjectId.Hex(), senderId, input.ContentType, input.ContentAddress,
+			input.Content, input.ClientMessageId, replyToId, room.IsSecret)
+	}
+
+	return nil, fmt.Errorf("unknown room kind: %s", room.Kind)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"chat_app/utils"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// syncLimit -> Messages sent for one sync, kept below sendQueueSize. The client syncs again with the cursors
+// of sync.done while HasMore is set
+const syncLimit = 100
+
+// syncGapGrace -> How long a sync waits for a missing seq. Seqs are taken before the insert, so a message
+// can be stored after ones with a higher seq. Longer than the insert timeout of MessageModel.Create, a seq
+// still missing by then was never stored
+const syncGapGrace = 15 * time.Second
+
+// SyncData -> Data of sync, the last seq the client has of each room it wants to catch up on
+type SyncData struct {
+	Cursors map[string]int64 `json:"cursors"`
+}
+
+// SyncDoneData -> Data of sync.done, the cursors to send with the next sync. Pending is set when a room
+// stopped before a message that is still being stored, the client syncs again shortly
+type SyncDoneData struct {
+	Cursors map[string]int64 `json:"cursors"`
+	HasMore bool             `json:"has_more"`
+	Pending bool             `json:"pending"`
+}
+
+// handleSync -> Sends the messages after each cursor as message.new frames carrying the id of the sync,
+// room by room in seq order, then sync.done. Rooms the connection isn't in get an error frame
+func (handler *Handler) handleSync(wsConn *WsConnection, envelope *Envelope) {
+	var data SyncData
+	if err := json.Unmarshal(envelope.Data, &data); err != nil {
+		wsConn.sendError(envelope.Id, "", errCodeInvalidData, "data of sync is invalid")
+		return
+	}
+
+	done := SyncDoneData{Cursors: make(map[string]int64, len(data.Cursors))}
+
+	rooms := make([]string, 0, len(data.Cursors))
+	for room, cursor := range data.Cursors {
+		rooms = append(rooms, room)
+		done.Cursors[room] = max(cursor, 0)
+	}
+
+	slices.Sort(rooms)
+
+	userId, errResp := utils.ToObjectId(wsConn.UserId)
+	if errResp != nil {
+		wsConn.sendError(envelope.Id, "", errCodeInternal, "user id is invalid")
+		return
+	}
+
+	remaining := int64(syncLimit)
+
+	for _, roomId := range rooms {
+		room, subscribed := handler.WebSocket.SubscribedRoom(wsConn, roomId)
+		if !subscribed {
+			delete(done.Cursors, roomId)
+			wsConn.sendError(envelope.Id, roomId, errCodeUnknownRoom, "you are not in this room")
+			continue
+		}
+
+		if remaining == 0 {
+			done.HasMore = true
+			continue
+		}
+
+		if !handler.authorizeRoom(wsConn, envelope, room, userId) {
+			delete(done.Cursors, roomId)
+			continue
+		}
+
+		// one more than needed tells whether the room has more
+		messages, err := handler.Models.Message.GetAfterSeq(room.MessageFilter(), done.Cursors[roomId], remaining+1)
+		if err != nil {
+			slog.Error("fetching messages to sync", "error", err, "room", roomId, "user_id", wsConn.UserId)
+			wsConn.sendError(envelope.Id, roomId, errCodeInternal, "failed to fetch the messages")
+			continue
+		}
+
+		if int64(len(messages)) > remaining {
+			messages = messages[:remaining]
+			done.HasMore = true
+		}
+
+		if held := untilGap(done.Cursors[roomId], messages, time.Now()); len(held) < len(messages) {
+			messages = held
+			done.Pending = true
+		}
+
+		remaining -= int64(len(messages))
+
+		for _, message := range handler.syncMessages(wsConn, userId, messages) {
+			wsConn.sendEvent(EventMessageNew, envelope.Id, room.Id, message)
+		}
+
+		if len(messages) > 0 {
+			done.Cursors[roomId] = messages[len(messages)-1].Seq
+		}
+	}
+
+	wsConn.sendEvent(EventSyncDone, envelope.Id, "", done)
+}
+
+// untilGap -> The messages, in seq order, up to the first missing seq after cursor. A gap older than
+// syncGapGrace is skipped, its message failed to be stored
+func untilGap(cursor int64, messages []models.Message, now time.Time) []models.Message {
+	next := cursor + 1
+
+	for idx, message := range messages {
+		if message.Seq > next && now.Sub(message.CreatedAt) < syncGapGrace {
+			return messages[:idx]
+		}
+
+		next = message.Seq + 1
+	}
+
+	return messages
+}
+
+// syncMessages -> The messages as message.new data. Like the listings, messages the user deleted for
+// themselves are left out
+func (handler *Handler) syncMessages(wsConn *WsConnection, userId primitive.ObjectID,
+	messages []models.Message) []MessageNewData {
+	usernames := handler.usernames(messages)
+
+	result := make([]MessageNewData, 0, len(messages))
+	for idx := range messages {
+		message := &messages[idx]
+		if message.IsDeletedForSender && message.SenderId == userId {
+			continue
+		}
+
+		content, err := handler.decryptMessage(message)
+		if err != nil {
+			slog.Error("decrypting message to sync", "error", err, "message_id", message.Id, "user_id", wsConn.UserId)
+			continue
+		}
+
+		result = append(result, newMessageData(message, usernames[message.SenderId], content))
+	}
+
+	return result
+}
+
+// usernames -> Usernames of the senders of the messages, missing ones are left out
+func (handler *Handler) usernames(messages []models.Message) map[primitive.ObjectID]string {
+	var senderIds []primitive.ObjectID
+	for _, message := range messages {
+		if !slices.Contains(senderIds, message.SenderId) {
+			senderIds = append(senderIds, message.SenderId)
+		}
+	}
+
+	usernames := make(map[primitive.ObjectID]string, len(senderIds))
+	if len(senderIds) == 0 {
+		return usernames
+	}
+
+	filter := bson.M{
+		"_id": bson.M{
+			"$in": senderIds,
+		},
+	}
+
+	users, err := handler.Models.User.GetAll(filter, bson.M{"_id": 1, "username": 1}, 1, int64(len(senderIds)))
+	if err != nil {
+		slog.Error("fetching usernames", "error", err)
+		return usernames
+	}
+
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+
+	return usernames
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"chat_app/database/models"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSync(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+	client := serveTestWebsocket(t, handler, primitive.NewObjectID().Hex(), nil)
+
+	send := func(t *testing.T, frame string) {
+		t.Helper()
+
+		if err := client.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+
+	readDone := func(t *testing.T, id string) SyncDoneData {
+		t.Helper()
+
+		envelope := readTestEnvelope(t, client)
+		if envelope.Type != EventSyncDone || envelope.Id != id {
+			t.Fatalf("Expected %s %q, got %s %q", EventSyncDone, id, envelope.Type, envelope.Id)
+		}
+
+		var data SyncDoneData
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			t.Fatalf("Failed to decode data: %v", err)
+		}
+
+		return data
+	}
+
+	t.Run("Nothing To Sync", func(t *testing.T) {
+		send(t, `{"type":"sync","id":"s1","data":{"cursors":{}}}`)
+
+		if data := readDone(t, "s1"); len(data.Cursors) != 0 || data.HasMore {
+			t.Errorf("Expected an empty sync.done, got %+v", data)
+		}
+	})
+
+	t.Run("Invalid Data", func(t *testing.T) {
+		send(t, `{"type":"sync","id":"s2","data":{"cursors":[1]}}`)
+
+		envelope := readTestEnvelope(t, client)
+		if envelope.Type != EventError || envelope.Id != "s2" {
+			t.Errorf("Expected %s %q, got %s %q", EventError, "s2", envelope.Type, envelope.Id)
+		}
+	})
+
+	t.Run("Room Of Someone Else", func(t *testing.T) {
+		other := testRoom(RoomGroup)
+		send(t, `{"type":"sync","id":"s3","data":{"cursors":{"`+other.Id+`":5}}}`)
+
+		envelope := readTestEnvelope(t, client)
+
+		var errData ErrorData
+		json.Unmarshal(envelope.Data, &errData)
+
+		if envelope.Type != EventError || envelope.Room != other.Id || errData.Code != errCodeUnknownRoom {
+			t.Errorf("Expected %s %s for %s, got %+v", EventError, errCodeUnknownRoom, other.Id, envelope)
+		}
+
+		// the room is left out of the cursors to sync with next
+		if data := readDone(t, "s3"); len(data.Cursors) != 0 {
+			t.Errorf("Expected no cursors, got %v", data.Cursors)
+		}
+	})
+}
+
+func TestUntilGap(t *testing.T) {
+	now := time.Now()
+
+	messages := func(createdAt time.Time, seqs ...int64) []models.Message {
+		result := make([]models.Message, 0, len(seqs))
+		for _, seq := range seqs {
+			result = append(result, models.Message{Seq: seq, CreatedAt: createdAt})
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		cursor   int64
+		messages []models.Message
+		want     []int64
+	}{
+		{name: "No Gap", cursor: 3, messages: messages(now, 4, 5, 6), want: []int64{4, 5, 6}},
+		{name: "Nothing After The Cursor", cursor: 3, messages: nil, want: []int64{}},
+		// 5 was taken first but 6 was stored first, the cursor waits at 4 for 5
+		{name: "Stored Out Of Order", cursor: 3, messages: messages(now, 4, 6), want: []int64{4}},
+		{name: "Gap Right After The Cursor", cursor: 3, messages: messages(now, 5, 6), want: []int64{}},
+		{name: "Gap Past The Grace Period", cursor: 3, messages: messages(now.Add(-2*syncGapGrace), 4, 6),
+			want: []int64{4, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int64{}
+			for _, message := range untilGap(tt.cursor, tt.messages, now) {
+				got = append(got, message.Seq)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected seqs %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
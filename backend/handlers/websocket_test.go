@@ -1,292 +1,600 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func TestWebSocketManager_ConnectionLimits(t *testing.T) {
+func TestWebSocketManager_Multiplexing(t *testing.T) {
 	ws := WebsocketInit()
 
-	// Test chat connection limit (max 2 users)
-	t.Run("Chat Connection Limit", func(t *testing.T) {
-		// Create test connections
-		conn1 := createTestConnection(t)
-		conn2 := createTestConnection(t)
-		conn3 := createTestConnection(t)
+	chat := testRoom(RoomChat)
+	secretChat := testRoom(RoomSecretChat)
+	group := testRoom(RoomGroup)
 
-		// Add first two users (should succeed)
-		wsConn1 := &WsConnection{Conn: conn1}
-		wsConn2 := &WsConnection{Conn: conn2}
+	t.Run("One Connection Subscribes To All Rooms", func(t *testing.T) {
+		conn := createTestConnection(t)
+		defer conn.Close()
 
-		wsConn1.AddChat("chat1", "user1", ws)
-		wsConn2.AddChat("chat1", "user2", ws)
+		wsConn := NewWsConnection(conn, "user1", "phone", "user_one")
+		ws.Register(wsConn, []Room{chat, secretChat, group})
 
-		// Verify both users are connected
 		if !ws.IsUserConnected("user1") {
 			t.Error("user1 should be connected")
 		}
-		if !ws.IsUserConnected("user2") {
-			t.Error("user2 should be connected")
-		}
 
-		// Try to add third user (should fail due to limit)
-		wsConn3 := &WsConnection{Conn: conn3}
-		wsConn3.AddChat("chat1", "user3", ws)
+		for _, room := range []Room{chat, secretChat, group} {
+			if !ws.IsUserInRoom(room.Id, "user1") {
+				t.Errorf("user1 should be in %s", room.Id)
+			}
 
-		// Verify third user is not connected
-		if ws.IsUserConnected("user3") {
-			t.Error("user3 should not be connected due to limit")
+			if _, subscribed := ws.SubscribedRoom(wsConn, room.Id); !subscribed {
+				t.Errorf("connection should be subscribed to %s", room.Id)
+			}
 		}
 
-		// Cleanup
-		conn1.Close()
-		conn2.Close()
-		conn3.Close()
+		if _, subscribed := ws.SubscribedRoom(wsConn, testRoom(RoomChat).Id); subscribed {
+			t.Error("connection should not be subscribed to another chat")
+		}
 	})
 
-	// Test group connection limit (max 100 users)
-	t.Run("Group Connection Limit", func(t *testing.T) {
-		// Create test connections for 101 users
-		connections := make([]*websocket.Conn, 101)
-		for i := 0; i < 101; i++ {
-			connections[i] = createTestConnection(t)
-		}
+	t.Run("Reconnecting Device Replaces Its Old Connection", func(t *testing.T) {
+		conn := createTestConnection(t)
+		defer conn.Close()
 
-		// Add first 100 users (should succeed)
-		for i := 0; i < 100; i++ {
-			wsConn := &WsConnection{Conn: connections[i]}
-			wsConn.AddGroup("group1", fmt.Sprintf("user%d", i), ws)
-		}
+		oldConn := ws.Connections["user1"]["phone"]
 
-		// Verify first 100 users are connected
-		for i := 0; i < 100; i++ {
-			if !ws.IsUserConnected(fmt.Sprintf("user%d", i)) {
-				t.Errorf("user%d should be connected", i)
-			}
-		}
+		wsConn := NewWsConnection(conn, "user1", "phone", "user_one")
+		ws.Register(wsConn, []Room{group})
 
-		// Try to add 101st user (should fail due to limit)
-		wsConn101 := &WsConnection{Conn: connections[100]}
-		wsConn101.AddGroup("group1", "user101", ws)
+		if ws.IsUserInRoom(chat.Id, "user1") {
+			t.Error("user1 should not be in the chat of the old connection")
+		}
 
-		// Verify 101st user is not connected
-		if ws.IsUserConnected("user101") {
-			t.Error("user101 should not be connected due to limit")
+		if !ws.IsUserInRoom(group.Id, "user1") {
+			t.Error("user1 should be in the group")
 		}
 
-		// Cleanup
-		for _, conn := range connections {
-			conn.Close()
+		// the old read loop ends and unregisters, which must not drop the new connection
+		ws.Unregister(oldConn)
+
+		if !ws.IsUserConnected("user1") || !ws.IsUserInRoom(group.Id, "user1") {
+			t.Error("unregistering the old connection removed the new one")
 		}
 	})
 }
 
-func TestWebSocketManager_OneConnectionPerUser(t *testing.T) {
+func TestWebSocketManager_MultipleDevices(t *testing.T) {
 	ws := WebsocketInit()
+	chat := testRoom(RoomChat)
+
+	phone := NewWsConnection(createTestConnection(t), "user1", "phone", "user_one")
+	laptop := NewWsConnection(createTestConnection(t), "user1", "laptop", "user_one")
 
-	t.Run("User Can Only Be In One Room", func(t *testing.T) {
-		// Create test connections
-		conn1 := createTestConnection(t)
-		conn2 := createTestConnection(t)
+	if err := ws.Register(phone, []Room{chat}); err != nil {
+		t.Fatal(err)
+	}
 
-		// Add user to chat
-		wsConn1 := &WsConnection{Conn: conn1}
-		wsConn1.AddChat("chat1", "user1", ws)
+	if err := ws.Register(laptop, []Room{chat}); err != nil {
+		t.Fatal(err)
+	}
 
-		// Verify user is in chat
-		if !ws.IsUserConnected("user1") {
-			t.Error("user1 should be connected to chat")
-		}
+	if devices := ws.GetUserDevices("user1"); len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %v", devices)
+	}
+
+	if len(ws.GetRoomConnections(chat.Id)) != 2 {
+		t.Error("both devices should be subscribed to the chat")
+	}
 
-		// Add same user to group (should disconnect from chat)
-		wsConn2 := &WsConnection{Conn: conn2}
-		wsConn2.AddGroup("group1", "user1", ws)
+	// a new chat reaches every device
+	group := testRoom(RoomGroup)
+	ws.JoinRoom("user1", group)
 
-		// Verify user is now in group, not chat
-		if !ws.IsUserConnected("user1") {
-			t.Error("user1 should be connected to group")
+	for _, wsConn := range []*WsConnection{phone, laptop} {
+		if _, subscribed := ws.SubscribedRoom(wsConn, group.Id); !subscribed {
+			t.Errorf("%s should be subscribed to the group", wsConn.DeviceId)
 		}
+	}
+
+	ws.Unregister(phone)
+
+	if !ws.IsUserConnected("user1") || !ws.IsUserInRoom(chat.Id, "user1") {
+		t.Error("the laptop should stay connected when the phone disconnects")
+	}
+
+	if !ws.ForceDisconnectDevice("user1", "laptop") || ws.IsUserConnected("user1") {
+		t.Error("user1 should be disconnected after the last device")
+	}
+}
 
-		// Verify user is not in chat anymore
-		if ws.IsUserConnectedToChat("chat1", "user1") {
-			t.Error("user1 should not be in chat anymore")
+func TestWebSocketManager_DeviceLimit(t *testing.T) {
+	ws := WebsocketInit()
+
+	for i := 0; i < maxDevicesPerUser; i++ {
+		wsConn := NewWsConnection(createTestConnection(t), "user1", fmt.Sprintf("device%d", i), "user_one")
+		if err := ws.Register(wsConn, nil); err != nil {
+			t.Fatalf("device %d: %v", i, err)
 		}
+	}
 
-		// Cleanup
-		conn1.Close()
-		conn2.Close()
-	})
+	extra := NewWsConnection(createTestConnection(t), "user1", "one-too-many", "user_one")
+	if err := ws.Register(extra, nil); err != errTooManyDevices {
+		t.Errorf("Expected errTooManyDevices, got %v", err)
+	}
+
+	// a known device can always reconnect
+	again := NewWsConnection(createTestConnection(t), "user1", "device0", "user_one")
+	if err := ws.Register(again, nil); err != nil {
+		t.Errorf("reconnecting device0 failed: %v", err)
+	}
+}
+
+func TestDeviceIdFromRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		header   string
+		expected string
+		wantErr  bool
+	}{
+		{"From query", "?device_id=phone-1", "", "phone-1", false},
+		{"Header wins", "?device_id=phone-1", "laptop_2", "laptop_2", false},
+		{"Invalid characters", "?device_id=a/b", "", "", true},
+		{"Too long", "?device_id=" + strings.Repeat("a", 65), "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/websocket"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Device-Id", tt.header)
+			}
+
+			deviceId, err := deviceIdFromRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, got %v", tt.wantErr, err)
+			}
+
+			if deviceId != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, deviceId)
+			}
+		})
+	}
+
+	// clients without a device id get a random one
+	first, _ := deviceIdFromRequest(httptest.NewRequest(http.MethodGet, "/api/websocket", nil))
+	second, _ := deviceIdFromRequest(httptest.NewRequest(http.MethodGet, "/api/websocket", nil))
+	if first == "" || first == second {
+		t.Errorf("Expected distinct generated device ids, got %q and %q", first, second)
+	}
+}
+
+func TestWebSocketManager_JoinAndLeaveRoom(t *testing.T) {
+	ws := WebsocketInit()
+	chat := testRoom(RoomChat)
+
+	conn := createTestConnection(t)
+	defer conn.Close()
+
+	ws.Register(NewWsConnection(conn, "user1", "phone", "user_one"), nil)
+
+	ws.JoinRoom("user1", chat)
+	if !ws.IsUserInRoom(chat.Id, "user1") {
+		t.Error("user1 should be in the chat after joining")
+	}
+
+	// users without a live connection are ignored
+	ws.JoinRoom("user2", chat)
+	if ws.IsUserInRoom(chat.Id, "user2") {
+		t.Error("user2 has no connection and should not be in the chat")
+	}
+
+	if left := ws.LeaveRoom("user1", chat.Id); len(left) != 1 {
+		t.Errorf("Expected 1 connection to leave, got %d", len(left))
+	}
+
+	if ws.IsUserInRoom(chat.Id, "user1") {
+		t.Error("user1 should not be in the chat after leaving")
+	}
+
+	if _, exists := ws.Rooms[chat.Id]; exists {
+		t.Error("empty rooms should be removed")
+	}
+}
+
+func TestWebSocketManager_CloseRoom(t *testing.T) {
+	ws := WebsocketInit()
+	chat := testRoom(RoomChat)
+	group := testRoom(RoomGroup)
+
+	conn1 := createTestConnection(t)
+	conn2 := createTestConnection(t)
+	defer conn1.Close()
+	defer conn2.Close()
+
+	ws.Register(NewWsConnection(conn1, "user1", "phone", "user_one"), []Room{chat, group})
+	ws.Register(NewWsConnection(conn2, "user2", "phone", "user_two"), []Room{chat})
+
+	if closed := ws.CloseRoom(chat.Id); len(closed) != 2 {
+		t.Errorf("Expected 2 connections to be unsubscribed, got %d", len(closed))
+	}
+
+	if ws.IsUserInRoom(chat.Id, "user1") || ws.IsUserInRoom(chat.Id, "user2") {
+		t.Error("nobody should be in a closed room")
+	}
+
+	if !ws.IsUserInRoom(group.Id, "user1") {
+		t.Error("other rooms should stay subscribed")
+	}
+
+	if closed := ws.CloseRoom(chat.Id); len(closed) != 0 {
+		t.Errorf("Expected an empty room to close nothing, got %d", len(closed))
+	}
 }
 
 func TestWebSocketManager_MessageBroadcasting(t *testing.T) {
 	ws := WebsocketInit()
 
-	t.Run("Chat Message Broadcasting", func(t *testing.T) {
-		// Create test connections
-		conn1 := createTestConnection(t)
-		conn2 := createTestConnection(t)
+	chat := testRoom(RoomChat)
+	group := testRoom(RoomGroup)
+
+	conn1, received1 := createReadingTestConnection(t)
+	conn1Laptop, received1Laptop := createReadingTestConnection(t)
+	conn2, received2 := createReadingTestConnection(t)
+	conn3, received3 := createReadingTestConnection(t)
+	defer conn1.Close()
+	defer conn1Laptop.Close()
+	defer conn2.Close()
+	defer conn3.Close()
+
+	sender := NewWsConnection(conn1, "user1", "phone", "user_one")
+	ws.Register(sender, []Room{chat, group})
+	ws.Register(NewWsConnection(conn1Laptop, "user1", "laptop", "user_one"), []Room{chat, group})
+	ws.Register(NewWsConnection(conn2, "user2", "phone", "user_two"), []Room{chat, group})
+	ws.Register(NewWsConnection(conn3, "user3", "phone", "user_three"), []Room{group})
+
+	if err := ws.BroadcastToRoom(chat.Id, sender.Id, []byte("chat message")); err != nil {
+		t.Fatalf("Failed to broadcast message: %v", err)
+	}
 
-		// Add users to chat
-		wsConn1 := &WsConnection{Conn: conn1}
-		wsConn2 := &WsConnection{Conn: conn2}
+	if message := readTestMessage(t, received2); message != "chat message" {
+		t.Errorf("user2 got %q", message)
+	}
 
-		wsConn1.AddChat("chat1", "user1", ws)
-		wsConn2.AddChat("chat1", "user2", ws)
+	// the sender's other devices show their own message too
+	if message := readTestMessage(t, received1Laptop); message != "chat message" {
+		t.Errorf("user1's laptop got %q", message)
+	}
 
-		// Create test message
-		message := ChatMessage{
-			SenderId:       "user1",
-			ReceiverId:     "user2",
-			Content:        "Hello, World!",
-			ContentType:    "text",
-			ContentAddress: "",
-		}
+	if err := ws.BroadcastToRoom(group.Id, sender.Id, []byte("group message")); err != nil {
+		t.Fatalf("Failed to broadcast message: %v", err)
+	}
 
-		messageBytes, _ := json.Marshal(message)
+	// user3 isn't in the chat, so the first message it sees is the group one
+	if message := readTestMessage(t, received3); message != "group message" {
+		t.Errorf("user3 got %q", message)
+	}
 
-		// Broadcast message
-		err := ws.BroadcastToRoom("chat1", "user1", websocket.TextMessage, messageBytes)
-		if err != nil {
-			t.Errorf("Failed to broadcast message: %v", err)
-		}
+	if message := readTestMessage(t, received2); message != "group message" {
+		t.Errorf("user2 got %q", message)
+	}
 
-		// Verify message was sent (in a real test, you'd read from the connection)
-		// For this test, we just verify no error occurred
+	if err := ws.BroadcastToRoom(testRoom(RoomChat).Id, sender.Id, []byte("x")); err == nil {
+		t.Error("broadcasting to a room without connections should fail")
+	}
 
-		// Cleanup
-		conn1.Close()
-		conn2.Close()
-	})
+	select {
+	case message := <-received1:
+		t.Errorf("the sending connection got its own message %q", message)
+	case <-time.After(100 * time.Millisecond):
+	}
 }
 
 func TestWebSocketManager_ConnectionCleanup(t *testing.T) {
 	ws := WebsocketInit()
 
-	t.Run("User Disconnection Cleanup", func(t *testing.T) {
-		// Create test connection
-		conn := createTestConnection(t)
+	chat := testRoom(RoomChat)
+	group := testRoom(RoomGroup)
 
-		// Add user to chat
-		wsConn := &WsConnection{Conn: conn}
-		wsConn.AddChat("chat1", "user1", ws)
+	conn := createTestConnection(t)
+	defer conn.Close()
 
-		// Verify user is connected
-		if !ws.IsUserConnected("user1") {
-			t.Error("user1 should be connected")
-		}
+	wsConn := NewWsConnection(conn, "user1", "phone", "user_one")
+	ws.Register(wsConn, []Room{chat, group})
 
-		// Disconnect user
-		ws.Delete("chat1", "user1")
+	ws.Unregister(wsConn)
 
-		// Verify user is not connected
-		if ws.IsUserConnected("user1") {
-			t.Error("user1 should not be connected after disconnect")
-		}
+	if ws.IsUserConnected("user1") {
+		t.Error("user1 should not be connected after disconnect")
+	}
 
-		// Verify chat room is empty
-		connections := ws.GetChatConnectionsReadOnly("chat1")
-		if connections != nil && len(connections) > 0 {
-			t.Error("chat room should be empty after user disconnect")
-		}
+	if len(ws.Rooms) != 0 {
+		t.Errorf("rooms should be empty after user disconnect, got %d", len(ws.Rooms))
+	}
 
-		// Cleanup
-		conn.Close()
-	})
+	ws.Register(NewWsConnection(createTestConnection(t), "user2", "phone", "user_two"), []Room{chat})
+
+	if !ws.ForceDisconnectUser("user2") {
+		t.Error("user2 should have been disconnected")
+	}
+
+	if ws.IsUserInRoom(chat.Id, "user2") {
+		t.Error("user2 should not be in the chat after a forced disconnect")
+	}
 }
 
 func TestWebSocketManager_Statistics(t *testing.T) {
 	ws := WebsocketInit()
 
-	t.Run("Connection Statistics", func(t *testing.T) {
-		// Create test connections
-		conn1 := createTestConnection(t)
-		conn2 := createTestConnection(t)
-		conn3 := createTestConnection(t)
+	chat1 := testRoom(RoomChat)
+	chat2 := testRoom(RoomChat)
+	secretChat := testRoom(RoomSecretChat)
+	group := testRoom(RoomGroup)
+
+	conn1 := createTestConnection(t)
+	conn1Laptop := createTestConnection(t)
+	conn2 := createTestConnection(t)
+	defer conn1.Close()
+	defer conn1Laptop.Close()
+	defer conn2.Close()
+
+	ws.Register(NewWsConnection(conn1, "user1", "phone", "user_one"), []Room{chat1, chat2, group})
+	ws.Register(NewWsConnection(conn1Laptop, "user1", "laptop", "user_one"), []Room{chat1, chat2, group})
+	ws.Register(NewWsConnection(conn2, "user2", "phone", "user_two"), []Room{chat1, secretChat, group})
+
+	stats := ws.GetConnectionStats()
+
+	expected := map[string]int{
+		"total_users":       2,
+		"total_devices":     3,
+		"total_rooms":       4,
+		"chat_rooms":        2,
+		"secret_chat_rooms": 1,
+		"group_rooms":       1,
+		"subscriptions":     9,
+	}
+
+	for key, value := range expected {
+		if stats[key] != value {
+			t.Errorf("Expected %d %s, got %v", value, key, stats[key])
+		}
+	}
+
+	devices := stats["devices"].([]map[string]any)
+	if len(devices) != 3 {
+		t.Fatalf("Expected 3 device entries, got %d", len(devices))
+	}
 
-		// Add users to different rooms
-		wsConn1 := &WsConnection{Conn: conn1}
-		wsConn2 := &WsConnection{Conn: conn2}
-		wsConn3 := &WsConnection{Conn: conn3}
+	for _, device := range devices {
+		if device["rooms"] != 3 {
+			t.Errorf("Expected 3 rooms for %v/%v, got %v", device["user_id"], device["device_id"], device["rooms"])
+		}
+	}
+}
 
-		wsConn1.AddChat("chat1", "user1", ws)
-		wsConn2.AddChat("chat2", "user2", ws)
-		wsConn3.AddGroup("group1", "user3", ws)
+func TestWsConnection_SendQueueOverflow(t *testing.T) {
+	conn := createTestConnection(t)
+	defer conn.Close()
 
-		// Get statistics
-		stats := ws.GetConnectionStats()
+	// never registered, so nothing drains the queue
+	wsConn := NewWsConnection(conn, "user1", "phone", "user_one")
 
-		// Verify statistics
-		if stats["total_users"] != 3 {
-			t.Errorf("Expected 3 total users, got %v", stats["total_users"])
+	for i := 0; i < sendQueueSize; i++ {
+		if err := wsConn.Send([]byte("frame")); err != nil {
+			t.Fatalf("Frame %d should be queued, got %v", i, err)
 		}
-		if stats["chat_rooms"] != 2 {
-			t.Errorf("Expected 2 chat rooms, got %v", stats["chat_rooms"])
+	}
+
+	if err := wsConn.Send([]byte("one too many")); !errors.Is(err, errSendQueueFull) {
+		t.Fatalf("Expected %v, got %v", errSendQueueFull, err)
+	}
+
+	// the slow connection is closed, later frames are refused right away
+	if err := wsConn.Send([]byte("frame")); !errors.Is(err, errConnClosed) {
+		t.Errorf("Expected %v, got %v", errConnClosed, err)
+	}
+}
+
+func TestWebSocketManager_BroadcastPastStalledClient(t *testing.T) {
+	ws := WebsocketInit()
+	group := testRoom(RoomGroup)
+
+	stalled := createStalledTestConnection(t)
+	wsConn := NewWsConnection(stalled, "user1", "phone", "user_one")
+	if err := ws.Register(wsConn, []Room{group}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	payload := make([]byte, 64<<10)
+
+	// big frames fill the socket buffers until writePump blocks on the client, holding the write lock
+	drained := func() bool {
+		deadline := time.Now().Add(100 * time.Millisecond)
+		for len(wsConn.send) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
 		}
-		if stats["group_rooms"] != 1 {
-			t.Errorf("Expected 1 group room, got %v", stats["group_rooms"])
+
+		return len(wsConn.send) == 0
+	}
+
+	for i := 0; ; i++ {
+		if i == 1000 {
+			t.Fatal("Expected writePump to block on the stalled client")
 		}
-		if stats["chat_connections"] != 2 {
-			t.Errorf("Expected 2 chat connections, got %v", stats["chat_connections"])
+
+		ws.BroadcastToRoom(group.Id, "", payload)
+		if !drained() {
+			break
 		}
-		if stats["group_connections"] != 1 {
-			t.Errorf("Expected 1 group connection, got %v", stats["group_connections"])
+	}
+
+	// the queue overflows now, closing the connection must not wait for the write lock
+	for i := 0; i <= sendQueueSize; i++ {
+		start := time.Now()
+		ws.BroadcastToRoom(group.Id, "", payload)
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Broadcast %d took %s, the stalled client held it up", i, elapsed)
 		}
+	}
 
-		// Cleanup
-		conn1.Close()
-		conn2.Close()
-		conn3.Close()
-	})
+	if err := wsConn.Send(payload); !errors.Is(err, errConnClosed) {
+		t.Errorf("Expected the stalled connection to be closed, got %v", err)
+	}
 }
 
-func TestWebSocketManager_RoomLimits(t *testing.T) {
+func TestWebSocketManager_ConcurrentSenders(t *testing.T) {
 	ws := WebsocketInit()
+	group := testRoom(RoomGroup)
+
+	const (
+		receivers = 5
+		senders   = 20
+		perSender = 10
+	)
 
-	t.Run("Room Limits Monitoring", func(t *testing.T) {
-		// Create test connections for a full chat
-		conn1 := createTestConnection(t)
-		conn2 := createTestConnection(t)
+	counts := make([]*atomic.Int64, receivers)
+	for i := range receivers {
+		conn, count := createCountingTestConnection(t)
+		defer conn.Close()
 
-		// Add users to chat (should reach limit)
-		wsConn1 := &WsConnection{Conn: conn1}
-		wsConn2 := &WsConnection{Conn: conn2}
+		counts[i] = count
+		ws.Register(NewWsConnection(conn, fmt.Sprintf("user%d", i), "phone", ""), []Room{group})
+	}
+
+	var wg sync.WaitGroup
+	for i := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// other users come and go while the group is busy
+			userId := fmt.Sprintf("sender%d", i)
+			conn := createTestConnection(t)
+			defer conn.Close()
+
+			ws.Register(NewWsConnection(conn, userId, "phone", ""), []Room{group})
+			for j := range perSender {
+				if err := ws.BroadcastToRoom(group.Id, userId+"/phone", []byte(fmt.Sprintf("%d-%d", i, j))); err != nil {
+					t.Errorf("Failed to broadcast: %v", err)
+				}
+			}
+			ws.LeaveRoom(userId, group.Id)
+		}()
+	}
+	wg.Wait()
 
-		wsConn1.AddChat("chat1", "user1", ws)
-		wsConn2.AddChat("chat1", "user2", ws)
+	want := int64(senders * perSender)
+	deadline := time.Now().Add(5 * time.Second)
 
-		// Check room limits
-		warnings := ws.CheckRoomLimits()
+	for i, count := range counts {
+		for count.Load() < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
 
-		// Verify chat is at limit
-		found := false
-		for _, warning := range warnings {
-			if warningMap, ok := warning.(map[string]any); ok {
-				if warningMap["room_id"] == "chat1" && warningMap["status"] == "at_limit" {
-					found = true
-					break
+		if got := count.Load(); got != want {
+			t.Errorf("Receiver %d: expected %d frames, got %d", i, want, got)
+		}
+	}
+}
+
+func TestHandleIncomingMsgs_Heartbeat(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+
+	fastHeartbeat := func(wsConn *WsConnection) {
+		wsConn.pingPeriod = 20 * time.Millisecond
+		wsConn.pongWait = 100 * time.Millisecond
+	}
+
+	t.Run("Client Answering Pings Stays Connected", func(t *testing.T) {
+		client := serveTestWebsocketWith(t, handler, "alive", nil, fastHeartbeat)
+
+		// reading lets the client answer pings with pongs
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					return
 				}
 			}
+		}()
+
+		time.Sleep(300 * time.Millisecond)
+
+		if !handler.WebSocket.IsUserConnected("alive") {
+			t.Error("a client answering pings should stay connected")
+		}
+	})
+
+	t.Run("Silent Client Is Disconnected", func(t *testing.T) {
+		// the client never reads, so it never answers a ping
+		serveTestWebsocketWith(t, handler, "silent", nil, fastHeartbeat)
+
+		waitForDisconnect(t, handler.WebSocket, "silent")
+	})
+
+	t.Run("Oversized Frame Closes The Connection", func(t *testing.T) {
+		client := serveTestWebsocket(t, handler, "chatty", nil)
+
+		if err := client.WriteMessage(websocket.TextMessage, make([]byte, maxMessageSize+1)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
 		}
 
-		if !found {
-			t.Error("Chat should be reported as at limit")
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := client.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Errorf("Expected close %d, got %v", websocket.CloseMessageTooBig, err)
 		}
 
-		// Cleanup
-		conn1.Close()
-		conn2.Close()
+		waitForDisconnect(t, handler.WebSocket, "chatty")
 	})
 }
 
+func waitForDisconnect(t *testing.T, ws *WebSocketManager, userId string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for ws.IsUserConnected(userId) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s should have been disconnected", userId)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testRoom(kind string) Room {
+	objectId := primitive.NewObjectID()
+
+	return Room{
+		Id:         roomId(kind, objectId),
+		Kind:       kind,
+		ObjectId:   objectId,
+		ReceiverId: primitive.NewObjectID(),
+		IsSecret:   kind == RoomSecretChat,
+	}
+}
+
 // Helper function to create a test WebSocket connection
 func createTestConnection(t *testing.T) *websocket.Conn {
+	conn, _ := createReadingTestConnection(t)
+	return conn
+}
+
+// createReadingTestConnection -> Messages written to the connection arrive on the channel
+func createReadingTestConnection(t *testing.T) (*websocket.Conn, <-chan string) {
+	received := make(chan string, 16)
+
 	// Create a test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Upgrade to WebSocket
@@ -302,13 +610,18 @@ func createTestConnection(t *testing.T) *websocket.Conn {
 		}
 		// Keep connection open for test duration
 		for {
-			_, _, err := conn.ReadMessage()
+			_, message, err := conn.ReadMessage()
 			if err != nil {
 				break
 			}
+
+			select {
+			case received <- string(message):
+			default:
+			}
 		}
 	}))
-	defer server.Close()
+	t.Cleanup(server.Close)
 
 	// Connect to the test server
 	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
@@ -317,9 +630,87 @@ func createTestConnection(t *testing.T) *websocket.Conn {
 		t.Fatalf("Failed to connect to test server: %v", err)
 	}
 
+	return conn, received
+}
+
+// createStalledTestConnection -> The other end never reads, writes block once the socket buffers are full
+func createStalledTestConnection(t *testing.T) *websocket.Conn {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+
 	return conn
 }
 
+// createCountingTestConnection -> Counts the frames written to the connection
+func createCountingTestConnection(t *testing.T) (*websocket.Conn, *atomic.Int64) {
+	count := &atomic.Int64{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade connection: %v", err)
+			return
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+
+			count.Add(1)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to test server: %v", err)
+	}
+
+	return conn, count
+}
+
+func readTestMessage(t *testing.T, received <-chan string) string {
+	t.Helper()
+
+	select {
+	case message := <-received:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return ""
+	}
+}
+
 // Helper function to create a test WebSocket connection for benchmarks
 func createTestConnectionForBenchmark(b *testing.B) *websocket.Conn {
 	// Create a test server
@@ -343,7 +734,7 @@ func createTestConnectionForBenchmark(b *testing.B) *websocket.Conn {
 			}
 		}
 	}))
-	defer server.Close()
+	b.Cleanup(server.Close)
 
 	// Connect to the test server
 	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
@@ -356,27 +747,26 @@ func createTestConnectionForBenchmark(b *testing.B) *websocket.Conn {
 }
 
 // Benchmark tests for performance
-func BenchmarkWebSocketManager_AddChat(b *testing.B) {
+func BenchmarkWebSocketManager_Register(b *testing.B) {
 	ws := WebsocketInit()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		conn := createTestConnectionForBenchmark(b)
-		wsConn := &WsConnection{Conn: conn}
-		wsConn.AddChat(fmt.Sprintf("chat%d", i), fmt.Sprintf("user%d", i), ws)
+		ws.Register(NewWsConnection(conn, fmt.Sprintf("user%d", i), "phone", ""), []Room{testRoom(RoomChat), testRoom(RoomGroup)})
 		conn.Close()
 	}
 }
 
 func BenchmarkWebSocketManager_Broadcast(b *testing.B) {
 	ws := WebsocketInit()
+	group := testRoom(RoomGroup)
 
 	// Setup test connections
 	connections := make([]*websocket.Conn, 10)
 	for i := 0; i < 10; i++ {
 		connections[i] = createTestConnectionForBenchmark(b)
-		wsConn := &WsConnection{Conn: connections[i]}
-		wsConn.AddGroup("group1", fmt.Sprintf("user%d", i), ws)
+		ws.Register(NewWsConnection(connections[i], fmt.Sprintf("user%d", i), "phone", ""), []Room{group})
 	}
 	defer func() {
 		for _, conn := range connections {
@@ -388,6 +778,30 @@ func BenchmarkWebSocketManager_Broadcast(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ws.BroadcastToRoom("group1", "user1", websocket.TextMessage, message)
+		ws.BroadcastToRoom(group.Id, "user1", message)
+	}
+}
+
+func TestWsConnection_ClosesWhenTokenExpires(t *testing.T) {
+	handler := &Handler{WebSocket: WebsocketInit()}
+
+	client := serveTestWebsocketWith(t, handler, "user1", nil, func(wsConn *WsConnection) {
+		wsConn.ExpireAt = time.Now().Add(100 * time.Millisecond)
+	})
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := client.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != closeTokenExpired {
+		t.Fatalf("Expected close code %d once the token expired, got %v", closeTokenExpired, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.WebSocket.IsUserConnected("user1") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expired connection to be unregistered")
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"time"
+)
+
+// Attempts -> Failed attempts of one key (a username, an ip...) inside the current window
+type Attempts struct {
+	Count        int       `bson:"count"`
+	LastFailedAt time.Time `bson:"last_failed_at"`
+}
+
+// Store -> Keeps the failure counters. A counter starts over once window has passed since its last failure
+type Store interface {
+	Get(key string) (Attempts, error)
+	Increment(key string, window time.Duration) (Attempts, error)
+	Reset(key string) error
+}
+
+// Policy -> The first FreeAttempts failures cost nothing, every further one doubles the wait (up to MaxDelay)
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+}
+
+// Delay -> Wait required after count failures
+func (policy Policy) Delay(count int) time.Duration {
+	if count <= policy.FreeAttempts {
+		return 0
+	}
+
+	delay := policy.BaseDelay
+	for range count - policy.FreeAttempts - 1 {
+		delay *= 2
+		if delay >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+
+	return min(delay, policy.MaxDelay)
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// RetryAfter -> How long the key is still blocked, 0 when a new attempt is allowed
+func (limiter *Limiter) RetryAfter(key string, policy Policy) (time.Duration, error) {
+	attempts, err := limiter.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return limiter.retryAfter(attempts, policy), nil
+}
+
+// Fail -> Records a failure and returns the new count with the wait it causes
+func (limiter *Limiter) Fail(key string, policy Policy) (int, time.Duration, error) {
+	attempts, err := limiter.store.Increment(key, policy.Window)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return attempts.Count, limiter.retryAfter(attempts, policy), nil
+}
+
+// Reset -> Clears the key, e.g. after a successful login
+func (limiter *Limiter) Reset(key string) error {
+	return limiter.store.Reset(key)
+}
+
+func (limiter *Limiter) retryAfter(attempts Attempts, policy Policy) time.Duration {
+	if attempts.Count == 0 || limiter.now().Sub(attempts.LastFailedAt) >= policy.Window {
+		return 0
+	}
+
+	blockedUntil := attempts.LastFailedAt.Add(policy.Delay(attempts.Count))
+
+	return max(blockedUntil.Sub(limiter.now()), 0)
+}
@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	Window:       time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		count    int
+		expected time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{7, 8 * time.Second},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := testPolicy.Delay(tt.count); got != tt.expected {
+			t.Errorf("Delay(%d) = %v, expected %v", tt.count, got, tt.expected)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	store := NewMemoryStore()
+	store.now = clock
+
+	limiter := New(store)
+	limiter.now = clock
+
+	for range testPolicy.FreeAttempts {
+		if _, wait, err := limiter.Fail("user:alice", testPolicy); err != nil || wait != 0 {
+			t.Fatalf("Free attempts should not block, got wait %v, err %v", wait, err)
+		}
+	}
+
+	count, wait, err := limiter.Fail("user:alice", testPolicy)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != testPolicy.FreeAttempts+1 || wait != time.Second {
+		t.Errorf("Expected count %d and wait 1s, got %d and %v", testPolicy.FreeAttempts+1, count, wait)
+	}
+
+	if retryAfter, _ := limiter.RetryAfter("user:bob", testPolicy); retryAfter != 0 {
+		t.Errorf("Other keys should not be blocked, got %v", retryAfter)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if retryAfter, _ := limiter.RetryAfter("user:alice", testPolicy); retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected 500ms left, got %v", retryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if retryAfter, _ := limiter.RetryAfter("user:alice", testPolicy); retryAfter != 0 {
+		t.Errorf("Expected block to be over, got %v", retryAfter)
+	}
+
+	if err := limiter.Reset("user:alice"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count, _, _ := limiter.Fail("user:alice", testPolicy); count != 1 {
+		t.Errorf("Expected count to start over after reset, got %d", count)
+	}
+}
+
+func TestMemoryStoreWindow(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	store.Increment("key", time.Minute)
+	store.Increment("key", time.Minute)
+
+	if attempts, _ := store.Get("key"); attempts.Count != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts.Count)
+	}
+
+	now = now.Add(time.Minute)
+
+	if attempts, _ := store.Get("key"); attempts.Count != 0 {
+		t.Errorf("Expected counter to expire after the window, got %d", attempts.Count)
+	}
+	if attempts, _ := store.Increment("key", time.Minute); attempts.Count != 1 {
+		t.Errorf("Expected expired counter to start over, got %d", attempts.Count)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	for i := range memorySweepThreshold - 1 {
+		store.Increment(string(rune(i)), time.Second)
+	}
+
+	now = now.Add(time.Second)
+	store.Increment("fresh", time.Second)
+
+	if len(store.entries) != 1 {
+		t.Errorf("Expected expired counters to be swept, %d left", len(store.entries))
+	}
+}
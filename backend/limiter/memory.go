@@ -0,0 +1,87 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// expired counters are swept once the map grows past this size
+const memorySweepThreshold = 1024
+
+// MemoryStore -> Store for a single instance. Counters are lost on restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]memoryEntry
+	now      func() time.Time
+	capacity int
+}
+
+type memoryEntry struct {
+	attempts Attempts
+	expireAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:  make(map[string]memoryEntry),
+		now:      time.Now,
+		capacity: memorySweepThreshold,
+	}
+}
+
+func (store *MemoryStore) Get(key string) (Attempts, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok || !store.now().Before(entry.expireAt) {
+		return Attempts{}, nil
+	}
+
+	return entry.attempts, nil
+}
+
+func (store *MemoryStore) Increment(key string, window time.Duration) (Attempts, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+
+	entry, ok := store.entries[key]
+	if !ok || !now.Before(entry.expireAt) {
+		entry = memoryEntry{}
+	}
+
+	entry.attempts.Count++
+	entry.attempts.LastFailedAt = now
+	entry.expireAt = now.Add(window)
+	store.entries[key] = entry
+
+	if len(store.entries) >= store.capacity {
+		store.sweep(now)
+	}
+
+	return entry.attempts, nil
+}
+
+func (store *MemoryStore) Reset(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.entries, key)
+
+	return nil
+}
+
+// sweep -> Drops expired counters. Doubles the threshold when most of them are still alive
+func (store *MemoryStore) sweep(now time.Time) {
+	for key, entry := range store.entries {
+		if !now.Before(entry.expireAt) {
+			delete(store.entries, key)
+		}
+	}
+
+	if len(store.entries) >= store.capacity/2 {
+		store.capacity *= 2
+	}
+}
@@ -0,0 +1,249 @@
+package paseto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultKeyId -> Id of the PASETO_SYMMETRIC_KEY key. Tokens without a key id (issued before the keyring) use it
+const DefaultKeyId = "default"
+
+var ErrUnknownKey = errors.New("token key is unknown or retired")
+
+// key -> Symmetric keys make v2.local tokens, ed25519 keys make v4.public tokens
+type key struct {
+	id        string
+	symmetric []byte
+	private   ed25519.PrivateKey
+}
+
+func (k *key) isPublic() bool {
+	return k.private != nil
+}
+
+// Keyring -> The active key signs new tokens, every other key only verifies until it is retired
+type Keyring struct {
+	active string
+	keys   map[string]*key
+}
+
+// PublicKey -> Verification key other services can use for v4.public tokens, in PASERK format (k4.public.)
+type PublicKey struct {
+	KeyId  string `json:"kid"`
+	Paserk string `json:"paserk"`
+	Active bool   `json:"active"`
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]*key),
+	}
+}
+
+// AddSymmetricKey -> The secret is hashed to the 32 bytes v2.local needs
+func (keyring *Keyring) AddSymmetricKey(id, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("paseto key %q is empty", id)
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+
+	return keyring.add(&key{id: id, symmetric: hash[:]})
+}
+
+// AddPublicKey -> seed is the 32 byte ed25519 private key seed
+func (keyring *Keyring) AddPublicKey(id string, seed []byte) error {
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("paseto key %q must be a %d byte ed25519 seed", id, ed25519.SeedSize)
+	}
+
+	return keyring.add(&key{id: id, private: ed25519.NewKeyFromSeed(seed)})
+}
+
+func (keyring *Keyring) add(k *key) error {
+	if k.id == "" || strings.ContainsAny(k.id, "=,") {
+		return fmt.Errorf("paseto key id %q is invalid", k.id)
+	}
+
+	if _, exists := keyring.keys[k.id]; exists {
+		return fmt.Errorf("paseto key id %q is used twice", k.id)
+	}
+
+	keyring.keys[k.id] = k
+
+	return nil
+}
+
+// SetActive -> Picks the key that signs new tokens. Its type decides between v2.local and v4.public
+func (keyring *Keyring) SetActive(id string) error {
+	if _, exists := keyring.keys[id]; !exists {
+		return fmt.Errorf("active paseto key %q does not exist", id)
+	}
+
+	keyring.active = id
+
+	return nil
+}
+
+// Retire -> Tokens of a retired key are rejected. The active key can't be retired
+func (keyring *Keyring) Retire(id string) error {
+	if id == keyring.active {
+		return fmt.Errorf("active paseto key %q can not be retired", id)
+	}
+
+	delete(keyring.keys, id)
+
+	return nil
+}
+
+// PublicKeys -> Every ed25519 key that still verifies, sorted by id
+func (keyring *Keyring) PublicKeys() []PublicKey {
+	publicKeys := []PublicKey{}
+
+	for _, k := range keyring.keys {
+		if !k.isPublic() {
+			continue
+		}
+
+		publicKeys = append(publicKeys, PublicKey{
+			KeyId:  k.id,
+			Paserk: "k4.public." + base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+			Active: k.id == keyring.active,
+		})
+	}
+
+	sort.Slice(publicKeys, func(i, j int) bool { return publicKeys[i].KeyId < publicKeys[j].KeyId })
+
+	return publicKeys
+}
+
+func (keyring *Keyring) activeKey() *key {
+	return keyring.keys[keyring.active]
+}
+
+// verificationKey -> Looks the key up by the id of the token footer
+func (keyring *Keyring) verificationKey(id string, public bool) (*key, error) {
+	if id == "" {
+		id = DefaultKeyId
+	}
+
+	k, exists := keyring.keys[id]
+	if !exists || k.isPublic() != public {
+		return nil, ErrUnknownKey
+	}
+
+	return k, nil
+}
+
+// KeyringFromEnv -> Builds the keyring from:
+//
+//	PASETO_SYMMETRIC_KEY    secret of the "default" v2.local key
+//	PASETO_KEYS             more v2.local keys: id=secret,id=secret
+//	PASETO_PUBLIC_KEYS      v4.public keys: id=hex ed25519 seed,...
+//	PASETO_ACTIVE_KEY_ID    key that signs new tokens ("default" when set, else the only key)
+//	PASETO_RETIRED_KEY_IDS  keys whose tokens are rejected: id,id
+func KeyringFromEnv() (*Keyring, error) {
+	keyring := NewKeyring()
+
+	if secret := os.Getenv("PASETO_SYMMETRIC_KEY"); secret != "" {
+		if err := keyring.AddSymmetricKey(DefaultKeyId, secret); err != nil {
+			return nil, err
+		}
+	}
+
+	symmetricKeys, err := parseKeyList("PASETO_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range symmetricKeys {
+		if err := keyring.AddSymmetricKey(entry[0], entry[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	publicKeys, err := parseKeyList("PASETO_PUBLIC_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range publicKeys {
+		seed, err := hex.DecodeString(entry[1])
+		if err != nil {
+			return nil, fmt.Errorf("paseto key %q is not hex encoded", entry[0])
+		}
+
+		if err := keyring.AddPublicKey(entry[0], seed); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("PASETO_SYMMETRIC_KEY env variable does not exist")
+	}
+
+	activeId := os.Getenv("PASETO_ACTIVE_KEY_ID")
+	if activeId == "" {
+		activeId, err = defaultActiveKey(keyring)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keyring.SetActive(activeId); err != nil {
+		return nil, err
+	}
+
+	for _, id := range strings.Split(os.Getenv("PASETO_RETIRED_KEY_IDS"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+
+		if err := keyring.Retire(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
+
+func defaultActiveKey(keyring *Keyring) (string, error) {
+	if _, exists := keyring.keys[DefaultKeyId]; exists {
+		return DefaultKeyId, nil
+	}
+
+	if len(keyring.keys) == 1 {
+		for id := range keyring.keys {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("PASETO_ACTIVE_KEY_ID must be set when several paseto keys are configured")
+}
+
+// parseKeyList -> "id=value,id=value" pairs
+func parseKeyList(envName string) ([][2]string, error) {
+	var entries [][2]string
+
+	for _, item := range strings.Split(os.Getenv(envName), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		id, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("%s entries must look like id=value", envName)
+		}
+
+		entries = append(entries, [2]string{strings.TrimSpace(id), strings.TrimSpace(value)})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,217 @@
+package paseto
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pasetolib "github.com/o1egl/paseto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeyRotation(t *testing.T) {
+	keyring := NewKeyring()
+	if err := keyring.AddSymmetricKey("old", "old-secret"); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if err := keyring.AddSymmetricKey("new", "new-secret"); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	keyring.SetActive("old")
+
+	maker := NewWithKeyring(keyring)
+	userID := primitive.NewObjectID()
+
+	oldToken, err := maker.CreateToken(userID, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	// rotate: the new key signs, the old one still verifies
+	if err := keyring.SetActive("new"); err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+
+	newToken, err := maker.CreateToken(userID, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	var footer tokenFooter
+	if err := pasetolib.ParseFooter(newToken, &footer); err != nil || footer.KeyId != "new" {
+		t.Errorf("Expected key id 'new' in footer, got %q (err %v)", footer.KeyId, err)
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := maker.VerifyToken(token); err != nil {
+			t.Errorf("Expected %s token to verify, got %v", name, err)
+		}
+	}
+
+	if err := keyring.Retire("new"); err == nil {
+		t.Error("Expected error when retiring the active key")
+	}
+
+	if err := keyring.Retire("old"); err != nil {
+		t.Fatalf("Failed to retire key: %v", err)
+	}
+
+	if _, err := maker.VerifyToken(oldToken); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey for retired key, got %v", err)
+	}
+}
+
+func TestTokenWithoutKeyId(t *testing.T) {
+	// tokens issued before the keyring have no footer and belong to the default key
+	os.Setenv("PASETO_SYMMETRIC_KEY", "test-symmetric-key-for-testing-only")
+	defer os.Unsetenv("PASETO_SYMMETRIC_KEY")
+
+	maker, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+
+	payload, err := NewPayload(primitive.NewObjectID(), "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create payload: %v", err)
+	}
+
+	legacyToken, err := maker.paseto.Encrypt(maker.keyring.keys[DefaultKeyId].symmetric, payload, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if _, err := maker.VerifyToken(legacyToken); err != nil {
+		t.Errorf("Expected legacy token to verify, got %v", err)
+	}
+}
+
+func TestV4PublicTokens(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 1
+
+	keyring := NewKeyring()
+	keyring.AddSymmetricKey(DefaultKeyId, "local-secret")
+	if err := keyring.AddPublicKey("signing-1", seed); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	keyring.SetActive("signing-1")
+
+	maker := NewWithKeyring(keyring)
+	userID := primitive.NewObjectID()
+
+	token, err := maker.CreateToken(userID, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if !strings.HasPrefix(token, v4PublicHeader) {
+		t.Fatalf("Expected v4.public token, got %s", token)
+	}
+
+	payload, err := maker.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	if payload.UserId != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, payload.UserId)
+	}
+
+	tampered := []byte(token)
+	tampered[len(v4PublicHeader)+5] ^= 1
+	if _, err := maker.VerifyToken(string(tampered)); err == nil {
+		t.Error("Expected tampered token to be rejected")
+	}
+
+	publicKeys := maker.PublicKeys()
+	if len(publicKeys) != 1 || publicKeys[0].KeyId != "signing-1" || !publicKeys[0].Active {
+		t.Fatalf("Expected the active signing key only, got %+v", publicKeys)
+	}
+	if !strings.HasPrefix(publicKeys[0].Paserk, "k4.public.") {
+		t.Errorf("Expected PASERK public key, got %s", publicKeys[0].Paserk)
+	}
+}
+
+func TestV4PublicVector(t *testing.T) {
+	// test vector 4-S-1 of the paseto specification
+	publicKey, _ := hex.DecodeString("1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	token := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+		"bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+
+	var payload map[string]string
+	if err := verifyV4Public(publicKey, token, &payload); err != nil {
+		t.Fatalf("Failed to verify spec token: %v", err)
+	}
+
+	if payload["data"] != "this is a signed message" {
+		t.Errorf("Unexpected payload %v", payload)
+	}
+}
+
+func TestKeyringFromEnv(t *testing.T) {
+	envs := []string{"PASETO_SYMMETRIC_KEY", "PASETO_KEYS", "PASETO_PUBLIC_KEYS", "PASETO_ACTIVE_KEY_ID",
+		"PASETO_RETIRED_KEY_IDS"}
+	for _, env := range envs {
+		os.Unsetenv(env)
+	}
+	defer func() {
+		for _, env := range envs {
+			os.Unsetenv(env)
+		}
+	}()
+
+	os.Setenv("PASETO_SYMMETRIC_KEY", "legacy")
+	os.Setenv("PASETO_KEYS", "k1=first, k2=second")
+	os.Setenv("PASETO_PUBLIC_KEYS", "p1="+strings.Repeat("ab", ed25519.SeedSize))
+
+	keyring, err := KeyringFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if keyring.active != DefaultKeyId || len(keyring.keys) != 4 {
+		t.Errorf("Expected 4 keys with 'default' active, got %d keys with %q active", len(keyring.keys), keyring.active)
+	}
+
+	os.Setenv("PASETO_ACTIVE_KEY_ID", "k2")
+	os.Setenv("PASETO_RETIRED_KEY_IDS", "default")
+
+	keyring, err = KeyringFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, exists := keyring.keys[DefaultKeyId]; exists || keyring.active != "k2" {
+		t.Errorf("Expected 'default' retired and 'k2' active, got %q active", keyring.active)
+	}
+
+	os.Setenv("PASETO_RETIRED_KEY_IDS", "k2")
+	if _, err := KeyringFromEnv(); err == nil {
+		t.Error("Expected error when retiring the active key")
+	}
+
+	os.Unsetenv("PASETO_SYMMETRIC_KEY")
+	os.Unsetenv("PASETO_ACTIVE_KEY_ID")
+	os.Unsetenv("PASETO_RETIRED_KEY_IDS")
+	if _, err := KeyringFromEnv(); err == nil {
+		t.Error("Expected error when several keys are configured without an active one")
+	}
+}
+
+func TestPreAuthEncode(t *testing.T) {
+	tests := []struct {
+		pieces   [][]byte
+		expected string
+	}{
+		{nil, "0000000000000000"},
+		{[][]byte{{}}, "01000000000000000000000000000000"},
+		{[][]byte{[]byte("test")}, "0100000000000000040000000000000074657374"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(preAuthEncode(tt.pieces...)); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+	}
+}
@@ -1,43 +1,48 @@
 package paseto
 
 import (
-	"crypto/sha256"
-	"errors"
-	"os"
+	"crypto/ed25519"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/o1egl/paseto"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Maker struct {
-	paseto       *paseto.V2
-	symmetricKey [32]byte
+	paseto   *paseto.V2
+	keyring  *Keyring
+	sessions SessionChecker
 }
 
+// tokenFooter -> Unencrypted but authenticated part of every token, tells which key verifies it
+type tokenFooter struct {
+	KeyId string `json:"kid"`
+}
+
+const v2LocalHeader = "v2.local."
+
+// SessionChecker -> Reports whether a server side session has been revoked
+type SessionChecker interface {
+	IsRevoked(sessionId string) bool
+}
+
+// New -> Maker with the keyring configured in the env (see KeyringFromEnv)
 func New() (*Maker, error) {
-	key, err := getSymmetricKey()
+	keyring, err := KeyringFromEnv()
 	if err != nil {
 		return nil, err
 	}
 
-	maker := &Maker{
-		paseto:       paseto.NewV2(),
-		symmetricKey: key,
-	}
-
-	return maker, nil
+	return NewWithKeyring(keyring), nil
 }
 
-func getSymmetricKey() ([32]byte, error) {
-	symmetricKey := os.Getenv("PASETO_SYMMETRIC_KEY")
-	if symmetricKey == "" {
-		return [32]byte{}, errors.New("PASETO_SYMMETRIC_KEY env variable does not exist")
+func NewWithKeyring(keyring *Keyring) *Maker {
+	return &Maker{
+		paseto:  paseto.NewV2(),
+		keyring: keyring,
 	}
-
-	hash := sha256.Sum256([]byte(symmetricKey))
-
-	return hash, nil
 }
 
 func (maker *Maker) CreateToken(userId primitive.ObjectID, username string, duration time.Duration) (string, error) {
@@ -46,13 +51,63 @@ func (maker *Maker) CreateToken(userId primitive.ObjectID, username string, dura
 		return "", err
 	}
 
-	return maker.paseto.Encrypt(maker.symmetricKey[:], payload, nil)
+	return maker.sign(payload)
+}
+
+// CreateSessionToken -> Creates an access or refresh token bound to a session
+func (maker *Maker) CreateSessionToken(userId primitive.ObjectID, username string, sessionId uuid.UUID, kind string,
+	duration time.Duration) (string, *Payload, error) {
+
+	payload, err := NewPayload(userId, username, duration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	payload.SessionId = sessionId
+	payload.Kind = kind
+
+	token, err := maker.sign(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, payload, nil
 }
 
+// VerifyToken -> Accepts v2.local and v4.public tokens of every key that is not retired
 func (maker *Maker) VerifyToken(token string) (*Payload, error) {
 	var payload Payload
-	if err := maker.paseto.Decrypt(token, maker.symmetricKey[:], &payload, nil); err != nil {
-		return nil, err
+	var footer tokenFooter
+
+	switch {
+	case strings.HasPrefix(token, v2LocalHeader):
+		if err := paseto.ParseFooter(token, &footer); err != nil {
+			return nil, err
+		}
+
+		k, err := maker.keyring.verificationKey(footer.KeyId, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := maker.paseto.Decrypt(token, k.symmetric, &payload, nil); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(token, v4PublicHeader):
+		if err := parseV4PublicFooter(token, &footer); err != nil {
+			return nil, err
+		}
+
+		k, err := maker.keyring.verificationKey(footer.KeyId, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := verifyV4Public(k.private.Public().(ed25519.PublicKey), token, &payload); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidToken
 	}
 
 	if err := payload.Valid(); err != nil {
@@ -61,3 +116,34 @@ func (maker *Maker) VerifyToken(token string) (*Payload, error) {
 
 	return &payload, nil
 }
+
+// PublicKeys -> Keys other services need to verify v4.public tokens themselves
+func (maker *Maker) PublicKeys() []PublicKey {
+	return maker.keyring.PublicKeys()
+}
+
+// sign -> Uses the active key, v4.public when it is an ed25519 key, else v2.local
+func (maker *Maker) sign(payload *Payload) (string, error) {
+	activeKey := maker.keyring.activeKey()
+	footer := tokenFooter{KeyId: activeKey.id}
+
+	if activeKey.isPublic() {
+		return signV4Public(activeKey.private, payload, footer)
+	}
+
+	return maker.paseto.Encrypt(activeKey.symmetric, payload, footer)
+}
+
+// SetSessionChecker -> Plugs in the session registry used to reject revoked sessions
+func (maker *Maker) SetSessionChecker(checker SessionChecker) {
+	maker.sessions = checker
+}
+
+// IsSessionRevoked -> Returns false when no session registry is configured
+func (maker *Maker) IsSessionRevoked(sessionId uuid.UUID) bool {
+	if maker.sessions == nil {
+		return false
+	}
+
+	return maker.sessions.IsRevoked(sessionId.String())
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -199,6 +200,76 @@ func TestVerifyToken(t *testing.T) {
 	}
 }
 
+func TestCreateSessionToken(t *testing.T) {
+	// Set up test environment
+	os.Setenv("PASETO_SYMMETRIC_KEY", "test-symmetric-key-for-testing-only")
+	defer os.Unsetenv("PASETO_SYMMETRIC_KEY")
+
+	maker, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+
+	userID := primitive.NewObjectID()
+	sessionID := uuid.New()
+
+	token, created, err := maker.CreateSessionToken(userID, "testuser", sessionID, RefreshToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session token: %v", err)
+	}
+
+	payload, err := maker.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+
+	if payload.ID != created.ID {
+		t.Errorf("Expected token ID %v, got %v", created.ID, payload.ID)
+	}
+
+	if payload.SessionId != sessionID {
+		t.Errorf("Expected session ID %v, got %v", sessionID, payload.SessionId)
+	}
+
+	if payload.Kind != RefreshToken {
+		t.Errorf("Expected kind %s, got %s", RefreshToken, payload.Kind)
+	}
+}
+
+type fakeSessionChecker map[string]bool
+
+func (checker fakeSessionChecker) IsRevoked(sessionId string) bool {
+	return checker[sessionId]
+}
+
+func TestIsSessionRevoked(t *testing.T) {
+	// Set up test environment
+	os.Setenv("PASETO_SYMMETRIC_KEY", "test-symmetric-key-for-testing-only")
+	defer os.Unsetenv("PASETO_SYMMETRIC_KEY")
+
+	maker, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+
+	revoked := uuid.New()
+	active := uuid.New()
+
+	if maker.IsSessionRevoked(revoked) {
+		t.Error("Expected no revocation without a session checker")
+	}
+
+	maker.SetSessionChecker(fakeSessionChecker{revoked.String(): true})
+
+	if !maker.IsSessionRevoked(revoked) {
+		t.Error("Expected revoked session to be reported")
+	}
+
+	if maker.IsSessionRevoked(active) {
+		t.Error("Expected active session not to be reported")
+	}
+}
+
 func TestVerifyTokenWithDifferentKeys(t *testing.T) {
 	// Create two makers with different keys
 	os.Setenv("PASETO_SYMMETRIC_KEY", "first-key")
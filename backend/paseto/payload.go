@@ -7,8 +7,19 @@ import (
 	"time"
 )
 
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+	// TwoFactorChallenge proves the password step of a login, it is exchanged for a session with a totp code
+	TwoFactorChallenge = "2fa_challenge"
+	// APIKey payloads are never signed, the auth middleware builds them for personal api keys
+	APIKey = "api_key"
+)
+
 type Payload struct {
 	ID        uuid.UUID          `json:"id"`
+	SessionId uuid.UUID          `json:"session_id"`
+	Kind      string             `json:"kind"` // access, refresh, 2fa_challenge or api_key
 	UserId    primitive.ObjectID `json:"user_id"`
 	Username  string             `json:"username"`
 	CreatedAt time.Time          `json:"created_at"`
@@ -23,6 +34,7 @@ func NewPayload(userId primitive.ObjectID, username string, duration time.Durati
 
 	payload := &Payload{
 		ID:        tokenId,
+		Kind:      AccessToken,
 		UserId:    userId,
 		Username:  username,
 		CreatedAt: time.Now(),
@@ -0,0 +1,111 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// v4.public is not supported by the paseto library, it only needs ed25519 and the PAE encoding of the spec
+
+const v4PublicHeader = "v4.public."
+
+var (
+	ErrInvalidToken     = errors.New("token is invalid")
+	ErrInvalidSignature = errors.New("token signature is invalid")
+)
+
+var tokenEncoding = base64.RawURLEncoding
+
+func signV4Public(privateKey ed25519.PrivateKey, payload, footer any) (string, error) {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	footerBytes, err := json.Marshal(footer)
+	if err != nil {
+		return "", err
+	}
+
+	signature := ed25519.Sign(privateKey, preAuthEncode([]byte(v4PublicHeader), message, footerBytes, nil))
+
+	token := v4PublicHeader + tokenEncoding.EncodeToString(append(message, signature...))
+	token += "." + tokenEncoding.EncodeToString(footerBytes)
+
+	return token, nil
+}
+
+func verifyV4Public(publicKey ed25519.PublicKey, token string, payload any) error {
+	body, footer, err := splitV4Public(token)
+	if err != nil {
+		return err
+	}
+
+	if len(body) < ed25519.SignatureSize {
+		return ErrInvalidToken
+	}
+
+	message := body[:len(body)-ed25519.SignatureSize]
+	signature := body[len(body)-ed25519.SignatureSize:]
+
+	if !ed25519.Verify(publicKey, preAuthEncode([]byte(v4PublicHeader), message, footer, nil), signature) {
+		return ErrInvalidSignature
+	}
+
+	return json.Unmarshal(message, payload)
+}
+
+// parseV4PublicFooter -> Reads the footer before the signature is checked, to find the key
+func parseV4PublicFooter(token string, footer any) error {
+	_, footerBytes, err := splitV4Public(token)
+	if err != nil || len(footerBytes) == 0 {
+		return err
+	}
+
+	return json.Unmarshal(footerBytes, footer)
+}
+
+func splitV4Public(token string) ([]byte, []byte, error) {
+	rest, found := strings.CutPrefix(token, v4PublicHeader)
+	if !found {
+		return nil, nil, ErrInvalidToken
+	}
+
+	encodedBody, encodedFooter, _ := strings.Cut(rest, ".")
+
+	body, err := tokenEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	footer, err := tokenEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	return body, footer, nil
+}
+
+// preAuthEncode -> PAE from the paseto spec: the piece count, then every piece prefixed by its length
+func preAuthEncode(pieces ...[]byte) []byte {
+	var buffer bytes.Buffer
+
+	writeLength := func(length int) {
+		var encoded [8]byte
+		binary.LittleEndian.PutUint64(encoded[:], uint64(length)&^(1<<63))
+		buffer.Write(encoded[:])
+	}
+
+	writeLength(len(pieces))
+	for _, piece := range pieces {
+		writeLength(len(piece))
+		buffer.Write(piece)
+	}
+
+	return buffer.Bytes()
+}
@@ -2,25 +2,70 @@ package utils
 
 import (
 	"chat_app/paseto"
+	"context"
 	"errors"
 	"net/http"
+	"strings"
 )
 
+type authPayloadKey struct{}
+
 var (
 	NoAuthCookie   = errors.New("noCookieToken")
 	CookieNotValid = errors.New("cookieNotValid")
+	SessionRevoked = errors.New("sessionRevoked")
+	NoAuthPayload  = errors.New("noAuthPayload")
 )
 
-func CheckAuth(r *http.Request, paseto *paseto.Maker) (*paseto.Payload, *ErrorResponse) {
-	cookie, err := r.Cookie("auth_cookie")
-	if err != nil {
-		return nil, &ErrorResponse{Type: NoAuthCookie.Error(), Detail: "Auth cookie is missing"}
+func CheckAuth(r *http.Request, maker *paseto.Maker) (*paseto.Payload, *ErrorResponse) {
+	token, ok := AuthToken(r)
+	if !ok {
+		return nil, &ErrorResponse{Type: NoAuthCookie.Error(), Detail: "Auth cookie or bearer token is missing"}
 	}
 
-	payload, err := paseto.VerifyToken(cookie.Value)
+	payload, err := maker.VerifyToken(token)
 	if err != nil {
 		return nil, &ErrorResponse{Type: CookieNotValid.Error(), Detail: "Can't verify auth token"}
 	}
 
+	// refresh tokens are only accepted by the refresh endpoint
+	if payload.Kind != "" && payload.Kind != paseto.AccessToken {
+		return nil, &ErrorResponse{Type: CookieNotValid.Error(), Detail: "Token is not an access token"}
+	}
+
+	if maker.IsSessionRevoked(payload.SessionId) {
+		return nil, &ErrorResponse{Type: SessionRevoked.Error(), Detail: "Session has been revoked"}
+	}
+
 	return payload, nil
 }
+
+// AuthToken -> Returns the token of the "Authorization: Bearer" header, falling back to the auth cookie
+func AuthToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found || token == "" {
+			return "", false
+		}
+
+		return strings.TrimSpace(token), true
+	}
+
+	cookie, err := r.Cookie("auth_cookie")
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+// WithAuthPayload -> Returns a copy of ctx carrying the verified token payload
+func WithAuthPayload(ctx context.Context, payload *paseto.Payload) context.Context {
+	return context.WithValue(ctx, authPayloadKey{}, payload)
+}
+
+// AuthPayload -> Returns the payload stored by the auth middleware, if any
+func AuthPayload(ctx context.Context) (*paseto.Payload, bool) {
+	payload, ok := ctx.Value(authPayloadKey{}).(*paseto.Payload)
+	return payload, ok && payload != nil
+}
@@ -1,13 +1,16 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
 func Hash(plainText []byte) ([]byte, error) {
 	hash, err := bcrypt.GenerateFromPassword(plainText, bcrypt.DefaultCost)
 	if err != nil {
-		return []byte{}, nil
+		return nil, err
 	}
 
 	return hash, nil
@@ -16,3 +19,9 @@ func Hash(plainText []byte) ([]byte, error) {
 func VerifyHash(hash, plainText string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainText)) == nil
 }
+
+// HashToken -> Fast deterministic hash for high entropy secrets (api keys) that must be looked up by hash
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
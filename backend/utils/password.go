@@ -0,0 +1,125 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+// PasswordPolicy -> Rules every new password has to satisfy
+type PasswordPolicy struct {
+	MinLength int
+	// bcrypt can't hash more than 72 bytes. 0 means no limit
+	MaxLength int
+	// how many of the lower, upper, digit and symbol classes must appear
+	MinClasses int
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MaxLength:  72,
+	MinClasses: 3,
+}
+
+// PasswordPolicyFromEnv -> DefaultPasswordPolicy, overridden by PASSWORD_MIN_LENGTH and PASSWORD_MIN_CLASSES
+func PasswordPolicyFromEnv() (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 1 || minLength > policy.MaxLength {
+			return PasswordPolicy{}, fmt.Errorf("PASSWORD_MIN_LENGTH must be between 1 and %d", policy.MaxLength)
+		}
+
+		policy.MinLength = minLength
+	}
+
+	if value := os.Getenv("PASSWORD_MIN_CLASSES"); value != "" {
+		minClasses, err := strconv.Atoi(value)
+		if err != nil || minClasses < 0 || minClasses > 4 {
+			return PasswordPolicy{}, fmt.Errorf("PASSWORD_MIN_CLASSES must be between 0 and 4")
+		}
+
+		policy.MinClasses = minClasses
+	}
+
+	return policy, nil
+}
+
+// Validate -> Returns nil when the password is acceptable for this username
+func (policy PasswordPolicy) Validate(username, password string) *ErrorResponse {
+	if len(password) < policy.MinLength {
+		return weakPassword(fmt.Sprintf("password must be at least %d characters long", policy.MinLength))
+	}
+
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		return weakPassword(fmt.Sprintf("password can not be longer than %d bytes", policy.MaxLength))
+	}
+
+	if classes := passwordClasses(password); classes < policy.MinClasses {
+		return weakPassword(fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, "+
+			"digits and symbols", policy.MinClasses))
+	}
+
+	// very short usernames would match by accident
+	lowered := strings.ToLower(password)
+	if len(username) >= 3 && strings.Contains(lowered, strings.ToLower(username)) {
+		return weakPassword("password can not contain the username")
+	}
+
+	if commonPasswords[lowered] {
+		return weakPassword("password is too common")
+	}
+
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol bool
+
+	for _, char := range password {
+		switch {
+		case unicode.IsLower(char):
+			lower = true
+		case unicode.IsUpper(char):
+			upper = true
+		case unicode.IsDigit(char):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+
+	return classes
+}
+
+func loadCommonPasswords(file string) map[string]bool {
+	passwords := make(map[string]bool)
+
+	for _, line := range strings.Split(file, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+
+	return passwords
+}
+
+func weakPassword(detail string) *ErrorResponse {
+	return &ErrorResponse{Type: "weakPassword", Detail: detail}
+}
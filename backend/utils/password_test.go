@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy
+
+	tests := []struct {
+		name        string
+		username    string
+		password    string
+		expectError bool
+	}{
+		{"Strong password", "alice", "Tr1cky-Horse", false},
+		{"Empty password", "alice", "", true},
+		{"Too short", "alice", "Ab1!", true},
+		{"Too long", "alice", "Aa1!" + string(make([]byte, 80)), true},
+		{"Single class", "alice", "abcdefghijkl", true},
+		{"Two classes", "alice", "abcdefgh1234", true},
+		{"Contains username", "alice", "Alice-2024x", true},
+		{"Common password", "bob", "Password123", true},
+		{"Short username is not matched", "al", "Tr1cky-al-Horse", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errResp := policy.Validate(tt.username, tt.password)
+			if tt.expectError && errResp == nil {
+				t.Error("Expected password to be rejected")
+			}
+			if !tt.expectError && errResp != nil {
+				t.Errorf("Expected password to be accepted, got %v", errResp.Detail)
+			}
+			if errResp != nil && errResp.Type != "weakPassword" {
+				t.Errorf("Expected error type 'weakPassword', got '%s'", errResp.Type)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	defer os.Unsetenv("PASSWORD_MIN_LENGTH")
+	defer os.Unsetenv("PASSWORD_MIN_CLASSES")
+
+	policy, err := PasswordPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy != DefaultPasswordPolicy {
+		t.Errorf("Expected default policy, got %+v", policy)
+	}
+
+	os.Setenv("PASSWORD_MIN_LENGTH", "12")
+	os.Setenv("PASSWORD_MIN_CLASSES", "2")
+
+	policy, err = PasswordPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy.MinLength != 12 || policy.MinClasses != 2 {
+		t.Errorf("Expected min length 12 and 2 classes, got %+v", policy)
+	}
+
+	os.Setenv("PASSWORD_MIN_CLASSES", "5")
+	if _, err := PasswordPolicyFromEnv(); err == nil {
+		t.Error("Expected error for more than 4 classes")
+	}
+}
+
+func TestCommonPasswordsLoaded(t *testing.T) {
+	if len(commonPasswords) < 100 {
+		t.Errorf("Expected the bundled common password list, got %d entries", len(commonPasswords))
+	}
+	if !commonPasswords["password"] {
+		t.Error("Expected 'password' to be in the common password list")
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies -> Comma separated ips or CIDR ranges of the reverse proxies in front of the server
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES contains an invalid ip: %s", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES contains an invalid range: %s", entry)
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// ClientIP -> Returns the caller IP. The direct peer is used unless it is one of the trusted proxies, then
+// X-Forwarded-For is read from the right and the first hop that isn't a trusted proxy is the client.
+// Hops to the left of it are set by the client and never trusted
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		hop := strings.TrimSpace(hops[idx])
+		if net.ParseIP(hop) == nil {
+			// a malformed hop can't be followed any further
+			break
+		}
+
+		if !isTrustedProxy(hop, trustedProxies) {
+			return hop
+		}
+
+		peer = hop
+	}
+
+	return peer
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// base32 of the RFC 6238 test secret "12345678901234567890"
+const rfcTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to 6 digits
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcTestSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Failed to generate code: %v", err)
+		}
+		if code != tt.expected {
+			t.Errorf("At %d expected code %s, got %s", tt.unix, tt.expected, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	step, ok := VerifyTOTP(secret, code, now)
+	if !ok {
+		t.Fatal("Expected current code to be valid")
+	}
+	if step != now.Unix()/totpPeriod {
+		t.Errorf("Expected step %d, got %d", now.Unix()/totpPeriod, step)
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now.Add(totpPeriod*time.Second)); !ok {
+		t.Error("Expected code of the previous step to be accepted for clock drift")
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now.Add(5*totpPeriod*time.Second)); ok {
+		t.Error("Expected old code to be rejected")
+	}
+
+	if _, ok := VerifyTOTP(secret, "12345", now); ok {
+		t.Error("Expected short code to be rejected")
+	}
+
+	if _, ok := VerifyTOTP("not base32!", code, now); ok {
+		t.Error("Expected invalid secret to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("ChatApp", "alice", rfcTestSecret)
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("Failed to parse uri: %v", err)
+	}
+
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
+		t.Errorf("Unexpected uri prefix: %s", uri)
+	}
+	if !strings.HasSuffix(parsed.Path, "ChatApp:alice") {
+		t.Errorf("Expected label ChatApp:alice, got %s", parsed.Path)
+	}
+	if parsed.Query().Get("secret") != rfcTestSecret {
+		t.Errorf("Expected secret %s, got %s", rfcTestSecret, parsed.Query().Get("secret"))
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -62,6 +63,38 @@ func TestCheckAuth(t *testing.T) {
 			expectError: true,
 			errorType:   "noCookieToken",
 		},
+		{
+			name: "Valid bearer token",
+			setupReq: func() *http.Request {
+				req := httptest.NewRequest("GET", "/test", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				return req
+			},
+			expectError: false,
+		},
+		{
+			name: "Bearer token wins over cookie",
+			setupReq: func() *http.Request {
+				req := httptest.NewRequest("GET", "/test", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				req.AddCookie(&http.Cookie{
+					Name:  "auth_cookie",
+					Value: "invalid-token",
+				})
+				return req
+			},
+			expectError: false,
+		},
+		{
+			name: "Malformed authorization header",
+			setupReq: func() *http.Request {
+				req := httptest.NewRequest("GET", "/test", nil)
+				req.Header.Set("Authorization", "Basic "+token)
+				return req
+			},
+			expectError: true,
+			errorType:   "noCookieToken",
+		},
 		{
 			name: "Invalid auth cookie",
 			setupReq: func() *http.Request {
@@ -110,6 +143,121 @@ func TestCheckAuth(t *testing.T) {
 	}
 }
 
+type revokedSessions map[string]bool
+
+func (sessions revokedSessions) IsRevoked(sessionId string) bool {
+	return sessions[sessionId]
+}
+
+func TestCheckAuthSessions(t *testing.T) {
+	// Set up test environment
+	os.Setenv("PASETO_SYMMETRIC_KEY", "test-symmetric-key-for-testing-only")
+	defer os.Unsetenv("PASETO_SYMMETRIC_KEY")
+
+	maker, err := paseto.New()
+	if err != nil {
+		t.Fatalf("Failed to create paseto maker: %v", err)
+	}
+
+	userID := primitive.NewObjectID()
+	activeSession := uuid.New()
+	revokedSession := uuid.New()
+
+	maker.SetSessionChecker(revokedSessions{revokedSession.String(): true})
+
+	tests := []struct {
+		name        string
+		sessionId   uuid.UUID
+		kind        string
+		expectError bool
+		errorType   string
+	}{
+		{"Active session access token", activeSession, paseto.AccessToken, false, ""},
+		{"Refresh token is rejected", activeSession, paseto.RefreshToken, true, "cookieNotValid"},
+		{"Revoked session", revokedSession, paseto.AccessToken, true, "sessionRevoked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := maker.CreateSessionToken(userID, "testuser", tt.sessionId, tt.kind, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to create token: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.AddCookie(&http.Cookie{Name: "auth_cookie", Value: token})
+
+			payload, errResp := CheckAuth(req, maker)
+			if tt.expectError {
+				if errResp == nil {
+					t.Fatal("Expected error response, got nil")
+				}
+				if errResp.Type != tt.errorType {
+					t.Errorf("Expected error type '%s', got '%s'", tt.errorType, errResp.Type)
+				}
+				return
+			}
+
+			if errResp != nil {
+				t.Fatalf("Unexpected error: %v", errResp)
+			}
+			if payload.SessionId != tt.sessionId {
+				t.Errorf("Expected session ID %v, got %v", tt.sessionId, payload.SessionId)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("Failed to parse the proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"Direct Peer", "203.0.113.9:5555", "", "203.0.113.9"},
+		{"Header From Untrusted Peer", "203.0.113.9:5555", "198.51.100.1", "203.0.113.9"},
+		{"Behind Trusted Proxy", "10.0.0.1:5555", "203.0.113.7", "203.0.113.7"},
+		{"Spoofed Hop Left Of Client", "10.0.0.1:5555", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"Chained Trusted Proxies", "10.0.0.1:5555", "203.0.113.7, 192.168.1.1, 10.0.0.2", "203.0.113.7"},
+		{"Malformed Hop", "10.0.0.1:5555", "not-an-ip", "10.0.0.1"},
+		{"Proxy Without Header", "192.168.1.1:5555", "", "192.168.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if ip := ClientIP(req, trustedProxies); ip != tt.want {
+				t.Errorf("Expected ip '%s', got '%s'", tt.want, ip)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if proxies, err := ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("Expected no proxies, got %v (%v)", proxies, err)
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid range to fail")
+	}
+
+	if _, err := ParseTrustedProxies("proxy.local"); err == nil {
+		t.Error("Expected a hostname to fail")
+	}
+}
+
 // JSON tests
 func TestWriteJSON(t *testing.T) {
 	tests := []struct {
@@ -341,6 +489,20 @@ func TestHash(t *testing.T) {
 	}
 }
 
+func TestHashToken(t *testing.T) {
+	hashed := HashToken("cak_secret")
+
+	if hashed != HashToken("cak_secret") {
+		t.Error("HashToken should be deterministic")
+	}
+	if hashed == HashToken("cak_other") {
+		t.Error("Different tokens should not share a hash")
+	}
+	if len(hashed) != 64 {
+		t.Errorf("Expected 64 hex characters, got %d", len(hashed))
+	}
+}
+
 // Benchmark tests
 func BenchmarkHash(b *testing.B) {
 	plainText := []byte("password123")
@@ -1,6 +1,8 @@
 package webserver
 
 import (
+	"chat_app/handlers"
+	"chat_app/utils"
 	"net/http"
 	"os"
 	"strings"
@@ -36,3 +38,60 @@ func getAllowedCors() string {
 
 	return origins
 }
+
+// Authenticate -> Verifies the auth token (or api key) once and stores its payload in the request context.
+// Api keys are only accepted when the route group has a scope, and must grant it
+func Authenticate(handler *handlers.Handler, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, _ := utils.AuthToken(r); handlers.IsAPIKey(token) {
+				if scope == "" {
+					utils.WriteError(w, http.StatusForbidden, "apiKeyScope", "api keys can not access this route")
+					return
+				}
+
+				payload, errResp := handler.AuthenticateAPIKey(token, handlers.RequiredScope(scope, r.Method))
+				if errResp != nil {
+					status := http.StatusUnauthorized
+					if errResp.Type == "apiKeyScope" {
+						status = http.StatusForbidden
+					}
+
+					utils.WriteError(w, status, errResp.Type, errResp.Detail)
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(utils.WithAuthPayload(r.Context(), payload)))
+				return
+			}
+
+			payload, errResp := utils.CheckAuth(r, handler.Paseto)
+			if errResp != nil {
+				utils.WriteError(w, http.StatusUnauthorized, errResp.Type, errResp.Detail)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(utils.WithAuthPayload(r.Context(), payload)))
+		})
+	}
+}
+
+// RequireAdmin -> Runs after Authenticate. Rejects users that are not configured as admins
+func RequireAdmin(handler *handlers.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload, ok := utils.AuthPayload(r.Context())
+			if !ok {
+				utils.WriteError(w, http.StatusUnauthorized, utils.NoAuthPayload, "request is not authenticated")
+				return
+			}
+
+			if !handler.IsAdmin(payload.UserId) {
+				utils.WriteError(w, http.StatusForbidden, "adminOnly", "only admins can access this route")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package webserver
+
+import (
+	"chat_app/handlers"
+	"chat_app/paseto"
+	"chat_app/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func setupAuthHandler(t *testing.T) *handlers.Handler {
+	os.Setenv("PASETO_SYMMETRIC_KEY", "test-symmetric-key-for-testing-only")
+	t.Cleanup(func() { os.Unsetenv("PASETO_SYMMETRIC_KEY") })
+
+	maker, err := paseto.New()
+	if err != nil {
+		t.Fatalf("Failed to create paseto maker: %v", err)
+	}
+
+	return &handlers.Handler{Paseto: maker}
+}
+
+func TestAuthenticate(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	userID := primitive.NewObjectID()
+	token, err := handler.Paseto.CreateToken(userID, "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	var gotPayload *paseto.Payload
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPayload, _ = utils.AuthPayload(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	protected := Authenticate(handler, "")(next)
+
+	t.Run("No Auth Cookie", func(t *testing.T) {
+		gotPayload = nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		w := httptest.NewRecorder()
+
+		protected.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+		if gotPayload != nil {
+			t.Error("Next handler should not be called without auth")
+		}
+	})
+
+	t.Run("Valid Bearer Token", func(t *testing.T) {
+		gotPayload = nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		protected.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if gotPayload == nil || gotPayload.UserId != userID {
+			t.Errorf("Expected payload for user %v in context, got %v", userID, gotPayload)
+		}
+	})
+
+	t.Run("Api Key On Route Without Scope", func(t *testing.T) {
+		gotPayload = nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer cak_ANYKEY")
+		w := httptest.NewRecorder()
+
+		protected.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+		if gotPayload != nil {
+			t.Error("Next handler should not be called for an api key without scope")
+		}
+	})
+
+	t.Run("Valid Auth Cookie", func(t *testing.T) {
+		gotPayload = nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_cookie", Value: token})
+		w := httptest.NewRecorder()
+
+		protected.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if gotPayload == nil || gotPayload.UserId != userID {
+			t.Errorf("Expected payload for user %v in context, got %v", userID, gotPayload)
+		}
+	})
+}
+
+func TestRequireAdmin(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	admin := primitive.NewObjectID()
+	handler.Admins = []primitive.ObjectID{admin}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	protected := Authenticate(handler, "")(RequireAdmin(handler)(next))
+
+	tests := []struct {
+		name     string
+		userId   primitive.ObjectID
+		expected int
+	}{
+		{"Admin", admin, http.StatusOK},
+		{"Regular User", primitive.NewObjectID(), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := handler.Paseto.CreateToken(tt.userId, "testuser", time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to create token: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			protected.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestRouteGroupsAccess(t *testing.T) {
+	handler := setupAuthHandler(t)
+	router := NewRouter(handler)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		apiKey   bool
+		expected int
+	}{
+		{"Authenticated route without cookie", "GET", "/api/user/search?q=test", false, http.StatusUnauthorized},
+		{"Auth check without cookie", "GET", "/api/auth-check", false, http.StatusUnauthorized},
+		{"Websocket route without cookie", "GET", "/api/websocket", false, http.StatusUnauthorized},
+		{"Api key on user route", "GET", "/api/user/api-keys", true, http.StatusForbidden},
+		{"Api key on websocket route", "GET", "/api/websocket", true, http.StatusForbidden},
+		{"Admin route without cookie", "POST", "/api/admin/users/reset-token/123", false, http.StatusUnauthorized},
+		{"Public route", "GET", "/api/logout", false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey {
+				req.Header.Set("Authorization", "Bearer cak_ANYKEY")
+			}
+			w := httptest.NewRecorder()
+
+			router.CoreRouter.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+// readOnlyGetRoutes -> GET routes that api keys with only the read scope can call. They must not change
+// anything, routes with side effects use another method so they need the write scope
+var readOnlyGetRoutes = []string{
+	"/chat/get/{chat_id}/messages",
+	"/group/get/{group_id}/messages",
+	"/chat/get/{chat_id}/pins",
+	"/group/get/{group_id}/pins",
+	"/message/history/{message_id}",
+	"/message/thread/{message_id}",
+	"/group/get/{group_id}/members",
+	"/save-message/get",
+	"/received-approvals/get/",
+	"/sent-approvals/get/",
+}
+
+func TestScopedGetRoutesAreReadOnly(t *testing.T) {
+	for _, group := range routeGroups {
+		if group.scope == "" {
+			continue
+		}
+
+		router := chi.NewRouter()
+		group.routes(router, &handlers.Handler{})
+
+		err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			if (method == http.MethodGet || method == http.MethodHead) && !slices.Contains(readOnlyGetRoutes, route) {
+				t.Errorf("%s %s of %q needs only %s, add it to readOnlyGetRoutes if it has no side effects",
+					method, route, group.name, handlers.RequiredScope(group.scope, method))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("Failed to walk the routes of %q: %v", group.name, err)
+		}
+	}
+}
+
+func TestMountRouteGroupUndeclaredAccess(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected panic for route group without declared access")
+		}
+	}()
+
+	group := routeGroup{
+		name:   "undeclared",
+		routes: func(r chi.Router, handler *handlers.Handler) {},
+	}
+
+	mountRouteGroup(chi.NewRouter(), nil, group)
+}
@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"chat_app/handlers"
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -12,20 +13,47 @@ type Router struct {
 	CoreRouter *chi.Mux
 }
 
+// Access -> Every route group has to declare who can reach it
+type Access int
+
+const (
+	Undeclared Access = iota
+	Public
+	Authenticated
+	// Admin groups need a session of a user listed in ADMIN_USER_IDS
+	Admin
+)
+
+type routeGroup struct {
+	name   string
+	access Access
+	// scope is the api key resource of the group. Groups without one only accept session tokens
+	scope  string
+	routes func(r chi.Router, handler *handlers.Handler)
+}
+
+var routeGroups = []routeGroup{
+	{name: "auth", access: Public, routes: getAuthRoutes},
+	{name: "user", access: Authenticated, routes: getUserRoutes},
+	{name: "chat", access: Authenticated, scope: handlers.ScopeChats, routes: getChatRoutes},
+	{name: "message", access: Authenticated, scope: handlers.ScopeMessages, routes: getMessageRoutes},
+	{name: "group", access: Authenticated, scope: handlers.ScopeGroups, routes: getGroupRoutes},
+	{name: "save-message", access: Authenticated, scope: handlers.ScopeSavedMessages, routes: getSaveMessageRoutes},
+	{name: "secret-chat", access: Authenticated, routes: getSecretChatRoutes},
+	{name: "approval", access: Authenticated, scope: handlers.ScopeApprovals, routes: getApprovalRoutes},
+	{name: "websocket", access: Authenticated, routes: getWebsocketRoutes},
+	{name: "admin", access: Admin, routes: getAdminRoutes},
+}
+
 func NewRouter(handler *handlers.Handler) *Router {
 	routerInstance := chi.NewRouter()
 	routerInstance.Use(CheckCorsOrigin)
 	routerInstance.Use(middleware.Logger)
 
 	routerInstance.Route("/api", func(r chi.Router) {
-		getAuthRoutes(r, handler)
-		getUserRoutes(r, handler)
-		getChatRoutes(r, handler)
-		getMessageRoutes(r, handler)
-		getGroupRoutes(r, handler)
-		getSaveMessageRoutes(r, handler)
-		getSecretChatRoutes(r, handler)
-		getApprovalRoutes(r, handler)
+		for _, group := range routeGroups {
+			mountRouteGroup(r, handler, group)
+		}
 	})
 
 	fs := http.FileServer(http.Dir("./uploads"))
@@ -34,33 +62,82 @@ func NewRouter(handler *handlers.Handler) *Router {
 	return &Router{CoreRouter: routerInstance}
 }
 
+// mountRouteGroup -> Mounts the group behind the middleware matching its access. Panics on undeclared access
+func mountRouteGroup(r chi.Router, handler *handlers.Handler, group routeGroup) {
+	switch group.access {
+	case Public:
+		r.Group(func(r chi.Router) {
+			group.routes(r, handler)
+		})
+	case Authenticated:
+		r.Group(func(r chi.Router) {
+			r.Use(Authenticate(handler, group.scope))
+			group.routes(r, handler)
+		})
+	case Admin:
+		r.Group(func(r chi.Router) {
+			r.Use(Authenticate(handler, ""))
+			r.Use(RequireAdmin(handler))
+			group.routes(r, handler)
+		})
+	default:
+		panic(fmt.Sprintf("route group %q does not declare its access", group.name))
+	}
+}
+
 func getAuthRoutes(r chi.Router, handler *handlers.Handler) {
 	r.Post("/register", handler.Register)
 	r.Post("/login", handler.Login)
+	r.Post("/login/2fa", handler.LoginTwoFactor)
+	r.Post("/password/reset", handler.ResetPassword)
+	r.Get("/paseto/public-keys", handler.GetPasetoPublicKeys)
+	r.Post("/refresh", handler.Refresh)
 	r.Get("/logout", handler.Logout)
-	r.Get("/auth-check", handler.AuthCheck)
 }
 
 func getUserRoutes(r chi.Router, handler *handlers.Handler) {
+	r.Get("/auth-check", handler.AuthCheck)
 	r.Get("/user/search", handler.SearchUser)
 	r.Get("/user/get/{user_id}", handler.GetUser)
+	r.Get("/user/presence/{user_id}", handler.GetUserPresence)
+	r.Put("/user/privacy", handler.UpdatePrivacy)
 	r.Delete("/user/delete", handler.DeleteUser)
+	r.Put("/user/change-password", handler.ChangePassword)
 	r.Post("/user/upload-avatar", handler.UploadAvatar)
 	r.Get("/user/get-chats", handler.GetUserChats)
 	r.Get("/user/get-secret-chats", handler.GetUserSecretChats)
 	r.Get("/user/get-groups", handler.GetUserGroups)
+	r.Get("/user/sessions/get", handler.GetUserSessions)
+	r.Delete("/user/sessions/revoke/{session_id}", handler.RevokeSession)
+	r.Delete("/user/sessions/revoke-all", handler.RevokeAllSessions)
+	r.Get("/user/api-keys", handler.GetAPIKeys)
+	r.Post("/user/api-keys", handler.CreateAPIKey)
+	r.Delete("/user/api-keys/{api_key_id}", handler.RevokeAPIKey)
+	r.Post("/user/2fa/enroll", handler.EnrollTwoFactor)
+	r.Post("/user/2fa/confirm", handler.ConfirmTwoFactor)
+	r.Post("/user/2fa/disable", handler.DisableTwoFactor)
 }
 
 func getChatRoutes(r chi.Router, handler *handlers.Handler) {
 	r.Post("/chat/create", handler.CreateChat)
 	r.Post("/chat/upload/{chat_id}/{receiver_id}", handler.UploadChatImage)
-	r.Get("/chat/get/{chat_id}/messages", handler.GetChatMessages)
 	r.Delete("/chat/delete/{chat_id}", handler.DeleteChat)
-	r.Get("/websocket/chat/add/{chat_id}", handler.AddChatWebsocket)
 }
 
 func getMessageRoutes(r chi.Router, handler *handlers.Handler) {
+	r.Get("/chat/get/{chat_id}/messages", handler.GetChatMessages)
+	r.Get("/group/get/{group_id}/messages", handler.GetGroupMessages)
+	r.Get("/chat/get/{chat_id}/pins", handler.GetChatPins)
+	r.Get("/group/get/{group_id}/pins", handler.GetGroupPins)
 	r.Put("/message/update/{message_id}", handler.EditMessage)
+	r.Get("/message/history/{message_id}", handler.GetMessageHistory)
+	r.Get("/message/thread/{message_id}", handler.GetMessageThread)
+	r.Post("/message/reactions/{message_id}", handler.AddReaction)
+	r.Delete("/message/reactions/{message_id}", handler.RemoveReaction)
+	r.Post("/message/pin/{message_id}", handler.PinMessage)
+	r.Delete("/message/pin/{message_id}", handler.UnpinMessage)
+	r.Put("/message/pins/order", handler.ReorderPins)
+	r.Put("/message/read/{message_id}", handler.MarkRead)
 	r.Post("/message/upload-chat-image/{chat_id}", handler.UploadImageChatMessage)
 	r.Post("/message/upload-group-image/{group_id}", handler.UploadImageGroupMessage)
 	r.Delete("/message/delete/sender/{message_id}", handler.DeleteMessageForSender)
@@ -70,15 +147,13 @@ func getMessageRoutes(r chi.Router, handler *handlers.Handler) {
 func getGroupRoutes(r chi.Router, handler *handlers.Handler) {
 	r.Post("/group/create", handler.CreateGroup)
 	r.Put("/group/update/{group_id}", handler.UpdateGroup)
-	r.Get("/group/join/{invite_link}", handler.JoinGroup)
+	r.Post("/group/join/{invite_link}", handler.JoinGroup)
 	r.Post("/group/ban/{group_id}", handler.BanMemberFromGroup)
 	r.Post("/group/unban/{group_id}", handler.UnBanMemberFromGroup)
-	r.Get("/group/get/{group_id}/messages", handler.GetGroupMessages)
 	r.Get("/group/get/{group_id}/members", handler.GetGroupMembers)
 	r.Delete("/group/leave/{group_id}", handler.LeaveGroup)
 	r.Delete("/group/remove-user/{group_id}/{user_id}", handler.RemoveUserFromGroup)
 	r.Delete("/group/delete/{group_id}", handler.DeleteGroup)
-	r.Get("/websocket/group/add/{group_id}", handler.AddGroupWebsocket)
 }
 
 func getSaveMessageRoutes(r chi.Router, handler *handlers.Handler) {
@@ -92,11 +167,11 @@ func getSecretChatRoutes(r chi.Router, handler *handlers.Handler) {
 	r.Get("/secret-chat/get/{secret_chat_id}", handler.GetSecretChat)
 	r.Post("/secret-chat/create", handler.CreateSecretChat)
 	r.Get("/secret-chat/get/{secret_chat_id}/messages", handler.GetSecretChatMessages)
+	r.Get("/secret-chat/get/{secret_chat_id}/pins", handler.GetSecretChatPins)
 	r.Delete("/secret-chat/delete/{secret_chat_id}", handler.DeleteSecretChat)
 	r.Post("/secret-chat/add-public-key/{secret_chat_id}", handler.UploadSecretChatPublicKey)
 	r.Post("/secret-chat/add-symmetric-key/{secret_chat_id}", handler.UploadSecretChatSymmetricKey)
 	r.Post("/secret-chat/approve/{secret_chat_id}", handler.ApproveSecretChat)
-	r.Get("/websocket/secret-chat/add/{secret_chat_id}", handler.AddSecretChatWebsocket)
 }
 
 func getApprovalRoutes(r chi.Router, handler *handlers.Handler) {
@@ -106,3 +181,12 @@ func getApprovalRoutes(r chi.Router, handler *handlers.Handler) {
 	r.Put("/approvals/edit-status/{approval_id}", handler.EditApprovalStatus)
 	r.Delete("/approvals/delete/{approval_id}", handler.DeleteApproval)
 }
+
+// getWebsocketRoutes -> Live connections belong to browser sessions, so api keys are not accepted here
+func getWebsocketRoutes(r chi.Router, handler *handlers.Handler) {
+	r.Get("/websocket", handler.OpenWebsocket)
+}
+
+func getAdminRoutes(r chi.Router, handler *handlers.Handler) {
+	r.Post("/admin/users/reset-token/{user_id}", handler.IssuePasswordResetToken)
+}
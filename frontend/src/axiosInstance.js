@@ -10,12 +10,30 @@ const axiosInstance = axios.create({
     },
 });
 
+let refreshPromise = null;
+
 // Response interceptor to handle authentication errors
 axiosInstance.interceptors.response.use(
     (response) => {
         return response;
     },
-    (error) => {
+    async (error) => {
+        const original = error.config;
+
+        // Access tokens are short-lived: try one silent refresh before giving up
+        if (error.response?.status === 401 && original && !original._retried && original.url !== "/api/refresh") {
+            original._retried = true;
+            try {
+                refreshPromise = refreshPromise || axiosInstance.post("/api/refresh");
+                await refreshPromise;
+                return axiosInstance(original);
+            } catch (refreshError) {
+                // fall through to the redirect below
+            } finally {
+                refreshPromise = null;
+            }
+        }
+
         // Handle 401 Unauthorized or noAuthCookie errors
         if (error.response?.status === 401 || error.response?.data?.error === "noAuthCookie") {
             // Redirect to auth page if unauthorized or no auth cookie is found
@@ -5,12 +5,15 @@ import { useGroupStore } from '../stores/groups';
 import { useUserStore } from '../stores/users';
 import { useSecretGroupE2EE } from './useSecretGroupE2EE';
 import { useKeyPair } from './useKeyPair';
+import { connectSocket, roomFor, sendToRoom, subscribeRoom, trackRoomCursor, unsubscribeRoom, useWebSocket } from './useWebSocket';
 
-let groupSocket = null;
+let groupRoom = null;
+let groupRoomHandler = null;
 let groupUsers = ref({});
 
 export function useGroupChat() {
     const groupStore = useGroupStore();
+    const { getConnectionStatus } = useWebSocket();
     const userStore = useUserStore();
     const { 
         encryptGroupMessage, 
@@ -39,10 +42,10 @@ export function useGroupChat() {
     const currentPage = ref(1);
     const pageLimit = ref(20);
 
-    // Establish group WebSocket connection
+    // Route the frames of the group to the callback, over the shared socket of the user
     const establishGroupConnection = (groupData, onMessageCallback, isSecretGroup = false) => {
-        console.log("🔌 Establishing group WebSocket connection with data:", groupData, "isSecretGroup:", isSecretGroup);
-        
+        console.log("🔌 Subscribing to group with data:", groupData, "isSecretGroup:", isSecretGroup);
+
         const { groupId, senderId, backendBaseUrl } = groupData;
 
         if (!groupId || !senderId || !backendBaseUrl) {
@@ -50,130 +53,58 @@ export function useGroupChat() {
             return false;
         }
 
-        // Check if we already have a connection to this group
-        if (groupSocket && groupSocket.readyState === WebSocket.OPEN) {
-            console.log("🔌 Group WebSocket already connected for group:", groupId);
-            return true;
-        }
-
-        // Prevent multiple simultaneous connection attempts
-        if (isConnecting.value) {
-            console.log("🔌 Connection already in progress, skipping...");
-            return false;
-        }
+        closeGroupConnection();
 
-        isConnecting.value = true;
-        
         // Store connection data for reconnection
         currentGroupData.value = { ...groupData, isSecretGroup };
         currentMessageCallback.value = onMessageCallback;
 
-        // Close existing group connection if any
-        if (groupSocket) {
-            console.log("🔌 Closing existing group WebSocket connection");
-            groupSocket.close();
-            groupSocket = null;
-        }
-
-        // Use WebSocket URL for groups (both regular and secret)
-        const wsUrl = `${backendBaseUrl.replace(/^http/, "ws")}/api/websocket/group/add/${groupId}?sender_id=${senderId}&is_secret=${isSecretGroup}`;
-        console.log("🔌 Creating group WebSocket connection to:", wsUrl);
-        
-        try {
-            groupSocket = new WebSocket(wsUrl, [], {
-                credentials: 'include'
-            });
-
-            // Add connection timeout
-            const connectionTimeout = setTimeout(() => {
-                if (groupSocket && groupSocket.readyState !== WebSocket.OPEN) {
-                    console.error("🔌 Group WebSocket connection timeout");
-                    groupSocket.close();
-                    isGroupConnected.value = false;
-                    isConnecting.value = false;
-                }
-            }, 10000); // 10 second timeout
-
-            groupSocket.onopen = () => {
-                console.log("🔌 Group WebSocket connected for group:", groupId);
-                clearTimeout(connectionTimeout);
-                isGroupConnected.value = true;
-                isConnecting.value = false;
-            };
-
-            groupSocket.onmessage = (event) => {
-                console.log("📨 Received group WebSocket message:", event.data);
-                try {
-                    const data = JSON.parse(event.data);
-                    console.log("📨 Parsed group WebSocket message:", data);
-                    console.log("📨 Message type:", data.type || 'unknown');
-                    console.log("📨 Message content:", data.content ? 'present' : 'missing');
-                    if (onMessageCallback) {
-                        onMessageCallback(data);
-                    }
-                } catch (error) {
-                    console.error("Error parsing group WebSocket message:", error);
-                    // Try to handle as plain text if JSON parsing fails
-                    if (onMessageCallback) {
-                        onMessageCallback({ content: event.data });
-                    }
-                }
-            };
+        groupRoom = roomFor({ groupId, isGroupChat: true });
+        groupRoomHandler = (data) => {
+            if (onMessageCallback) {
+                onMessageCallback(data);
+            }
+        };
+        subscribeRoom(groupRoom, groupRoomHandler);
 
-                                        groupSocket.onclose = (event) => {
-                console.log("🔌 Group WebSocket closed for group:", groupId, "Code:", event.code, "Reason:", event.reason);
-                clearTimeout(connectionTimeout);
-                isGroupConnected.value = false;
-                isConnecting.value = false;
-                groupSocket = null;
-            };
+        const socket = connectSocket(backendBaseUrl);
+        if (socket.readyState === WebSocket.OPEN) {
+            isGroupConnected.value = true;
+            return true;
+        }
 
-            groupSocket.onerror = (error) => {
-                console.error("🔌 Group WebSocket error:", error);
-                console.error("🔌 Error details:", {
-                    message: error.message,
-                    type: error.type,
-                    target: error.target
-                });
-                clearTimeout(connectionTimeout);
-                isGroupConnected.value = false;
-                isConnecting.value = false;
-            };
-        } catch (error) {
-            console.error("🔌 Failed to create WebSocket connection:", error);
+        isConnecting.value = true;
+        socket.addEventListener("open", () => {
+            isGroupConnected.value = true;
+            isConnecting.value = false;
+        }, { once: true });
+        socket.addEventListener("close", () => {
             isGroupConnected.value = false;
             isConnecting.value = false;
-        }
+        }, { once: true });
+
+        return true;
     };
 
     // Send group message with encryption for secret groups
     const sendGroupMessage = async (messageData, groupId, isSecretGroup = false) => {
         console.log("📤 Attempting to send group message:", messageData);
-        console.log("🔌 Group WebSocket state:", groupSocket ? groupSocket.readyState : "null");
+        console.log("🔌 Group WebSocket state:", getGroupConnectionStatus().readyState);
         console.log("🔐 Is secret group:", isSecretGroup);
         
         // Check connection status and retry if needed
-        if (!groupSocket || groupSocket.readyState !== WebSocket.OPEN) {
+        if (!groupRoom || getGroupConnectionStatus().readyState !== WebSocket.OPEN) {
             console.log("🔌 Group WebSocket not connected, attempting to reconnect...");
-            
+
             // Try to reconnect if we have the necessary data
             if (currentGroupData.value) {
-                const reconnectSuccess = await establishGroupConnection(
-                    currentGroupData.value,
-                    currentMessageCallback.value,
-                    isSecretGroup
-                );
-                
-                if (!reconnectSuccess) {
-                    console.error("🔌 Failed to reconnect WebSocket");
-                    return false;
-                }
-                
+                establishGroupConnection(currentGroupData.value, currentMessageCallback.value, isSecretGroup);
+
                 // Wait a bit for connection to stabilize
                 await new Promise(resolve => setTimeout(resolve, 500));
-                
+
                 // Check again after reconnection
-                if (!groupSocket || groupSocket.readyState !== WebSocket.OPEN) {
+                if (getGroupConnectionStatus().readyState !== WebSocket.OPEN) {
                     console.error("🔌 WebSocket still not connected after reconnection attempt");
                     return false;
                 }
@@ -235,8 +166,7 @@ export function useGroupChat() {
 
             console.log("📤 Sending group WebSocket message:", finalMessageData);
             console.log("📤 Raw message being sent:", JSON.stringify(finalMessageData));
-            console.log("📤 WebSocket ready state:", groupSocket.readyState);
-            console.log("📤 WebSocket URL:", groupSocket.url);
+            console.log("📤 WebSocket room:", groupRoom);
             
             // Add detailed logging for secret group messages
             if (isSecretGroup) {
@@ -248,13 +178,15 @@ export function useGroupChat() {
             
             try {
                 // Send the message in the exact format the Go backend expects
-                const messageToSend = JSON.stringify({
-                    sender_id: messageData.sender_id,
+                // the server adds the sender from the session
+                const sent = sendToRoom(groupRoom, {
                     content: messageData.content, // Just the message text, not the whole object
                     content_address: "",
                     content_type: "text"
                 });
-                groupSocket.send(messageToSend);
+                if (!sent) {
+                    throw new Error("group WebSocket is not connected");
+                }
                 console.log("✅ Group message sent successfully");
             } catch (sendError) {
                 console.error("❌ Error sending WebSocket message:", sendError);
@@ -267,21 +199,23 @@ export function useGroupChat() {
         }
     };
 
-    // Close group connection
+    // Stop routing frames of the group, the shared socket stays open
     const closeGroupConnection = () => {
-        if (groupSocket) {
-            groupSocket.close();
-            groupSocket = null;
+        if (groupRoom) {
+            unsubscribeRoom(groupRoom, groupRoomHandler);
+            groupRoom = null;
+            groupRoomHandler = null;
             isGroupConnected.value = false;
         }
     };
 
     const getGroupConnectionStatus = () => {
+        const status = getConnectionStatus();
+
         return {
-            isConnected: groupSocket ? groupSocket.readyState === WebSocket.OPEN : false,
+            isConnected: Boolean(groupRoom) && status.readyState === WebSocket.OPEN,
             isConnecting: isConnecting.value,
-            readyState: groupSocket ? groupSocket.readyState : null,
-            socket: groupSocket
+            readyState: status.readyState,
         };
     };
 
@@ -427,6 +361,8 @@ export function useGroupChat() {
             const messagesArray = response.data?.messages || response.data || [];
             
             if (Array.isArray(messagesArray)) {
+                trackRoomCursor(roomFor({ groupId, isGroupChat: true }), messagesArray);
+
                 console.log('📥 Processing', messagesArray.length, 'regular group messages');
                 // Transform API messages to our format
                 const transformedMessages = messagesArray.map((msg, index) => {
@@ -504,6 +440,8 @@ export function useGroupChat() {
             const messagesArray = response.data?.messages || response.data || [];
             
             if (Array.isArray(messagesArray)) {
+                trackRoomCursor(roomFor({ groupId, isGroupChat: true }), messagesArray);
+
                 console.log('📥 Processing', messagesArray.length, 'secret group messages');
                 // Transform API messages to our format with decryption
                 const transformedMessages = await Promise.all(messagesArray.map(async (msg, index) => {
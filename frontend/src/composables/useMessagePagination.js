@@ -3,6 +3,7 @@ import { useChatStore } from "../stores/chat";
 import { useE2EE } from "./useE2EE";
 import { useSecretChatEncryption } from "./useSecretChatEncryption";
 import axiosInstance from "../axiosInstance";
+import { roomFor, trackRoomCursor } from "./useWebSocket";
 
 export function useMessagePagination() {
     const chatStore = useChatStore();
@@ -59,6 +60,8 @@ export function useMessagePagination() {
             const hasMore = rawMessages.length >= limit;
             const totalPages = Math.ceil(rawMessages.length / limit) || 1;
 
+            trackRoomCursor(roomFor({ chatId }), messages);
+
             // Update store with new messages
             chatStore.setMessages(messages, page === 1);
             chatStore.setPaginationState(page, hasMore, false);
@@ -272,6 +275,8 @@ export function useMessagePagination() {
             const totalPages = Math.ceil(rawMessages.length / limit) || 1;
 
             // Update store with decrypted messages
+            trackRoomCursor(roomFor({ chatId: secretChatId, isSecretChat: true }), messages);
+
             chatStore.setMessages(decryptedMessages, page === 1);
             chatStore.setPaginationState(page, hasMore, false);
 
@@ -1,128 +1,452 @@
-import { ref } from "vue";
+import { reactive, ref } from "vue";
+import { useUserStore } from "../stores/users";
+import axiosInstance from "../axiosInstance";
 
-let currentSocket = null;
+// One socket per device, the server subscribes it to all of the user's chats and groups.
+// Every frame is an envelope {v, type, id, room, data}. The room ("chat:<id>", "secret_chat:<id>"
+// or "group:<id>") routes it
+const PROTOCOL_VERSION = 1;
 
-export function useWebSocket() {
-    const isConnected = ref(false);
+const RECONNECT_DELAY_MS = 2000;
+// sync.done is pending while a message before the ones synced is still being stored
+const SYNC_RETRY_DELAY_MS = 2000;
+// Close codes of the server: the access token expired, or the session was revoked
+const CLOSE_TOKEN_EXPIRED = 4001;
+const CLOSE_SESSION_ENDED = 4003;
+// typing.start is sent again while typing, the server forwards at most one per 3s
+const TYPING_INTERVAL_MS = 3000;
+const TYPING_TIMEOUT_MS = 3 * TYPING_INTERVAL_MS;
 
-    // Establish WebSocket connection
-    const establishConnection = (chatData, onMessageCallback) => {
-        console.log("Establishing WebSocket connection with data:", chatData);
-        
-        // Close existing connection if any
-        if (currentSocket) {
-            console.log("Closing existing WebSocket connection");
-            currentSocket.close();
-            currentSocket = null;
-            // Wait a bit for the connection to close
-            setTimeout(() => {
-                createNewConnection(chatData, onMessageCallback);
-            }, 100);
-        } else {
-            createNewConnection(chatData, onMessageCallback);
+let sharedSocket = null;
+let socketUrl = null;
+let reconnectTimer = null;
+const roomHandlers = new Map();
+// Last seq received per room, sent in a sync after a reconnect so nothing is missed or repeated
+const roomCursors = new Map();
+// Sends not acked yet by client_message_id, sent again after a reconnect. The server stores each once
+const pendingSends = new Map();
+const socketConnected = ref(false);
+// Status and last seen of chat partners and group members by user id, from presence frames
+export const userPresence = reactive({});
+// Users typing per room: room id -> user id -> username
+export const typingUsers = reactive({});
+const typingTimers = new Map();
+// Listeners of reaction.add and reaction.remove, called with the room and the data
+const reactionListeners = new Set();
+// Listeners of pins.update, called with the room and the data
+const pinListeners = new Set();
+// Seq the other participant of each 1:1 chat has read up to: room id -> seq. Messages up to it are seen
+export const readSeqs = reactive({});
+const lastTypingSent = new Map();
+
+// Device id sent in the handshake, so a reconnect replaces this device's old socket
+// instead of counting as one more device
+function getDeviceId() {
+    let deviceId = localStorage.getItem("device_id");
+    if (!deviceId) {
+        deviceId = crypto.randomUUID();
+        localStorage.setItem("device_id", deviceId);
+    }
+
+    return deviceId;
+}
+
+// Room id of a chat, secret chat or group
+export function roomFor({ chatId, groupId, isSecretChat, isGroupChat }) {
+    if (isGroupChat && groupId) {
+        return `group:${groupId}`;
+    }
+
+    return isSecretChat ? `secret_chat:${chatId}` : `chat:${chatId}`;
+}
+
+// Opens the shared socket, or returns the one that is open or connecting already
+export function connectSocket(backendBaseUrl) {
+    if (sharedSocket && (sharedSocket.readyState === WebSocket.OPEN || sharedSocket.readyState === WebSocket.CONNECTING)) {
+        return sharedSocket;
+    }
+
+    const wsUrl = `${backendBaseUrl.replace(/^http/, "ws")}/api/websocket?device_id=${encodeURIComponent(getDeviceId())}`;
+    console.log("Creating WebSocket connection to:", wsUrl);
+
+    const socket = new WebSocket(wsUrl);
+    sharedSocket = socket;
+    socketUrl = backendBaseUrl;
+
+    socket.onopen = () => {
+        console.log("WebSocket connected");
+        socketConnected.value = true;
+        syncRooms();
+        resendPending();
+        sendPresence();
+    };
+
+    socket.onmessage = (event) => {
+        let envelope;
+        try {
+            envelope = JSON.parse(event.data);
+        } catch (error) {
+            console.error("Error parsing WebSocket message:", error);
+            return;
+        }
+
+        switch (envelope.type) {
+            case "message.new": {
+                const seq = envelope.data?.seq || 0;
+                if (seq > 0) {
+                    // already received, e.g. live and again in a sync
+                    if (seq <= (roomCursors.get(envelope.room) || 0)) {
+                        break;
+                    }
+                    roomCursors.set(envelope.room, seq);
+                }
+
+                const handler = roomHandlers.get(envelope.room);
+                if (handler) {
+                    handler(envelope.data);
+                }
+                break;
+            }
+            case "sync.done":
+                if (envelope.data?.has_more) {
+                    syncRooms();
+                } else if (envelope.data?.pending) {
+                    setTimeout(syncRooms, SYNC_RETRY_DELAY_MS);
+                }
+                break;
+            case "room.removed":
+                // kicked, banned, left or deleted: the server sends nothing more for this room
+                console.log("Removed from room:", envelope.room, envelope.data);
+                roomHandlers.delete(envelope.room);
+                roomCursors.delete(envelope.room);
+                break;
+            case "reaction.add":
+            case "reaction.remove":
+                for (const listener of reactionListeners) {
+                    listener(envelope.room, { ...envelope.data, added: envelope.type === "reaction.add" });
+                }
+                break;
+            case "message.read":
+                // our own devices read the room as well, only the partner's reads mean "seen"
+                if (envelope.data.user_id !== useUserStore().user_id) {
+                    readSeqs[envelope.room] = Math.max(readSeqs[envelope.room] || 0, envelope.data.seq);
+                }
+                break;
+            case "pins.update":
+                for (const listener of pinListeners) {
+                    listener(envelope.room, envelope.data);
+                }
+                break;
+            case "typing.start":
+            case "typing.stop":
+                setTyping(envelope.room, envelope.data, envelope.type === "typing.start");
+                break;
+            case "presence":
+                if (envelope.data?.user_id) {
+                    userPresence[envelope.data.user_id] = {
+                        status: envelope.data.status,
+                        last_seen_at: envelope.data.last_seen_at || null,
+                    };
+                }
+                break;
+            case "message.ack": {
+                console.log("Message stored:", envelope.id, envelope.data);
+                pendingSends.delete(envelope.data?.client_message_id);
+                // our own messages aren't sent back live, skip them in the next sync when nothing is in between
+                const seq = envelope.data?.seq || 0;
+                if (seq > 0 && seq === (roomCursors.get(envelope.room) || 0) + 1) {
+                    roomCursors.set(envelope.room, seq);
+                }
+                break;
+            }
+            case "error":
+                console.error("WebSocket error frame:", envelope.id, envelope.data);
+                // sending to this room again won't help
+                if (["unknownRoom", "forbidden", "invalidData"].includes(envelope.data?.code)) {
+                    for (const [clientMessageId, pending] of pendingSends) {
+                        if (pending.roomId === envelope.room) {
+                            pendingSends.delete(clientMessageId);
+                        }
+                    }
+                }
+                break;
+            default:
+                console.log("Unhandled WebSocket event:", envelope.type);
         }
     };
 
-    const createNewConnection = (chatData, onMessageCallback) => {
-        const { chatId, senderId, receiverId, backendBaseUrl, isSecretChat, isGroupChat, groupId } = chatData;
+    socket.onclose = (event) => {
+        console.log("WebSocket closed. Code:", event.code, "Reason:", event.reason);
+        if (sharedSocket !== socket) {
+            return;
+        }
 
-        if (!chatId || !senderId || !backendBaseUrl) {
-            console.error("Missing required data for WebSocket connection:", { chatId, senderId, backendBaseUrl });
+        sharedSocket = null;
+        socketConnected.value = false;
+
+        // logged out or the password changed, reconnecting would only be refused
+        if (event.code === CLOSE_SESSION_ENDED) {
             return;
         }
 
-        // Use different WebSocket URL based on chat type
-        let wsUrl;
-        if (isGroupChat && groupId) {
-            wsUrl = `${backendBaseUrl.replace(/^http/, "ws")}/api/websocket/group/add/${groupId}?sender_id=${senderId}`;
-        } else if (isSecretChat) {
-            wsUrl = `${backendBaseUrl.replace(/^http/, "ws")}/api/websocket/secret-chat/add/${chatId}?sender_id=${senderId}&receiver_id=${receiverId}`;
-        } else {
-            wsUrl = `${backendBaseUrl.replace(/^http/, "ws")}/api/websocket/chat/add/${chatId}?sender_id=${senderId}&receiver_id=${receiverId}`;
+        if (event.code === CLOSE_TOKEN_EXPIRED) {
+            // a failed refresh redirects to the login page
+            axiosInstance.post("/api/refresh").then(scheduleReconnect, () => {});
+            return;
         }
 
-        console.log("Creating WebSocket connection to:", wsUrl);
-        currentSocket = new WebSocket(wsUrl, [], {
-            credentials: 'include'
-        });
+        scheduleReconnect();
+    };
 
-        currentSocket.onopen = () => {
-            console.log("WebSocket connected for chat:", chatId);
-            isConnected.value = true;
-        };
+    socket.onerror = (error) => {
+        console.error("WebSocket error:", error);
+    };
 
-        currentSocket.onmessage = (event) => {
-            console.log("📨 Received WebSocket message:", event.data);
-            try {
-                const data = JSON.parse(event.data);
-                if (onMessageCallback) {
-                    onMessageCallback(data);
-                }
-            } catch (error) {
-                console.error("Error parsing WebSocket message:", error);
-                // Try to handle as plain text if JSON parsing fails
-                if (onMessageCallback) {
-                    onMessageCallback({ content: event.data });
-                }
-            }
-        };
+    return socket;
+}
 
-        currentSocket.onclose = (event) => {
-            console.log("WebSocket closed for chat:", chatId, "Code:", event.code, "Reason:", event.reason);
-            isConnected.value = false;
-            currentSocket = null;
-        };
+// Reconnects after a dropped connection, the sync on open fetches what was missed
+function scheduleReconnect() {
+    if (reconnectTimer || !socketUrl || roomHandlers.size === 0) {
+        return;
+    }
+
+    reconnectTimer = setTimeout(() => {
+        reconnectTimer = null;
+        if (socketUrl) {
+            connectSocket(socketUrl);
+        }
+    }, RECONNECT_DELAY_MS);
+}
+
+// Asks for the messages after the last seq of every room received so far
+function syncRooms() {
+    if (roomCursors.size === 0) {
+        return;
+    }
+
+    sendEvent("sync", "", { cursors: Object.fromEntries(roomCursors) });
+}
+
+// Starts the cursor of a room from messages loaded over http, so a reconnect syncs from there
+export function trackRoomCursor(roomId, messages) {
+    for (const message of messages) {
+        if ((message?.seq || 0) > (roomCursors.get(roomId) || 0)) {
+            roomCursors.set(roomId, message.seq);
+        }
+    }
+}
+
+function resendPending() {
+    for (const { roomId, message } of pendingSends.values()) {
+        sendEvent("message.send", roomId, message);
+    }
+}
+
+// Shows or hides a typing user, dropped after TYPING_TIMEOUT_MS without another start
+function setTyping(roomId, data, isTyping) {
+    if (!roomId || !data?.user_id) {
+        return;
+    }
+
+    const timerKey = `${roomId}/${data.user_id}`;
+    clearTimeout(typingTimers.get(timerKey));
+    typingTimers.delete(timerKey);
+
+    if (!isTyping) {
+        if (typingUsers[roomId]) {
+            delete typingUsers[roomId][data.user_id];
+        }
+        return;
+    }
+
+    typingUsers[roomId] = { ...typingUsers[roomId], [data.user_id]: data.username };
+    typingTimers.set(timerKey, setTimeout(() => setTyping(roomId, data, false), TYPING_TIMEOUT_MS));
+}
+
+// Tells the room the user is typing or stopped. Starts are sent at most once per TYPING_INTERVAL_MS
+export function sendTyping(roomId, isTyping) {
+    const now = Date.now();
+    const lastSent = lastTypingSent.get(roomId);
+
+    if (isTyping) {
+        if (lastSent && now - lastSent < TYPING_INTERVAL_MS) {
+            return;
+        }
+
+        lastTypingSent.set(roomId, now);
+        sendEvent("typing.start", roomId);
+        return;
+    }
+
+    if (lastSent) {
+        lastTypingSent.delete(roomId);
+        sendEvent("typing.stop", roomId);
+    }
+}
 
-        currentSocket.onerror = (error) => {
-            console.error("WebSocket error:", error);
-            isConnected.value = false;
+// Away while the tab is hidden, online otherwise
+function sendPresence() {
+    sendEvent("presence", "", { status: document.visibilityState === "hidden" ? "away" : "online" });
+}
+
+if (typeof document !== "undefined") {
+    document.addEventListener("visibilitychange", () => {
+        if (sharedSocket && sharedSocket.readyState === WebSocket.OPEN) {
+            sendPresence();
+        }
+    });
+}
+
+// Calls the listener for every reaction change, returns a function removing it
+export function onReaction(listener) {
+    reactionListeners.add(listener);
+    return () => reactionListeners.delete(listener);
+}
+
+// Adds or removes the user's reaction, every device in the room gets the change
+export function sendReaction(roomId, messageId, emoji, add = true) {
+    return sendEvent(add ? "reaction.add" : "reaction.remove", roomId, { message_id: messageId, emoji });
+}
+
+// Marks the room read up to the message. Chat partners see it as "seen", groups aren't told
+export function sendRead(roomId, messageId) {
+    return sendEvent("message.read", roomId, { message_id: messageId });
+}
+
+// Calls the listener whenever the pinned messages of a room change, returns a function removing it.
+// data.message_ids has the new order, pinning and unpinning go through the http endpoints
+export function onPinsUpdate(listener) {
+    pinListeners.add(listener);
+    return () => pinListeners.delete(listener);
+}
+
+// Frames of the room are passed to the handler, one handler per room
+export function subscribeRoom(roomId, handler) {
+    roomHandlers.set(roomId, handler);
+}
+
+export function unsubscribeRoom(roomId, handler) {
+    if (!handler || roomHandlers.get(roomId) === handler) {
+        roomHandlers.delete(roomId);
+    }
+}
+
+// Sends an event, returns false when the socket is not open
+export function sendEvent(type, roomId, data) {
+    if (!sharedSocket || sharedSocket.readyState !== WebSocket.OPEN) {
+        console.error("WebSocket is not connected. State:", sharedSocket ? sharedSocket.readyState : "null");
+        return false;
+    }
+
+    try {
+        sharedSocket.send(JSON.stringify({
+            v: PROTOCOL_VERSION,
+            type,
+            id: crypto.randomUUID(),
+            room: roomId,
+            data,
+        }));
+        return true;
+    } catch (error) {
+        console.error("Error sending message:", error);
+        return false;
+    }
+}
+
+// Sends a message to a room the server subscribed the socket to. It is kept until acked and sent again
+// after a reconnect, with the same client_message_id so it's stored once
+export function sendToRoom(roomId, message) {
+    const pending = { ...message, client_message_id: message.client_message_id || crypto.randomUUID() };
+    pendingSends.set(pending.client_message_id, { roomId, message: pending });
+
+    return sendEvent("message.send", roomId, pending);
+}
+
+// Closes the shared socket, e.g. on logout
+export function disconnectSocket() {
+    roomHandlers.clear();
+    roomCursors.clear();
+    pendingSends.clear();
+    lastTypingSent.clear();
+    socketUrl = null;
+    clearTimeout(reconnectTimer);
+    reconnectTimer = null;
+    if (sharedSocket) {
+        sharedSocket.close();
+        sharedSocket = null;
+        socketConnected.value = false;
+    }
+}
+
+export function useWebSocket() {
+    let activeRoom = null;
+    let activeHandler = null;
+
+    // Routes the frames of the chat to the callback, the socket stays shared
+    const establishConnection = (chatData, onMessageCallback) => {
+        const { chatId, groupId, senderId, backendBaseUrl, isGroupChat } = chatData;
+
+        if (!(chatId || (isGroupChat && groupId)) || !senderId || !backendBaseUrl) {
+            console.error("Missing required data for WebSocket connection:", { chatId, senderId, backendBaseUrl });
+            return;
+        }
+
+        closeConnection();
+
+        activeRoom = roomFor(chatData);
+        activeHandler = (data) => {
+            if (onMessageCallback) {
+                onMessageCallback(data);
+            }
         };
+
+        subscribeRoom(activeRoom, activeHandler);
+        connectSocket(backendBaseUrl);
     };
 
-    // Send message through WebSocket
+    // Send message through WebSocket to the active room
     const sendMessage = (messageData) => {
-        console.log("Attempting to send message:", messageData);
-        console.log("WebSocket state:", currentSocket ? currentSocket.readyState : "null");
-        
-        if (!currentSocket || currentSocket.readyState !== WebSocket.OPEN) {
-            console.error("WebSocket is not connected. State:", currentSocket ? currentSocket.readyState : "null");
+        if (!activeRoom) {
+            console.error("No chat is open");
             return false;
         }
 
-        try {
-            console.log("📤 Sending WebSocket message:", messageData);
-            currentSocket.send(messageData);
-            console.log("Message sent successfully");
-            return true;
-        } catch (error) {
-            console.error("Error sending message:", error);
-            return false;
+        const message = typeof messageData === "string" ? JSON.parse(messageData) : messageData;
+        return sendToRoom(activeRoom, message);
+    };
+
+    // Call on every input in the active room, and with false once the message is sent or cleared
+    const notifyTyping = (isTyping = true) => {
+        if (activeRoom) {
+            sendTyping(activeRoom, isTyping);
         }
     };
 
-    // Close current connection
+    // Stops routing frames of the active room, the shared socket stays open
     const closeConnection = () => {
-        if (currentSocket) {
-            currentSocket.close();
-            currentSocket = null;
-            isConnected.value = false;
+        if (activeRoom) {
+            unsubscribeRoom(activeRoom, activeHandler);
+            activeRoom = null;
+            activeHandler = null;
         }
     };
 
     // Get connection status
     const getConnectionStatus = () => {
         return {
-            isConnected: isConnected.value,
-            readyState: currentSocket
-                ? currentSocket.readyState
+            isConnected: socketConnected.value,
+            readyState: sharedSocket
+                ? sharedSocket.readyState
                 : WebSocket.CLOSED,
         };
     };
 
     return {
-        isConnected,
+        isConnected: socketConnected,
         establishConnection,
         sendMessage,
+        notifyTyping,
         closeConnection,
         getConnectionStatus,
     };